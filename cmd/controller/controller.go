@@ -1,65 +1,146 @@
 package controller
 
 import (
+	"context"
 	"crypto/tls"
-	"flag"
+	goflag "flag"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"argocd-pod-enrichment/internal/argocd"
 	"argocd-pod-enrichment/internal/controller"
+	"argocd-pod-enrichment/pkg/argocdclusters"
+	"argocd-pod-enrichment/pkg/config"
+	"argocd-pod-enrichment/pkg/enrichment"
+	controllerconsts "argocd-pod-enrichment/pkg/consts/controller"
+	argocdconsts "argocd-pod-enrichment/pkg/consts/argocd"
+	rolloutconsts "argocd-pod-enrichment/pkg/consts/argorollouts"
+	enrichmentconsts "argocd-pod-enrichment/pkg/consts/enrichment"
+	policyconsts "argocd-pod-enrichment/pkg/consts/enrichmentpolicy"
 	"argocd-pod-enrichment/pkg/kubernetesclient"
+	"argocd-pod-enrichment/pkg/namespacescope"
+	"argocd-pod-enrichment/pkg/ownerbridge"
+	"argocd-pod-enrichment/pkg/runtimeconfig"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	metricsAddr                                      string
+	metricsCertPath, metricsCertName, metricsCertKey string
+	webhookCertPath, webhookCertName, webhookCertKey string
+	enableLeaderElection                             bool
+	probeAddr                                        string
+	secureMetrics                                    bool
+	enableHTTP2                                      bool
+	opts                                             = zap.Options{Development: true}
+)
+
 var ControllerCmd = &cobra.Command{
 	Use:   "controller",
 	Short: "Run the ArgoCD pod enrichment controller",
-	Run: func(cmd *cobra.Command, args []string) {
-		mainController()
+	// Flags, environment variables and the config file are resolved into the Config passed to the controller
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load(cmd)
+		if err != nil {
+			return err
+		}
+		mainController(cfg)
+		return nil
 	},
 }
 
-func mainController() {
-	var metricsAddr string
-	var metricsCertPath, metricsCertName, metricsCertKey string
-	var webhookCertPath, webhookCertName, webhookCertKey string
-	var enableLeaderElection bool
-	var probeAddr string
-	var secureMetrics bool
-	var enableHTTP2 bool
+func init() {
+	flags := ControllerCmd.Flags()
+	flags.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
+	config.BindEnv(flags, "metrics-bind-address", controllerconsts.MetricsBindAddressEnvironmentVariable)
+	flags.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	config.BindEnv(flags, "health-probe-bind-address", controllerconsts.HealthProbeBindAddressEnvironmentVariable)
+	flags.BoolVar(&enableLeaderElection, "leader-elect", false, "Enable leader election for controller manager.")
+	config.BindEnv(flags, "leader-elect", controllerconsts.LeaderElectEnvironmentVariable)
+	flags.BoolVar(&secureMetrics, "metrics-secure", true, "If set, the metrics endpoint is served securely via HTTPS.")
+	config.BindEnv(flags, "metrics-secure", controllerconsts.MetricsSecureEnvironmentVariable)
+	flags.StringVar(&webhookCertPath, "webhook-cert-path", "", "The directory that contains the webhook certificate.")
+	config.BindEnv(flags, "webhook-cert-path", controllerconsts.WebhookCertPathEnvironmentVariable)
+	flags.StringVar(&webhookCertName, "webhook-cert-name", "tls.crt", "The name of the webhook certificate file.")
+	config.BindEnv(flags, "webhook-cert-name", controllerconsts.WebhookCertNameEnvironmentVariable)
+	flags.StringVar(&webhookCertKey, "webhook-cert-key", "tls.key", "The name of the webhook key file.")
+	config.BindEnv(flags, "webhook-cert-key", controllerconsts.WebhookCertKeyEnvironmentVariable)
+	flags.StringVar(&metricsCertPath, "metrics-cert-path", "", "The directory that contains the metrics server certificate.")
+	config.BindEnv(flags, "metrics-cert-path", controllerconsts.MetricsCertPathEnvironmentVariable)
+	flags.StringVar(&metricsCertName, "metrics-cert-name", "tls.crt", "The name of the metrics server certificate file.")
+	config.BindEnv(flags, "metrics-cert-name", controllerconsts.MetricsCertNameEnvironmentVariable)
+	flags.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
+	config.BindEnv(flags, "metrics-cert-key", controllerconsts.MetricsCertKeyEnvironmentVariable)
+	flags.BoolVar(&enableHTTP2, "enable-http2", false, "If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	config.BindEnv(flags, "enable-http2", controllerconsts.EnableHTTP2EnvironmentVariable)
+
+	flags.String("argocd-server", "", "Address of the ArgoCD API server, Applications are read from the cluster if unset")
+	config.BindEnv(flags, "argocd-server", argocdconsts.ArgoCDServerEnvironmentVariable)
+	flags.String("argocd-auth-token", "", "Token of the ArgoCD API server")
+	config.BindEnv(flags, "argocd-auth-token", argocdconsts.ArgoCDAuthTokenEnvironmentVariable)
+	flags.Bool("argocd-plaintext", false, "Connect to the ArgoCD API server without TLS")
+	config.BindEnv(flags, "argocd-plaintext", argocdconsts.ArgoCDPlainTextEnvironmentVariable)
+	flags.Bool("argocd-insecure", false, "Skip the verification of the ArgoCD API server certificate")
+	config.BindEnv(flags, "argocd-insecure", argocdconsts.ArgoCDInsecureEnvironmentVariable)
+	flags.String("argocd-server-ca-file", "", "CA certificate of the ArgoCD API server")
+	config.BindEnv(flags, "argocd-server-ca-file", argocdconsts.ArgoCDServerCAFileEnvironmentVariable)
+	flags.StringSlice("argocd-application-namespaces", nil, "Namespaces besides the ArgoCD namespace holding Applications")
+	config.BindEnv(flags, "argocd-application-namespaces", argocdconsts.ArgoCDApplicationNamespacesEnvironmentVariable)
+	flags.Bool("multicluster", false, "Enrich the pods of the clusters managed by ArgoCD, discovered from its cluster secrets")
+	config.BindEnv(flags, "multicluster", argocdconsts.ArgoCDMultiClusterEnvironmentVariable)
+	flags.String("cluster-name", "", "Name of the cluster of the pods, resolved from the Application destination if unset")
+	config.BindEnv(flags, "cluster-name", controllerconsts.ClusterNameEnvironmentVariable)
+	flags.String("cluster-server", "", "Server of the cluster of the pods")
+	config.BindEnv(flags, "cluster-server", controllerconsts.ClusterServerEnvironmentVariable)
+	flags.Bool("cluster-identity-from-argocd", false, "Resolve the cluster of the pods from the ArgoCD clusters matching the Application destination, when --cluster-name is unset")
+	config.BindEnv(flags, "cluster-identity-from-argocd", controllerconsts.ClusterIdentityFromArgoCDEnvironmentVariable)
+	flags.String("enrichment-config-file", "", "Enrichment config file")
+	config.BindEnv(flags, "enrichment-config-file", enrichmentconsts.EnrichmentConfigFileEnvironmentVariable)
+	flags.Int("application-lookup-max-retries", controllerconsts.DefaultApplicationLookupMaxRetries, "Retries of a failed Application lookup before the pod is marked as failed")
+	config.BindEnv(flags, "application-lookup-max-retries", controllerconsts.ApplicationLookupMaxRetriesEnvironmentVariable)
+	flags.Bool("sharding", false, "Shard the namespaces between the controller replicas")
+	config.BindEnv(flags, "sharding", controllerconsts.ShardingEnvironmentVariable)
+	flags.String("shard-namespace", "", "Namespace of the shard Leases, the namespace of the controller if unset")
+	config.BindEnv(flags, "shard-namespace", controllerconsts.ShardNamespaceEnvironmentVariable)
+	flags.String("shard-replica-id", "", "Identity of the replica in the shard, the hostname if unset")
+	config.BindEnv(flags, "shard-replica-id", controllerconsts.ShardReplicaIDEnvironmentVariable)
+
+	zapFlags := goflag.NewFlagSet("zap", goflag.ExitOnError)
+	opts.BindFlags(zapFlags)
+	flags.AddGoFlagSet(zapFlags)
+}
+
+func mainController(cfg *config.Config) {
 	var tlsOpts []func(*tls.Config)
 	var scheme = runtime.NewScheme()
 	var setupLog = ctrl.Log.WithName("setup")
 
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
-	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", false, "Enable leader election for controller manager.")
-	flag.BoolVar(&secureMetrics, "metrics-secure", true, "If set, the metrics endpoint is served securely via HTTPS.")
-	flag.StringVar(&webhookCertPath, "webhook-cert-path", "", "The directory that contains the webhook certificate.")
-	flag.StringVar(&webhookCertName, "webhook-cert-name", "tls.crt", "The name of the webhook certificate file.")
-	flag.StringVar(&webhookCertKey, "webhook-cert-key", "tls.key", "The name of the webhook key file.")
-	flag.StringVar(&metricsCertPath, "metrics-cert-path", "", "The directory that contains the metrics server certificate.")
-	flag.StringVar(&metricsCertName, "metrics-cert-name", "tls.crt", "The name of the metrics server certificate file.")
-	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
-	flag.BoolVar(&enableHTTP2, "enable-http2", false, "If set, HTTP/2 will be enabled for the metrics and webhook servers")
-	opts := zap.Options{Development: true}
-	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
-
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
 	disableHTTP2 := func(c *tls.Config) {
@@ -95,37 +176,298 @@ func mainController() {
 		metricsServerOptions.KeyName = metricsCertKey
 	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		Metrics:                metricsServerOptions,
-		WebhookServer:          webhookServer,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "f6f0eda1.codefresh.io",
+	var shardNamespace, shardReplicaID string
+	if cfg.Sharding {
+		var err error
+		if shardNamespace, shardReplicaID, err = shardIdentity(cfg); err != nil {
+			setupLog.Error(err, "unable to determine shard identity")
+			os.Exit(1)
+		}
+	}
+
+	argocdNamespace := cfg.ArgoCDNamespace
+	if cfg.MultiCluster && argocdNamespace == "" {
+		setupLog.Info(argocdconsts.ArgoCDNamespaceEnvironmentVariable + " is required to discover clusters")
+		os.Exit(1)
+	}
+	// The cluster secrets are only listed to resolve the cluster identity when it is not configured
+	resolveClusterSecrets := cfg.ClusterIdentityFromArgoCD && cfg.ClusterName == "" && cfg.ArgoCDServer == ""
+	if resolveClusterSecrets && argocdNamespace == "" {
+		setupLog.Info(argocdconsts.ArgoCDNamespaceEnvironmentVariable + " is required to resolve the cluster identity from the cluster secrets")
+		os.Exit(1)
+	}
+
+	kubernetesClient, err := kubernetesclient.NewInClusterKubernetesClient(cfg.Kubeconfig)
+
+	if err != nil {
+		setupLog.Error(err, "unable to create Kubernetes client")
+		os.Exit(1)
+	}
+
+	scope, err := namespacescope.Load(cfg.WatchNamespaces, cfg.WatchNamespaceSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid namespace scope")
+		os.Exit(1)
+	}
+	var watchNamespaces []string
+	if scope != nil {
+		if watchNamespaces, err = scope.Resolve(context.Background(), kubernetesClient.DynamicClient); err != nil {
+			setupLog.Error(err, "unable to resolve watched namespaces")
+			os.Exit(1)
+		}
+		if cfg.ArgoCDServer == "" && len(applicationNamespaces(cfg)) == 0 {
+			setupLog.Info(argocdconsts.ArgoCDNamespaceEnvironmentVariable + " or " + argocdconsts.ArgoCDApplicationNamespacesEnvironmentVariable + " is required to read Applications outside the watched namespaces")
+			os.Exit(1)
+		}
+		// Owners of pods are in the namespace of the pod, never look anywhere else
+		kubernetesClient.Namespaces = watchNamespaces
+	}
+	setupLog.Info("Enriching pods", "scope", scope.String(), "namespaces", watchNamespaces)
+
+	ownerPermissions, err := namespacescope.ParseOwnerKinds(cfg.OwnerKinds)
+	if err != nil {
+		setupLog.Error(err, "invalid owner kinds")
+		os.Exit(1)
+	}
+	if err := namespacescope.CheckPermissions(context.Background(), kubernetesClient.AuthorizationClient, orAllNamespaces(watchNamespaces), append(namespacescope.PodPermissions, ownerPermissions...)); err != nil {
+		setupLog.Error(err, "insufficient permissions for the watched namespaces")
+		os.Exit(1)
+	}
+	// Rollouts own pods when they are one of the owner kinds, their pods then follow promotions and aborts
+	watchRollouts := namespacescope.IncludesResource(ownerPermissions, rolloutconsts.RolloutGroup, rolloutconsts.RolloutResource)
+	if watchRollouts {
+		if err := namespacescope.CheckPermissions(context.Background(), kubernetesClient.AuthorizationClient, orAllNamespaces(watchNamespaces), namespacescope.RolloutPermissions); err != nil {
+			setupLog.Error(err, "insufficient permissions to watch Argo Rollouts")
+			os.Exit(1)
+		}
+	}
+	if cfg.ArgoCDServer == "" {
+		if err := namespacescope.CheckPermissions(context.Background(), kubernetesClient.AuthorizationClient, orAllNamespaces(applicationNamespaces(cfg)), namespacescope.ApplicationPermissions); err != nil {
+			setupLog.Error(err, "insufficient permissions to read ArgoCD Applications")
+			os.Exit(1)
+		}
+	}
+	if cfg.Sharding {
+		if err := namespacescope.CheckPermissions(context.Background(), kubernetesClient.AuthorizationClient, []string{shardNamespace}, namespacescope.LeasePermissions); err != nil {
+			setupLog.Error(err, "insufficient permissions to coordinate shards")
+			os.Exit(1)
+		}
+	}
+	if cfg.MultiCluster {
+		if err := namespacescope.CheckPermissions(context.Background(), kubernetesClient.AuthorizationClient, []string{argocdNamespace}, namespacescope.ClusterSecretPermissions); err != nil {
+			setupLog.Error(err, "insufficient permissions to read ArgoCD cluster secrets")
+			os.Exit(1)
+		}
+	} else if resolveClusterSecrets {
+		if err := namespacescope.CheckPermissions(context.Background(), kubernetesClient.AuthorizationClient, []string{argocdNamespace}, namespacescope.ClusterIdentityPermissions); err != nil {
+			setupLog.Error(err, "insufficient permissions to resolve the cluster identity from ArgoCD cluster secrets")
+			os.Exit(1)
+		}
+	}
+	if cfg.EnrichmentPolicies {
+		if err := namespacescope.CheckPermissions(context.Background(), kubernetesClient.AuthorizationClient, orAllNamespaces(watchNamespaces), append(namespacescope.PolicyPermissions, namespacescope.PolicyStatusPermissions...)); err != nil {
+			setupLog.Error(err, "insufficient permissions to read PodEnrichmentPolicies")
+			os.Exit(1)
+		}
+		if err := namespacescope.CheckPermissions(context.Background(), kubernetesClient.AuthorizationClient, []string{""}, append(namespacescope.ClusterPolicyPermissions, namespacescope.ClusterPolicyStatusPermissions...)); err != nil {
+			setupLog.Error(err, "insufficient permissions to read ClusterPodEnrichmentPolicies")
+			os.Exit(1)
+		}
+	}
+	runtimeConfigNamespace, runtimeConfigName, err := runtimeconfig.ConfigMapReference(cfg.RuntimeConfigMap)
+	if err != nil {
+		setupLog.Error(err, "invalid runtime config ConfigMap")
+		os.Exit(1)
+	}
+	if runtimeConfigName != "" {
+		if err := namespacescope.CheckPermissions(context.Background(), kubernetesClient.AuthorizationClient, []string{runtimeConfigNamespace}, namespacescope.RuntimeConfigPermissions); err != nil {
+			setupLog.Error(err, "insufficient permissions to watch the runtime config ConfigMap")
+			os.Exit(1)
+		}
+	}
+
+	cacheOptions := cache.Options{ByObject: map[client.Object]cache.ByObject{
+		// Pods are cached without spec and status, the controller only needs their metadata
+		&corev1.Pod{}: controller.PodCacheByObject(),
+	}}
+	if len(watchNamespaces) > 0 {
+		cacheOptions.DefaultNamespaces = map[string]cache.Config{}
+		for _, namespace := range watchNamespaces {
+			cacheOptions.DefaultNamespaces[namespace] = cache.Config{}
+		}
+	}
+	if cfg.MultiCluster {
+		// Only cache ArgoCD cluster secrets, never every secret in the cluster
+		cacheOptions.ByObject[&corev1.Secret{}] = cache.ByObject{
+			Namespaces: map[string]cache.Config{argocdNamespace: {}},
+			Label:      labels.SelectorFromSet(labels.Set{argocdconsts.ArgoCDSecretTypeLabel: argocdconsts.ArgoCDSecretTypeCluster}),
+		}
+	}
+	// Only cache Applications of the ArgoCD namespaces when they are known, otherwise every namespace
+	if namespaces := applicationNamespaces(cfg); len(namespaces) > 0 {
+		application := &unstructured.Unstructured{}
+		application.SetGroupVersionKind(argocdconsts.ApplicationGVK)
+		applicationCacheConfig := cache.ByObject{Namespaces: map[string]cache.Config{}}
+		for _, namespace := range namespaces {
+			applicationCacheConfig.Namespaces[namespace] = cache.Config{}
+		}
+		cacheOptions.ByObject[application] = applicationCacheConfig
+	}
+
+	restConfig, err := kubernetesConfig(cfg.Kubeconfig)
+	if err != nil {
+		setupLog.Error(err, "unable to load the Kubernetes client config")
+		os.Exit(1)
+	}
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                  scheme,
+		Cache:                   cacheOptions,
+		Metrics:                 metricsServerOptions,
+		WebhookServer:           webhookServer,
+		HealthProbeBindAddress:  probeAddr,
+		// Shard replicas are all active, the leader only runs the controllers writing shared state
+		LeaderElection:          enableLeaderElection || cfg.Sharding,
+		LeaderElectionID:        "f6f0eda1.codefresh.io",
+		LeaderElectionNamespace: shardNamespace,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// Unlabelled pods are resolved like the webhook does, with the same owner bridge rules
+	kubernetesClient.OwnerBridgeRules, err = ownerbridge.LoadRulesFile(cfg.OwnerBridgeRulesFile)
+	if err != nil {
+		setupLog.Error(err, "unable to load owner bridge rules")
+		os.Exit(1)
+	}
 
+	var argocdClient *argocd.Client
+	if cfg.ArgoCDServer != "" {
+		argocdClient, err = argocd.NewClient(argocd.ClientOptions{
+			ServerAddr: cfg.ArgoCDServer,
+			AuthToken:  cfg.ArgoCDAuthToken,
+			PlainText:  cfg.ArgoCDPlainText,
+			Insecure:   cfg.ArgoCDInsecure,
+			CACertFile: cfg.ArgoCDServerCAFile,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to create ArgoCD API client")
+			os.Exit(1)
+		}
+		setupLog.Info("Reading ArgoCD Applications from the ArgoCD API server", "server", cfg.ArgoCDServer)
+	}
 
-	kubernetesClient, err := kubernetesclient.NewInClusterKubernetesClient()
+	// The cluster identity is either configured or, if enabled, resolved from the destination of each Application
+	var clusterIdentity *argocdclusters.Identity
+	var clusterResolver *argocdclusters.Resolver
+	if cfg.ClusterName != "" {
+		clusterIdentity = &argocdclusters.Identity{Name: cfg.ClusterName, Server: cfg.ClusterServer}
+	} else if cfg.ClusterIdentityFromArgoCD && argocdClient != nil {
+		clusterResolver = argocdclusters.NewResolver(argocdClient.ListClusters)
+	} else if resolveClusterSecrets {
+		clusterResolver = argocdclusters.NewResolver(argocdclusters.SecretClusterLister(kubernetesClient.CoreClient, argocdNamespace))
+	}
 
+	enrichmentConfig, err := enrichment.LoadConfigFile(cfg.EnrichmentConfigFile)
 	if err != nil {
-		setupLog.Error(err, "unable to create Kubernetes client")
+		setupLog.Error(err, "unable to load enrichment config")
 		os.Exit(1)
 	}
 
-       if err := (&controller.PodReconciler{
-	       Client: mgr.GetClient(),
-	       Scheme: mgr.GetScheme(),
-	       KubernetesClient: kubernetesClient,
-       }).SetupWithManager(mgr); err != nil {
+	if cfg.ApplicationLookupMaxRetries < 1 {
+		setupLog.Info("invalid --application-lookup-max-retries, expected a positive integer")
+		os.Exit(1)
+	}
+
+	// The enrichment config and the namespace filter follow the runtime config ConfigMap, without restarting
+	var runtimeConfig *runtimeconfig.Store
+	if runtimeConfigName != "" {
+		runtimeConfig = runtimeconfig.NewStore(&runtimeconfig.Config{Enrichment: enrichmentConfig})
+		if err := runtimeconfig.RegisterMetrics(ctrlmetrics.Registry); err != nil {
+			setupLog.Error(err, "unable to register runtime config metrics")
+			os.Exit(1)
+		}
+		if err := mgr.Add(&runtimeconfig.Watcher{
+			Client:    kubernetesClient.DynamicClient,
+			Namespace: runtimeConfigNamespace,
+			Name:      runtimeConfigName,
+			Store:     runtimeConfig,
+			Recorder:  mgr.GetEventRecorderFor("argocd-pod-enrichment-controller"),
+			Log:       ctrl.Log.WithName("runtime-config"),
+			Component: "controller",
+		}); err != nil {
+			setupLog.Error(err, "unable to watch the runtime config ConfigMap")
+			os.Exit(1)
+		}
+		setupLog.Info("Reloading the runtime config from ConfigMap", "namespace", runtimeConfigNamespace, "name", runtimeConfigName)
+	}
+
+	podReconciler := controller.PodReconciler{
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		KubernetesClient: kubernetesClient,
+		ArgoCDClient:     argocdClient,
+		ArgoCDNamespace:  argocdNamespace,
+		TrackingLabel:    cfg.ArgoCDTrackingLabel,
+		ClusterIdentity:  clusterIdentity,
+		ClusterResolver:  clusterResolver,
+		EnrichmentConfig: enrichmentConfig,
+		ApplicationCache: mgr.GetCache(),
+		RuntimeConfig:    runtimeConfig,
+		MaxLookupRetries: cfg.ApplicationLookupMaxRetries,
+		OwnerKinds:       ownerPermissions,
+		WatchRollouts:    watchRollouts,
+	}
+
+	if cfg.EnrichmentPolicies {
+		podReconciler.PolicyCache = mgr.GetCache()
+		counter := &controller.PolicyMatchCounter{Reader: mgr.GetCache(), ArgoCDNamespace: argocdNamespace, RuntimeConfig: runtimeConfig}
+		for _, kind := range []string{policyconsts.PodEnrichmentPolicyGVK.Kind, policyconsts.ClusterPodEnrichmentPolicyGVK.Kind} {
+			if err := (&controller.PolicyStatusReconciler{Client: mgr.GetClient(), Kind: kind, Counter: counter}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", kind)
+				os.Exit(1)
+			}
+		}
+		setupLog.Info("Evaluating PodEnrichmentPolicies and ClusterPodEnrichmentPolicies")
+	}
+
+	if cfg.Sharding {
+		shard := &controller.Sharding{
+			Client:        mgr.GetClient(),
+			Reader:        mgr.GetAPIReader(),
+			Namespace:     shardNamespace,
+			ReplicaID:     shardReplicaID,
+			LeaseDuration: 30 * time.Second,
+			RenewInterval: 10 * time.Second,
+		}
+		if err := mgr.Add(shard); err != nil {
+			setupLog.Error(err, "unable to set up sharding")
+			os.Exit(1)
+		}
+		setupLog.Info("Sharding namespaces between controller replicas", "replica", shardReplicaID, "leaseNamespace", shardNamespace)
+		podReconciler.Shard = shard
+	}
+
+	if err := (&podReconciler).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Pod")
 		os.Exit(1)
 	}
 
+	if cfg.MultiCluster {
+		setupLog.Info("Discovering managed clusters from ArgoCD cluster secrets", "namespace", argocdNamespace)
+		if err := (&controller.ClusterSecretReconciler{
+			Client:          mgr.GetClient(),
+			Scheme:          mgr.GetScheme(),
+			ArgoCDNamespace: argocdNamespace,
+			WatchNamespaces: watchNamespaces,
+			PodReconciler:   podReconciler,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ClusterSecret")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -141,3 +483,59 @@ func mainController() {
 		os.Exit(1)
 	}
 }
+
+// applicationNamespaces returns the namespaces holding ArgoCD Applications: the ArgoCD namespace and the
+// namespaces of --argocd-application-namespaces
+func applicationNamespaces(cfg *config.Config) []string {
+	namespaces := []string{}
+	if cfg.ArgoCDNamespace != "" {
+		namespaces = append(namespaces, cfg.ArgoCDNamespace)
+	}
+	for _, namespace := range cfg.ArgoCDApplicationNamespaces {
+		if namespace = strings.TrimSpace(namespace); namespace != "" && !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// orAllNamespaces returns namespaces, or all namespaces if it is empty
+func orAllNamespaces(namespaces []string) []string {
+	if len(namespaces) == 0 {
+		return []string{""}
+	}
+	return namespaces
+}
+
+// shardIdentity returns the namespace of the shard Leases and the identity of the replica
+func shardIdentity(cfg *config.Config) (string, string, error) {
+	namespace := cfg.ShardNamespace
+	if namespace == "" {
+		namespace = os.Getenv(controllerconsts.PodNamespaceEnvironmentVariable)
+	}
+	if namespace == "" {
+		data, err := os.ReadFile(controllerconsts.ServiceAccountNamespaceFile)
+		if err != nil {
+			return "", "", fmt.Errorf("%s is required outside a cluster: %w", controllerconsts.ShardNamespaceEnvironmentVariable, err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	replicaID := cfg.ShardReplicaID
+	if replicaID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", "", fmt.Errorf("unable to get hostname, set %s: %w", controllerconsts.ShardReplicaIDEnvironmentVariable, err)
+		}
+		replicaID = hostname
+	}
+	return namespace, replicaID, nil
+}
+
+// kubernetesConfig returns the client config of the kubeconfig file if set, the config found by controller-runtime otherwise
+func kubernetesConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return ctrl.GetConfig()
+}
@@ -0,0 +1,341 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"argocd-pod-enrichment/pkg/config"
+	argocdconsts "argocd-pod-enrichment/pkg/consts/argocd"
+	rolloutconsts "argocd-pod-enrichment/pkg/consts/argorollouts"
+	controllerconsts "argocd-pod-enrichment/pkg/consts/controller"
+	enrichmentconsts "argocd-pod-enrichment/pkg/consts/enrichment"
+	"argocd-pod-enrichment/pkg/enrichment"
+	"argocd-pod-enrichment/pkg/kubernetesclient"
+	"argocd-pod-enrichment/pkg/namespacescope"
+	"argocd-pod-enrichment/pkg/ownerbridge"
+	"argocd-pod-enrichment/pkg/runtimeconfig"
+
+	"github.com/spf13/cobra"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	component   string
+	observe     bool
+	appProjects bool
+	namePrefix  string
+)
+
+var RbacCmd = &cobra.Command{
+	Use:   "rbac",
+	Short: "Print the minimal RBAC of the webhook and the controller",
+	Long: `Print the ClusterRoles the webhook and the controller need, granting get access to the owner kinds of pods only.
+
+The owner kinds come from --owner-kinds and default to the built-in workload kinds.
+With --observe, the owner chains of the existing pods are walked to learn the owner kinds in use.
+Parents of owner bridge rules (--owner-bridge-rules-file) are always included.
+
+Example:
+$ argocd-pod-enrichment rbac --observe | kubectl apply -f -`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load(cmd)
+		if err == nil {
+			err = printRBAC(cmd.Context(), cfg)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RbacCmd.Flags().StringVar(&component, "component", "all", "Component to print the RBAC of: webhook, controller or all")
+	RbacCmd.Flags().BoolVar(&observe, "observe", false, "Learn the owner kinds from the owner chains of the existing pods")
+	RbacCmd.Flags().Bool("multicluster", false, "Include the cluster secret access of the multi-cluster mode")
+	config.BindEnv(RbacCmd.Flags(), "multicluster", argocdconsts.ArgoCDMultiClusterEnvironmentVariable)
+	RbacCmd.Flags().Bool("cluster-identity-from-argocd", false, "Include the cluster secret access resolving the cluster identity")
+	config.BindEnv(RbacCmd.Flags(), "cluster-identity-from-argocd", controllerconsts.ClusterIdentityFromArgoCDEnvironmentVariable)
+	RbacCmd.Flags().Bool("sharding", false, "Include the Lease access of the sharding mode")
+	config.BindEnv(RbacCmd.Flags(), "sharding", controllerconsts.ShardingEnvironmentVariable)
+	// The settings of the controller deciding which access it needs
+	RbacCmd.Flags().String("argocd-server", "", "Leave out the Application and AppProject access, the controller reads them from the ArgoCD API server")
+	config.BindEnv(RbacCmd.Flags(), "argocd-server", argocdconsts.ArgoCDServerEnvironmentVariable)
+	RbacCmd.Flags().String("cluster-name", "", "Leave out the cluster secret access resolving the cluster identity, the identity is configured")
+	config.BindEnv(RbacCmd.Flags(), "cluster-name", controllerconsts.ClusterNameEnvironmentVariable)
+	RbacCmd.Flags().String("shard-namespace", "", "Namespace of the shard Leases")
+	config.BindEnv(RbacCmd.Flags(), "shard-namespace", controllerconsts.ShardNamespaceEnvironmentVariable)
+	RbacCmd.Flags().String("enrichment-config-file", "", "Enrichment config file of the controller, its expressions may reference the AppProject")
+	config.BindEnv(RbacCmd.Flags(), "enrichment-config-file", enrichmentconsts.EnrichmentConfigFileEnvironmentVariable)
+	RbacCmd.Flags().BoolVar(&appProjects, "app-projects", false, "Include the AppProject access of enrichment expressions, implied when the enrichment config references the project")
+	RbacCmd.Flags().StringVar(&namePrefix, "name-prefix", "argocd-pod-enrichment", "Prefix of the role names")
+}
+
+func printRBAC(ctx context.Context, cfg *config.Config) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if component != "all" && component != "webhook" && component != "controller" {
+		return fmt.Errorf("invalid --component %q, expected webhook, controller or all", component)
+	}
+
+	owners, err := ownerPermissions(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	scope, err := namespacescope.Load(cfg.WatchNamespaces, cfg.WatchNamespaceSelector)
+	if err != nil {
+		return err
+	}
+	policiesEnabled := cfg.EnrichmentPolicies
+	argocdNamespace := cfg.ArgoCDNamespace
+	if argocdNamespace == "" {
+		argocdNamespace = "argocd"
+	}
+
+	documents := []interface{}{}
+	if component == "all" || component == "webhook" {
+		permissions := append(slices.Clone(owners), namespacescope.HookApplicationPermissions...)
+		if policiesEnabled {
+			permissions = append(permissions, namespacescope.PolicyPermissions...)
+			permissions = append(permissions, namespacescope.ClusterPolicyPermissions...)
+		}
+		documents = append(documents, clusterRole(namePrefix+"-webhook", permissions))
+		// The webhook applies the policies, whose expressions may reference the AppProject
+		if policiesEnabled && appProjects {
+			documents = append(documents, role(namePrefix+"-webhook-app-projects", argocdNamespace, namespacescope.AppProjectPermissions))
+		}
+	}
+	if component == "all" || component == "controller" {
+		permissions := append(slices.Clone(namespacescope.PodPermissions), owners...)
+		if namespacescope.IncludesResource(owners, rolloutconsts.RolloutGroup, rolloutconsts.RolloutResource) {
+			permissions = append(permissions, namespacescope.RolloutPermissions...)
+		}
+		if cfg.ArgoCDServer == "" {
+			permissions = append(permissions, namespacescope.ApplicationPermissions...)
+		}
+		if policiesEnabled {
+			permissions = append(permissions, namespacescope.PolicyPermissions...)
+			permissions = append(permissions, namespacescope.ClusterPolicyPermissions...)
+			permissions = append(permissions, namespacescope.PolicyStatusPermissions...)
+			permissions = append(permissions, namespacescope.ClusterPolicyStatusPermissions...)
+		}
+		documents = append(documents, clusterRole(namePrefix+"-controller", permissions))
+		if cfg.Sharding {
+			namespace := cfg.ShardNamespace
+			if namespace == "" {
+				namespace = "default"
+			}
+			documents = append(documents, role(namePrefix+"-controller-shards", namespace, namespacescope.LeasePermissions))
+		}
+		if cfg.MultiCluster {
+			documents = append(documents, role(namePrefix+"-controller-cluster-secrets", argocdNamespace, namespacescope.ClusterSecretPermissions))
+		} else if cfg.ClusterIdentityFromArgoCD && cfg.ClusterName == "" && cfg.ArgoCDServer == "" {
+			documents = append(documents, role(namePrefix+"-controller-cluster-identity", argocdNamespace, namespacescope.ClusterIdentityPermissions))
+		}
+		if cfg.ArgoCDServer == "" {
+			enrichmentConfig, err := enrichment.LoadConfigFile(cfg.EnrichmentConfigFile)
+			if err != nil {
+				return err
+			}
+			if appProjects || enrichmentConfig.References(enrichmentconsts.ExpressionVariableProject) {
+				documents = append(documents, role(namePrefix+"-controller-app-projects", argocdNamespace, namespacescope.AppProjectPermissions))
+			}
+		}
+	}
+
+	// The namespace selector is resolved cluster-wide, even when the other roles are bound in the watched
+	// namespaces only, so it gets its own ClusterRole to bind with a ClusterRoleBinding
+	if scope != nil && scope.Selector != nil {
+		documents = append(documents, clusterRole(namePrefix+"-namespaces", namespacescope.NamespacePermissions))
+	}
+
+	runtimeConfigNamespace, runtimeConfigName, err := runtimeconfig.ConfigMapReference(cfg.RuntimeConfigMap)
+	if err != nil {
+		return err
+	}
+	if runtimeConfigName != "" {
+		documents = append(documents, role(namePrefix+"-runtime-config", runtimeConfigNamespace, namespacescope.RuntimeConfigPermissions))
+	}
+
+	for i, document := range documents {
+		out, err := yaml.Marshal(document)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Print(string(out))
+	}
+	return nil
+}
+
+// ownerPermissions returns the configured or observed owner kinds, plus the parents of the bridge rules
+func ownerPermissions(ctx context.Context, cfg *config.Config) ([]namespacescope.Permission, error) {
+	owners, err := namespacescope.ParseResources(strings.Join(cfg.OwnerKinds, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := ownerbridge.LoadRulesFile(cfg.OwnerBridgeRulesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var client *kubernetesclient.KubernetesClient
+	if observe || len(rules) > 0 {
+		if client, err = kubernetesclient.NewInClusterKubernetesClient(cfg.Kubeconfig); err != nil {
+			return nil, err
+		}
+		client.OwnerBridgeRules = rules
+	}
+
+	if observe {
+		observed, err := observeOwners(ctx, client, cfg)
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, observed...)
+	} else if len(owners) == 0 {
+		owners = slices.Clone(namespacescope.OwnerPermissions)
+	}
+
+	for _, rule := range rules {
+		gvr, _, err := client.ResourceFor(rule.ParentAPIVersion, rule.ParentKind)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving bridge rule parent %s: %w", rule.ParentKind, err)
+		}
+		owners = append(owners, namespacescope.Permission{Group: gvr.Group, Resource: gvr.Resource, Verbs: []string{"get"}})
+	}
+	return owners, nil
+}
+
+// observeOwners walks the owner chains of the existing pods of the watched namespaces, reading metadata only,
+// and returns the owner kinds found. Pods with the same controller are walked once.
+func observeOwners(ctx context.Context, client *kubernetesclient.KubernetesClient, cfg *config.Config) ([]namespacescope.Permission, error) {
+	namespaces := []string{metav1.NamespaceAll}
+	scope, err := namespacescope.Load(cfg.WatchNamespaces, cfg.WatchNamespaceSelector)
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil {
+		if namespaces, err = scope.Resolve(ctx, client.DynamicClient); err != nil {
+			return nil, err
+		}
+		client.Namespaces = namespaces
+	}
+
+	podsGVR := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	seenControllers := map[types.UID]bool{}
+	found := map[schema.GroupResource]bool{}
+
+	for _, namespace := range namespaces {
+		pods, err := client.MetadataClient.Resource(podsGVR).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error listing pods: %w", err)
+		}
+		for i := range pods.Items {
+			pod := pods.Items[i]
+			if controller := metav1.GetControllerOf(&pod); controller != nil {
+				if seenControllers[controller.UID] {
+					continue
+				}
+				seenControllers[controller.UID] = true
+			}
+
+			res := &unstructured.Unstructured{}
+			res.SetAPIVersion("v1")
+			res.SetKind("Pod")
+			res.SetNamespace(pod.Namespace)
+			res.SetName(pod.Name)
+			res.SetUID(pod.UID)
+			res.SetLabels(pod.Labels)
+			res.SetAnnotations(pod.Annotations)
+			res.SetOwnerReferences(pod.OwnerReferences)
+
+			chain, err := client.GetControllerOwnerChain(res)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "skipping pod %s/%s: %v\n", pod.Namespace, pod.Name, err)
+				continue
+			}
+			for _, owner := range chain[1:] {
+				gvr, _, err := client.ResourceFor(owner.GetAPIVersion(), owner.GetKind())
+				if err != nil {
+					return nil, err
+				}
+				found[gvr.GroupResource()] = true
+			}
+		}
+	}
+
+	owners := []namespacescope.Permission{}
+	for groupResource := range found {
+		owners = append(owners, namespacescope.Permission{Group: groupResource.Group, Resource: groupResource.Resource, Verbs: []string{"get"}})
+	}
+	return owners, nil
+}
+
+// policyRules merges permissions into one rule per API group and verb set, with sorted resources
+func policyRules(permissions []namespacescope.Permission) []rbacv1.PolicyRule {
+	type ruleKey struct {
+		group string
+		verbs string
+	}
+	resources := map[ruleKey][]string{}
+	for _, permission := range permissions {
+		verbs := slices.Clone(permission.Verbs)
+		sort.Strings(verbs)
+		key := ruleKey{group: permission.Group, verbs: strings.Join(verbs, ",")}
+		if !slices.Contains(resources[key], permission.Resource) {
+			resources[key] = append(resources[key], permission.Resource)
+		}
+	}
+
+	keys := make([]ruleKey, 0, len(resources))
+	for key := range resources {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].verbs < keys[j].verbs
+	})
+
+	rules := make([]rbacv1.PolicyRule, 0, len(keys))
+	for _, key := range keys {
+		sort.Strings(resources[key])
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{key.group},
+			Resources: resources[key],
+			Verbs:     strings.Split(key.verbs, ","),
+		})
+	}
+	return rules
+}
+
+func clusterRole(name string, permissions []namespacescope.Permission) *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Rules:      policyRules(permissions),
+	}
+}
+
+func role(name, namespace string, permissions []namespacescope.Permission) *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Rules:      policyRules(permissions),
+	}
+}
@@ -5,20 +5,39 @@ import (
 	"encoding/json"
 	"strings"
 	"fmt"
+	"context"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"sort"
 
 	client "argocd-pod-enrichment/pkg/kubernetesclient"
 	argocdtracking "argocd-pod-enrichment/pkg/argocdresourcetracking"
+	enrichmentconsts "argocd-pod-enrichment/pkg/consts/enrichment"
+	"argocd-pod-enrichment/pkg/enrichment"
+	"argocd-pod-enrichment/pkg/enrichmentpolicy"
+	"argocd-pod-enrichment/pkg/namespacescope"
+	"argocd-pod-enrichment/pkg/ownerbridge"
+	"argocd-pod-enrichment/pkg/podtracking"
+	"argocd-pod-enrichment/pkg/runtimeconfig"
 
+	"github.com/go-logr/stdr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"argocd-pod-enrichment/pkg/config"
 	consts "argocd-pod-enrichment/pkg/consts/webhook"
 )
 
@@ -28,6 +47,17 @@ var (
 	port    int
 	codecs  = serializer.NewCodecFactory(runtime.NewScheme())
 	logger  = log.New(os.Stdout, "http: ", log.LstdFlags)
+
+	// cfg is the configuration resolved from the flags, the environment and the config file at startup
+	cfg *config.Config
+
+	ownerBridgeRules []ownerbridge.Rule
+	// watchNamespaces are the namespaces whose pods are mutated, all namespaces if empty
+	watchNamespaces []string
+	// runtimeConfig narrows watchNamespaces from the runtime config ConfigMap, nil if none is configured
+	runtimeConfig *runtimeconfig.Store
+	// policies holds the enrichment policies, nil if they are disabled
+	policies *enrichmentpolicy.Informer
 )
 
 var WebhookCmd = &cobra.Command{
@@ -37,12 +67,17 @@ var WebhookCmd = &cobra.Command{
 
 Example:
 $ argocd-pod-enrichment webhook --tls-cert <tls_cert> --tls-key <tls_key> --port <port>`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		if cfg, err = config.Load(cmd); err != nil {
+			return err
+		}
 		if tlsCert == "" || tlsKey == "" {
 			fmt.Println("--tls-cert and --tls-key required")
 			os.Exit(1)
 		}
 		runWebhookServer(tlsCert, tlsKey)
+		return nil
 	},
 }
 
@@ -50,6 +85,9 @@ func init() {
 	WebhookCmd.Flags().StringVar(&tlsCert, "tls-cert", "/certs/tls.crt", "Certificate for TLS")
 	WebhookCmd.Flags().StringVar(&tlsKey, "tls-key", "/certs/tls.key", "Private key file for TLS")
 	WebhookCmd.Flags().IntVar(&port, "port", 8443, "Port to listen on for HTTPS traffic")
+	config.BindEnv(WebhookCmd.Flags(), "tls-cert", consts.TLSCertEnvironmentVariable)
+	config.BindEnv(WebhookCmd.Flags(), "tls-key", consts.TLSKeyEnvironmentVariable)
+	config.BindEnv(WebhookCmd.Flags(), "port", consts.PortEnvironmentVariable)
 }
 
 func admissionReviewFromRequest(r *http.Request, deserializer runtime.Decoder) (*admissionv1.AdmissionReview, error) {
@@ -76,8 +114,26 @@ func runWebhookServer(certFile, keyFile string) {
 	if err != nil {
 		panic(err)
 	}
+	ownerBridgeRules, err = ownerbridge.LoadRulesFile(cfg.OwnerBridgeRulesFile)
+	if err != nil {
+		panic(err)
+	}
+	logger.Printf("Loaded %d owner bridge rules", len(ownerBridgeRules))
+	watchNamespaces, err = setupNamespaceScope(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	runtimeConfig, err = setupRuntimeConfig(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	policies, err = setupPolicies(context.Background())
+	if err != nil {
+		panic(err)
+	}
 	fmt.Println("Starting webhook server")
 	http.HandleFunc("/mutate", mutatePod)
+	http.Handle("/metrics", promhttp.Handler())
 	server := http.Server{
 		Addr: fmt.Sprintf(":%d", port),
 		TLSConfig: &tls.Config{
@@ -109,6 +165,12 @@ func mutatePod(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(msg))
 		return
 	}
+	if len(watchNamespaces) > 0 && !slices.Contains(watchNamespaces, admissionReviewRequest.Request.Namespace) ||
+		runtimeConfig != nil && !runtimeConfig.Current().Watches(admissionReviewRequest.Request.Namespace) {
+		logger.Printf("skipping pod in namespace %s outside the watched namespaces", admissionReviewRequest.Request.Namespace)
+		writeAllowedResponse(w, admissionReviewRequest)
+		return
+	}
 	rawRequest := admissionReviewRequest.Request.Object.Raw
 	pod := unstructured.Unstructured{}
 	if _, _, err := deserializer.Decode(rawRequest, nil, &pod); err != nil {
@@ -118,7 +180,7 @@ func mutatePod(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(msg))
 		return
 	}
-	client, err := client.NewInClusterKubernetesClient()
+	client, err := client.NewInClusterKubernetesClient(cfg.Kubeconfig)
 	if err != nil {
 		msg := fmt.Sprintf("error creating in-cluster kubernetes client: %v", err)
 		logger.Print(msg)
@@ -126,21 +188,56 @@ func mutatePod(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(msg))
 		return
 	}
-	owner, err := client.GetTopmostControllerOwner(&pod)
+	client.OwnerBridgeRules = ownerBridgeRules
+	client.Namespaces = watchNamespaces
+	resolution, err := podtracking.Resolve(context.TODO(), client, &pod, client.GetArgoCDApplication, podtracking.Options{
+		ArgoCDNamespace: cfg.ArgoCDNamespace,
+		TrackingLabel:   cfg.ArgoCDTrackingLabel,
+	})
 	if err != nil {
-		msg := fmt.Sprintf("error getting topmost controller owner: %v", err)
+		msg := err.Error()
 		logger.Print(msg)
 		w.WriteHeader(500)
 		w.Write([]byte(msg))
 		return
 	}
 
-	argocdtracking := argocdtracking.ExtractArgoCDTrackingInfo(*owner)
-	logger.Printf("Extracted ArgoCD tracking info: %+v", argocdtracking)
+	if resolution != nil {
+		argocdtracking := resolution.Tracking
+		logger.Printf("Extracted ArgoCD tracking info: %+v", argocdtracking)
+
+		if resolution.Rollout != nil {
+			logger.Printf("Extracted Argo Rollouts info: %+v", resolution.Rollout)
+		}
+		if hook := resolution.Hook; hook != nil {
+			logger.Printf("Pod belongs to ArgoCD hook %s/%s: %+v", hook.ResourceKind, hook.ResourceName, hook)
+		}
+		if resolution.RolloutError != nil {
+			logger.Print(resolution.RolloutError)
+		}
+		if resolution.HookApplicationError != nil {
+			// The hook label is still useful without the sync operation, do not fail the admission
+			logger.Print(resolution.HookApplicationError)
+		}
 
-	if argocdtracking != nil {
+		extraLabels, extraAnnotations := resolution.ExtraLabels, resolution.ExtraAnnotations
+		if evaluation, err := evaluatePolicies(admissionReviewRequest.Request.Namespace, &pod, argocdtracking); err != nil {
+			logger.Printf("error evaluating enrichment policies: %v", err)
+		} else if evaluation != nil && evaluation.OptOut != nil {
+			logger.Printf("Pod opted out of enrichment by %s", evaluation.OptOut)
+			writeAllowedResponse(w, admissionReviewRequest)
+			return
+		} else if evaluation != nil && len(evaluation.Matched) > 0 {
+			if metadata, err := policyMetadata(context.TODO(), client, evaluation, &pod, argocdtracking); err != nil {
+				// The controller applies the policies too once the pod exists, do not fail the admission
+				logger.Printf("error applying enrichment policies: %v", err)
+			} else {
+				extraLabels = mergeValues(extraLabels, metadata.Labels)
+				extraAnnotations = mergeValues(extraAnnotations, metadata.Annotations)
+			}
+		}
 
-		admissionReviewResponse := constructResponse(argocdtracking)
+		admissionReviewResponse := constructResponse(&pod, argocdtracking, extraLabels, extraAnnotations)
 		admissionReviewResponse.SetGroupVersionKind(admissionReviewRequest.GroupVersionKind())
 		admissionReviewResponse.Response.UID = admissionReviewRequest.Request.UID
 
@@ -157,13 +254,253 @@ func mutatePod(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func constructResponse(argocdtracking *argocdtracking.ArgoCDTrackingInfo) *admissionv1.AdmissionReview {
+// setupNamespaceScope resolves the watched namespaces and checks that the service account can look up
+// pod owners in all of them
+func setupNamespaceScope(ctx context.Context) ([]string, error) {
+	scope, err := namespacescope.Load(cfg.WatchNamespaces, cfg.WatchNamespaceSelector)
+	if err != nil {
+		return nil, err
+	}
+	startupClient, err := client.NewInClusterKubernetesClient(cfg.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := []string{""}
+	if scope != nil {
+		if namespaces, err = scope.Resolve(ctx, startupClient.DynamicClient); err != nil {
+			return nil, err
+		}
+	}
+	logger.Printf("Mutating pods in %s", scope)
+	ownerPermissions, err := namespacescope.ParseOwnerKinds(cfg.OwnerKinds)
+	if err != nil {
+		return nil, err
+	}
+	if err := namespacescope.CheckPermissions(ctx, startupClient.AuthorizationClient, namespaces, ownerPermissions); err != nil {
+		return nil, err
+	}
+	if scope == nil {
+		return nil, nil
+	}
+	return namespaces, nil
+}
+
+// setupRuntimeConfig watches the runtime config ConfigMap, if one is configured, for the rest of the process
+func setupRuntimeConfig(ctx context.Context) (*runtimeconfig.Store, error) {
+	namespace, name, err := runtimeconfig.ConfigMapReference(cfg.RuntimeConfigMap)
+	if err != nil || name == "" {
+		return nil, err
+	}
+	startupClient, err := client.NewInClusterKubernetesClient(cfg.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := namespacescope.CheckPermissions(ctx, startupClient.AuthorizationClient, []string{namespace}, namespacescope.RuntimeConfigPermissions); err != nil {
+		return nil, err
+	}
+	if err := runtimeconfig.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		return nil, err
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: startupClient.CoreClient.Events("")})
+	store := runtimeconfig.NewStore(&runtimeconfig.Config{})
+	watcher := &runtimeconfig.Watcher{
+		Client:    startupClient.DynamicClient,
+		Namespace: namespace,
+		Name:      name,
+		Store:     store,
+		Recorder:  broadcaster.NewRecorder(clientgoscheme.Scheme, corev1.EventSource{Component: "argocd-pod-enrichment-webhook"}),
+		Log:       stdr.New(logger),
+		Component: "webhook",
+	}
+	go func() {
+		if err := watcher.Start(ctx); err != nil {
+			logger.Printf("error watching runtime config ConfigMap %s/%s: %v", namespace, name, err)
+		}
+	}()
+	logger.Printf("Reloading the runtime config from ConfigMap %s/%s", namespace, name)
+	return store, nil
+}
+
+// setupPolicies starts the informers of the enrichment policies of the watched namespaces, if they are enabled
+func setupPolicies(ctx context.Context) (*enrichmentpolicy.Informer, error) {
+	if !cfg.EnrichmentPolicies {
+		return nil, nil
+	}
+	startupClient, err := client.NewInClusterKubernetesClient(cfg.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := watchNamespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	if err := namespacescope.CheckPermissions(ctx, startupClient.AuthorizationClient, namespaces, namespacescope.PolicyPermissions); err != nil {
+		return nil, err
+	}
+	if err := namespacescope.CheckPermissions(ctx, startupClient.AuthorizationClient, []string{""}, namespacescope.ClusterPolicyPermissions); err != nil {
+		return nil, err
+	}
+	informer := &enrichmentpolicy.Informer{Client: startupClient.DynamicClient, Namespaces: watchNamespaces}
+	if err := informer.Start(ctx); err != nil {
+		return nil, err
+	}
+	logger.Print("Evaluating PodEnrichmentPolicies and ClusterPodEnrichmentPolicies")
+	return informer, nil
+}
+
+// evaluatePolicies returns the enrichment policies matching the pod, nil if policies are disabled
+func evaluatePolicies(namespace string, pod *unstructured.Unstructured, tracking *argocdtracking.ArgoCDTrackingInfo) (*enrichmentpolicy.Evaluation, error) {
+	if policies == nil {
+		return nil, nil
+	}
+	namespacePolicies, err := policies.Policies(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return enrichmentpolicy.Evaluate(namespacePolicies, enrichmentpolicy.Target{
+		Namespace:            namespace,
+		Labels:               pod.GetLabels(),
+		ApplicationNamespace: applicationNamespace(tracking),
+		ApplicationName:      tracking.ApplicationName,
+	}), nil
+}
+
+// policyMetadata returns the labels and annotations the matched policies add to the pod, so that the pod
+// is created with them instead of waiting for the controller
+func policyMetadata(ctx context.Context, kubernetesClient *client.KubernetesClient, evaluation *enrichmentpolicy.Evaluation, pod *unstructured.Unstructured, tracking *argocdtracking.ArgoCDTrackingInfo) (*enrichment.Metadata, error) {
+	app, err := kubernetesClient.GetArgoCDApplication(ctx, applicationNamespace(tracking), tracking.ApplicationName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting ArgoCD Application: %w", err)
+	}
+	typedPod := &corev1.Pod{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(pod.Object, typedPod); err != nil {
+		return nil, fmt.Errorf("error converting pod: %w", err)
+	}
+	input := &enrichment.ExpressionInput{Pod: typedPod, App: app}
+
+	if evaluation.References(enrichmentconsts.ExpressionVariableOwners) {
+		chain, err := kubernetesClient.GetControllerOwnerChain(pod)
+		if err != nil {
+			return nil, fmt.Errorf("error getting owner chain: %w", err)
+		}
+		// The chain starts with the pod itself
+		input.Owners = chain[1:]
+	}
+	if evaluation.References(enrichmentconsts.ExpressionVariableProject) {
+		name, _, _ := unstructured.NestedString(app.Object, "spec", "project")
+		if name == "" {
+			name = "default"
+		}
+		// ArgoCD keeps the AppProjects of every Application namespace in its own namespace
+		namespace := cfg.ArgoCDNamespace
+		if namespace == "" {
+			namespace = app.GetNamespace()
+		}
+		project, err := kubernetesClient.GetArgoCDAppProject(ctx, namespace, name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("error getting AppProject: %w", err)
+		}
+		input.Project = project
+	}
+
+	desired := enrichment.NewMetadata()
+	existing := &enrichment.Metadata{Labels: pod.GetLabels(), Annotations: pod.GetAnnotations()}
+	skipped, err := evaluation.Apply(desired, input, existing)
+	if err != nil {
+		logger.Printf("skipping policy expressions that failed: %v", err)
+	}
+	if len(skipped) > 0 {
+		logger.Printf("skipping policy keys that are not valid for their target: %v", skipped)
+	}
+	if len(desired.Labels) > 0 || len(desired.Annotations) > 0 {
+		// Recorded as managed, so the controller deletes the keys explicitly once the policy no longer sets them,
+		// even though the manager creating the pod owns them
+		desired.Annotations[consts.ManagedKeysAnnotationKey] = desired.Keys().Encode()
+	}
+	return desired, nil
+}
+
+// applicationNamespace returns the namespace of the Application of the pod, the ArgoCD namespace if the tracking
+// information does not name it
+func applicationNamespace(tracking *argocdtracking.ArgoCDTrackingInfo) string {
+	if tracking.ApplicationNamespace != "" {
+		return tracking.ApplicationNamespace
+	}
+	return cfg.ArgoCDNamespace
+}
+
+// mergeValues adds values to into, allocating it if needed. Keys already in into are kept.
+func mergeValues(into map[string]string, values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return into
+	}
+	if into == nil {
+		into = map[string]string{}
+	}
+	for key, value := range values {
+		if _, ok := into[key]; !ok {
+			into[key] = value
+		}
+	}
+	return into
+}
+
+// writeAllowedResponse admits the pod unchanged
+func writeAllowedResponse(w http.ResponseWriter, admissionReviewRequest *admissionv1.AdmissionReview) {
+	var admissionReviewResponse admissionv1.AdmissionReview
+	admissionReviewResponse.SetGroupVersionKind(admissionReviewRequest.GroupVersionKind())
+	admissionReviewResponse.Response = &admissionv1.AdmissionResponse{UID: admissionReviewRequest.Request.UID, Allowed: true}
+	resp, err := json.Marshal(admissionReviewResponse)
+	if err != nil {
+		msg := fmt.Sprintf("error marshalling response json: %v", err)
+		logger.Print(msg)
+		w.WriteHeader(500)
+		w.Write([]byte(msg))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+func metadataPatchOperation(field, key, value string) string {
+	path := "/metadata/" + field + "/" + strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+	encodedValue, _ := json.Marshal(value)
+	return `{"op":"add","path":"` + path + `", "value": ` + string(encodedValue) + `}`
+}
+
+// metadataPatchOperations returns add operations for the given keys of a metadata map, in a stable order
+func metadataPatchOperations(field string, values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	patchOperations := []string{}
+	for _, key := range keys {
+		patchOperations = append(patchOperations, metadataPatchOperation(field, key, values[key]))
+	}
+	return patchOperations
+}
+
+func constructResponse(pod *unstructured.Unstructured, argocdtracking *argocdtracking.ArgoCDTrackingInfo, extraLabels map[string]string, extraAnnotations map[string]string) *admissionv1.AdmissionReview {
 	admissionResponse := &admissionv1.AdmissionResponse{}
 	var patch string
 	patchType := admissionv1.PatchTypeJSONPatch
 
+	patchOperations := []string{}
+
+	// Adding a key to a map that does not exist fails, create the maps first
+	if pod.GetLabels() == nil {
+		patchOperations = append(patchOperations, `{"op":"add","path":"/metadata/labels","value":{}}`)
+	}
+	if pod.GetAnnotations() == nil && len(extraAnnotations) > 0 {
+		patchOperations = append(patchOperations, `{"op":"add","path":"/metadata/annotations","value":{}}`)
+	}
+
 	appLabelPath := "/metadata/labels/" + strings.ReplaceAll(consts.ApplicationLabelKey, "/", "~1")
-	patchOperations := []string{`{"op":"add","path":"` + appLabelPath + `", "value": "` + argocdtracking.ApplicationName + `"}`}
+	patchOperations = append(patchOperations, `{"op":"add","path":"` + appLabelPath + `", "value": "` + argocdtracking.ApplicationName + `"}`)
 
 	if argocdtracking.ApplicationNamespace != "" {
 		nsLabelPath := "/metadata/labels/" + strings.ReplaceAll(consts.ApplicationNamespaceLabelKey, "/", "~1")
@@ -175,6 +512,9 @@ func constructResponse(argocdtracking *argocdtracking.ArgoCDTrackingInfo) *admis
 		patchOperations = append(patchOperations, `{"op":"add","path":"` + idLabelPath + `", "value": "` + argocdtracking.InstallationID + `"}`)
 	}
 
+	patchOperations = append(patchOperations, metadataPatchOperations("labels", extraLabels)...)
+	patchOperations = append(patchOperations, metadataPatchOperations("annotations", extraAnnotations)...)
+
 	patch = "[" +  strings.Join(patchOperations, ",") + "]"
 
 	admissionResponse.Allowed = true
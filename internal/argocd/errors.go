@@ -0,0 +1,48 @@
+package argocd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// newAPIError converts an ArgoCD API error response into a Kubernetes StatusError,
+// so callers can use the apierrors helpers (IsNotFound, IsForbidden...) regardless of the Application source
+func newAPIError(code int, path string, body []byte) error {
+	message := strings.TrimSpace(string(body))
+	var apiError struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &apiError); err == nil && apiError.Message != "" {
+		message = apiError.Message
+	}
+
+	resource, name := resourceFromPath(path)
+	groupResource := schema.GroupResource{Group: "argoproj.io", Resource: resource}
+
+	statusError := apierrors.NewGenericServerResponse(code, http.MethodGet, groupResource, name, message, 0, false)
+	// The generic messages of some codes (NotFound, Unauthorized...) drop the message of the ArgoCD API
+	if message != "" && !strings.Contains(statusError.ErrStatus.Message, message) {
+		statusError.ErrStatus.Message += ": " + message
+	}
+	return statusError
+}
+
+// resourceFromPath extracts the resource and name from an API path such as /api/v1/applications/guestbook
+func resourceFromPath(path string) (string, string) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/api/v1"), "/"), "/")
+	if len(parts) > 0 && parts[0] == "stream" {
+		parts = parts[1:]
+	}
+	switch len(parts) {
+	case 0:
+		return "", ""
+	case 1:
+		return parts[0], ""
+	default:
+		return parts[0], parts[1]
+	}
+}
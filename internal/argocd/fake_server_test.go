@@ -0,0 +1,293 @@
+package argocd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"argocd-pod-enrichment/pkg/argocdclusters"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// FakeServer is an in-process ArgoCD API server serving Applications and AppProjects from memory.
+// It implements the subset of the API used by Client.
+type FakeServer struct {
+	*httptest.Server
+
+	authToken string
+
+	mu           sync.RWMutex
+	applications map[string]*unstructured.Unstructured
+	projects     map[string]*unstructured.Unstructured
+	clusters     map[string]argocdclusters.Identity
+	watchers     map[chan watch.Event]struct{}
+}
+
+// NewFakeServer starts a TLS fake server that requires authToken, or no authentication if it is empty
+func NewFakeServer(authToken string) *FakeServer {
+	s := &FakeServer{
+		authToken:    authToken,
+		applications: map[string]*unstructured.Unstructured{},
+		projects:     map[string]*unstructured.Unstructured{},
+		clusters:     map[string]argocdclusters.Identity{},
+		watchers:     map[chan watch.Event]struct{}{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/applications", s.authenticated(s.listApplications))
+	mux.HandleFunc("/api/v1/applications/", s.authenticated(s.getApplication))
+	mux.HandleFunc("/api/v1/stream/applications", s.authenticated(s.streamApplications))
+	mux.HandleFunc("/api/v1/projects", s.authenticated(s.listProjects))
+	mux.HandleFunc("/api/v1/projects/", s.authenticated(s.getProject))
+	mux.HandleFunc("/api/v1/clusters", s.authenticated(s.listClusters))
+
+	s.Server = httptest.NewTLSServer(mux)
+	return s
+}
+
+// Client returns a Client configured to talk to the fake server, polling projects every few milliseconds
+func (s *FakeServer) Client() *Client {
+	client, _ := NewClient(ClientOptions{
+		ServerAddr:          s.URL,
+		AuthToken:           s.authToken,
+		HTTPClient:          s.Server.Client(),
+		ProjectPollInterval: 10 * time.Millisecond,
+	})
+	return client
+}
+
+// UpsertApplication stores an Application and notifies application streams
+func (s *FakeServer) UpsertApplication(app *unstructured.Unstructured) {
+	app = app.DeepCopy()
+	setTypeMeta(app, applicationKind)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := applicationKey(app.GetNamespace(), app.GetName())
+	eventType := watch.Added
+	if _, ok := s.applications[key]; ok {
+		eventType = watch.Modified
+	}
+	s.applications[key] = app
+	s.notify(watch.Event{Type: eventType, Object: app})
+}
+
+// DeleteApplication removes an Application and notifies application streams
+func (s *FakeServer) DeleteApplication(namespace, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := applicationKey(namespace, name)
+	app, ok := s.applications[key]
+	if !ok {
+		return
+	}
+	delete(s.applications, key)
+	s.notify(watch.Event{Type: watch.Deleted, Object: app})
+}
+
+// UpsertAppProject stores an AppProject
+func (s *FakeServer) UpsertAppProject(project *unstructured.Unstructured) {
+	project = project.DeepCopy()
+	setTypeMeta(project, appProjectKind)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.projects[project.GetName()] = project
+}
+
+// DeleteAppProject removes an AppProject
+func (s *FakeServer) DeleteAppProject(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.projects, name)
+}
+
+// UpsertCluster registers a destination cluster
+func (s *FakeServer) UpsertCluster(cluster argocdclusters.Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clusters[cluster.Server] = cluster
+}
+
+// DeleteCluster removes a destination cluster by server URL
+func (s *FakeServer) DeleteCluster(server string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clusters, server)
+}
+
+func applicationKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// notify must be called with the lock held
+func (s *FakeServer) notify(event watch.Event) {
+	for watcher := range s.watchers {
+		select {
+		case watcher <- event:
+		default:
+			// Slow readers miss events rather than blocking writers
+		}
+	}
+}
+
+func (s *FakeServer) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.authToken != "" && r.Header.Get("Authorization") != "Bearer "+s.authToken {
+			writeFakeError(w, http.StatusUnauthorized, "invalid session: token is invalid")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *FakeServer) getApplication(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/applications/")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, app := range s.applications {
+		if app.GetName() == name && matchesAppNamespace(app, r.URL.Query().Get("appNamespace")) {
+			writeFakeJSON(w, app.Object)
+			return
+		}
+	}
+	writeFakeError(w, http.StatusNotFound, "applications.argoproj.io \""+name+"\" not found")
+}
+
+func (s *FakeServer) listApplications(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	items := []interface{}{}
+	for _, app := range s.applications {
+		if matchesApplicationQuery(app, r) {
+			items = append(items, app.Object)
+		}
+	}
+	writeFakeJSON(w, map[string]interface{}{"metadata": map[string]interface{}{}, "items": items})
+}
+
+func (s *FakeServer) streamApplications(w http.ResponseWriter, r *http.Request) {
+	flusher, _ := w.(http.Flusher)
+	events := make(chan watch.Event, 100)
+
+	s.mu.Lock()
+	initial := []*unstructured.Unstructured{}
+	for _, app := range s.applications {
+		initial = append(initial, app)
+	}
+	s.watchers[events] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, events)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	write := func(event watch.Event) bool {
+		app := event.Object.(*unstructured.Unstructured)
+		if !matchesApplicationQuery(app, r) {
+			return true
+		}
+		message := map[string]interface{}{
+			"result": map[string]interface{}{"type": event.Type, "application": app.Object},
+		}
+		if err := encoder.Encode(message); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	for _, app := range initial {
+		if !write(watch.Event{Type: watch.Added, Object: app}) {
+			return
+		}
+	}
+	for {
+		select {
+		case event := <-events:
+			if !write(event) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *FakeServer) getProject(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/projects/")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	project, ok := s.projects[name]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "appprojects.argoproj.io \""+name+"\" not found")
+		return
+	}
+	writeFakeJSON(w, project.Object)
+}
+
+func (s *FakeServer) listProjects(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	items := []interface{}{}
+	for _, project := range s.projects {
+		items = append(items, project.Object)
+	}
+	writeFakeJSON(w, map[string]interface{}{"metadata": map[string]interface{}{}, "items": items})
+}
+
+func (s *FakeServer) listClusters(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	items := []interface{}{}
+	for _, cluster := range s.clusters {
+		items = append(items, map[string]interface{}{"name": cluster.Name, "server": cluster.Server})
+	}
+	writeFakeJSON(w, map[string]interface{}{"metadata": map[string]interface{}{}, "items": items})
+}
+
+func matchesAppNamespace(app *unstructured.Unstructured, appNamespace string) bool {
+	return appNamespace == "" || app.GetNamespace() == appNamespace
+}
+
+func matchesApplicationQuery(app *unstructured.Unstructured, r *http.Request) bool {
+	query := r.URL.Query()
+	if !matchesAppNamespace(app, query.Get("appNamespace")) {
+		return false
+	}
+	if name := query.Get("name"); name != "" && app.GetName() != name {
+		return false
+	}
+	if projects := query["projects"]; len(projects) > 0 {
+		project, _, _ := unstructured.NestedString(app.Object, "spec", "project")
+		for _, p := range projects {
+			if p == project {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func writeFakeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeFakeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": message, "message": message, "code": code})
+}
@@ -1 +1,437 @@
 package argocd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"argocd-pod-enrichment/pkg/argocdclusters"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	applicationKind    = "Application"
+	appProjectKind     = "AppProject"
+	argoprojAPIVersion = "argoproj.io/v1alpha1"
+
+	// The ArgoCD API has no stream endpoint for projects, project watches poll the list endpoint instead
+	defaultProjectPollInterval = 30 * time.Second
+)
+
+// ClientOptions configure the connection to the ArgoCD API server
+type ClientOptions struct {
+	// ServerAddr is the address of the ArgoCD API server, e.g. argocd-server.argocd.svc:443
+	ServerAddr string
+	// AuthToken is sent as a bearer token on every request
+	AuthToken string
+	// PlainText uses HTTP instead of HTTPS
+	PlainText bool
+	// Insecure skips verification of the server certificate
+	Insecure bool
+	// CACertFile is an optional PEM bundle used to verify the server certificate
+	CACertFile string
+	// ProjectPollInterval controls how often project watches list projects
+	ProjectPollInterval time.Duration
+	// HTTPClient overrides the HTTP client built from the TLS settings
+	HTTPClient *http.Client
+}
+
+// Client reads Applications and AppProjects from the ArgoCD API server
+type Client struct {
+	baseURL             *url.URL
+	authToken           string
+	httpClient          *http.Client
+	projectPollInterval time.Duration
+}
+
+// ListOptions filter list and watch requests
+type ListOptions struct {
+	// Namespace is the Application namespace, empty means the ArgoCD namespace
+	Namespace string
+	// Name restricts a watch to a single Application
+	Name     string
+	Projects []string
+	Selector string
+}
+
+// NewClient initializes an ArgoCD API client
+func NewClient(opts ClientOptions) (*Client, error) {
+	if opts.ServerAddr == "" {
+		return nil, fmt.Errorf("ArgoCD server address is required")
+	}
+
+	scheme := "https"
+	if opts.PlainText {
+		scheme = "http"
+	}
+	serverAddr := opts.ServerAddr
+	if !strings.Contains(serverAddr, "://") {
+		serverAddr = scheme + "://" + serverAddr
+	}
+	baseURL, err := url.Parse(serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ArgoCD server address: %w", err)
+	}
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		tlsConfig := &tls.Config{InsecureSkipVerify: opts.Insecure}
+		if opts.CACertFile != "" {
+			caCert, err := os.ReadFile(opts.CACertFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ArgoCD server CA file: %w", err)
+			}
+			certPool := x509.NewCertPool()
+			if !certPool.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("no certificates found in ArgoCD server CA file %s", opts.CACertFile)
+			}
+			tlsConfig.RootCAs = certPool
+		}
+		httpClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}}
+	}
+
+	projectPollInterval := opts.ProjectPollInterval
+	if projectPollInterval == 0 {
+		projectPollInterval = defaultProjectPollInterval
+	}
+
+	return &Client{
+		baseURL:             baseURL,
+		authToken:           opts.AuthToken,
+		httpClient:          httpClient,
+		projectPollInterval: projectPollInterval,
+	}, nil
+}
+
+// GetApplication fetches an Application by namespace and name
+func (c *Client) GetApplication(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	query := url.Values{}
+	if namespace != "" {
+		query.Set("appNamespace", namespace)
+	}
+	app := &unstructured.Unstructured{}
+	if err := c.getJSON(ctx, "/api/v1/applications/"+url.PathEscape(name), query, &app.Object); err != nil {
+		return nil, err
+	}
+	setTypeMeta(app, applicationKind)
+	return app, nil
+}
+
+// ListApplications lists Applications matching the options
+func (c *Client) ListApplications(ctx context.Context, opts ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := c.list(ctx, "/api/v1/applications", opts.applicationQuery())
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		setTypeMeta(&list.Items[i], applicationKind)
+	}
+	return list, nil
+}
+
+// WatchApplications streams Application changes matching the options.
+// The watch ends when ctx is cancelled, the returned watcher is stopped or the server closes the stream.
+func (c *Client) WatchApplications(ctx context.Context, opts ListOptions) (watch.Interface, error) {
+	resp, err := c.do(ctx, "/api/v1/stream/applications", opts.applicationQuery())
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan watch.Event)
+	watcher := watch.NewProxyWatcher(events)
+
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		go func() {
+			// Closing the body unblocks the reader below when the watch is stopped
+			select {
+			case <-watcher.StopChan():
+			case <-ctx.Done():
+			}
+			resp.Body.Close()
+		}()
+
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(strings.TrimSpace(string(line))) > 0 {
+				event, ok := decodeApplicationStreamEvent(line)
+				if !ok {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-watcher.StopChan():
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return watcher, nil
+}
+
+type applicationStreamMessage struct {
+	Result *struct {
+		Type        watch.EventType        `json:"type"`
+		Application map[string]interface{} `json:"application"`
+	} `json:"result"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func decodeApplicationStreamEvent(line []byte) (watch.Event, bool) {
+	var message applicationStreamMessage
+	if err := json.Unmarshal(line, &message); err != nil {
+		return errorEvent(http.StatusInternalServerError, fmt.Sprintf("failed to decode application stream: %v", err)), true
+	}
+	if message.Error != nil {
+		return errorEvent(message.Error.Code, message.Error.Message), true
+	}
+	if message.Result == nil || message.Result.Application == nil {
+		return watch.Event{}, false
+	}
+	app := &unstructured.Unstructured{Object: message.Result.Application}
+	setTypeMeta(app, applicationKind)
+	return watch.Event{Type: message.Result.Type, Object: app}, true
+}
+
+// GetAppProject fetches an AppProject by name
+func (c *Client) GetAppProject(ctx context.Context, name string) (*unstructured.Unstructured, error) {
+	project := &unstructured.Unstructured{}
+	if err := c.getJSON(ctx, "/api/v1/projects/"+url.PathEscape(name), nil, &project.Object); err != nil {
+		return nil, err
+	}
+	setTypeMeta(project, appProjectKind)
+	return project, nil
+}
+
+// ListAppProjects lists all AppProjects
+func (c *Client) ListAppProjects(ctx context.Context) (*unstructured.UnstructuredList, error) {
+	list, err := c.list(ctx, "/api/v1/projects", nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		setTypeMeta(&list.Items[i], appProjectKind)
+	}
+	return list, nil
+}
+
+// WatchAppProjects emits AppProject changes by periodically listing projects and comparing resource versions
+func (c *Client) WatchAppProjects(ctx context.Context) (watch.Interface, error) {
+	initial, err := c.ListAppProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan watch.Event)
+	watcher := watch.NewProxyWatcher(events)
+
+	go func() {
+		defer close(events)
+
+		send := func(event watch.Event) bool {
+			select {
+			case events <- event:
+				return true
+			case <-watcher.StopChan():
+				return false
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		known := map[string]*unstructured.Unstructured{}
+		for i := range initial.Items {
+			project := &initial.Items[i]
+			known[project.GetName()] = project
+			if !send(watch.Event{Type: watch.Added, Object: project}) {
+				return
+			}
+		}
+
+		ticker := time.NewTicker(c.projectPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-watcher.StopChan():
+				return
+			case <-ctx.Done():
+				return
+			}
+
+			current, err := c.ListAppProjects(ctx)
+			if err != nil {
+				if !send(errorEvent(http.StatusInternalServerError, err.Error())) {
+					return
+				}
+				continue
+			}
+
+			seen := map[string]bool{}
+			for i := range current.Items {
+				project := &current.Items[i]
+				seen[project.GetName()] = true
+				previous, ok := known[project.GetName()]
+				known[project.GetName()] = project
+				if !ok {
+					if !send(watch.Event{Type: watch.Added, Object: project}) {
+						return
+					}
+				} else if previous.GetResourceVersion() != project.GetResourceVersion() {
+					if !send(watch.Event{Type: watch.Modified, Object: project}) {
+						return
+					}
+				}
+			}
+			for name, project := range known {
+				if !seen[name] {
+					delete(known, name)
+					if !send(watch.Event{Type: watch.Deleted, Object: project}) {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return watcher, nil
+}
+
+// ListClusters lists the clusters registered in ArgoCD
+func (c *Client) ListClusters(ctx context.Context) ([]argocdclusters.Identity, error) {
+	var body struct {
+		Items []struct {
+			Name   string `json:"name"`
+			Server string `json:"server"`
+		} `json:"items"`
+	}
+	if err := c.getJSON(ctx, "/api/v1/clusters", nil, &body); err != nil {
+		return nil, err
+	}
+	clusters := []argocdclusters.Identity{}
+	for _, item := range body.Items {
+		clusters = append(clusters, argocdclusters.Identity{Name: item.Name, Server: item.Server})
+	}
+	return clusters, nil
+}
+
+func (opts ListOptions) applicationQuery() url.Values {
+	query := url.Values{}
+	if opts.Namespace != "" {
+		query.Set("appNamespace", opts.Namespace)
+	}
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+	for _, project := range opts.Projects {
+		query.Add("projects", project)
+	}
+	if opts.Selector != "" {
+		query.Set("selector", opts.Selector)
+	}
+	return query
+}
+
+func (c *Client) list(ctx context.Context, path string, query url.Values) (*unstructured.UnstructuredList, error) {
+	var body struct {
+		Metadata map[string]interface{}   `json:"metadata"`
+		Items    []map[string]interface{} `json:"items"`
+	}
+	if err := c.getJSON(ctx, path, query, &body); err != nil {
+		return nil, err
+	}
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+	if body.Metadata != nil {
+		list.Object["metadata"] = body.Metadata
+	}
+	for _, item := range body.Items {
+		list.Items = append(list.Items, unstructured.Unstructured{Object: item})
+	}
+	return list, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, into interface{}) error {
+	resp, err := c.do(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+		return fmt.Errorf("failed to decode ArgoCD API response from %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	requestURL := *c.baseURL
+	requestURL.Path = strings.TrimSuffix(requestURL.Path, "/") + path
+	requestURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ArgoCD API request to %s failed: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newAPIError(resp.StatusCode, path, body)
+	}
+	return resp, nil
+}
+
+func setTypeMeta(obj *unstructured.Unstructured, kind string) {
+	// The ArgoCD API omits apiVersion and kind from its responses
+	if obj.GetAPIVersion() == "" {
+		obj.SetAPIVersion(argoprojAPIVersion)
+	}
+	if obj.GetKind() == "" {
+		obj.SetKind(kind)
+	}
+}
+
+func errorEvent(code int, message string) watch.Event {
+	return watch.Event{
+		Type: watch.Error,
+		Object: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    int32(code),
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,380 @@
+package argocd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"argocd-pod-enrichment/pkg/argocdclusters"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const testToken = "secret-token"
+
+func applicationObject(namespace, name, project string) *unstructured.Unstructured {
+	app := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"project": project},
+	}}
+	app.SetNamespace(namespace)
+	app.SetName(name)
+	return app
+}
+
+func projectObject(name, resourceVersion string) *unstructured.Unstructured {
+	project := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	project.SetNamespace("argocd")
+	project.SetName(name)
+	project.SetResourceVersion(resourceVersion)
+	return project
+}
+
+func names(items []unstructured.Unstructured) []string {
+	names := []string{}
+	for _, item := range items {
+		names = append(names, item.GetNamespace()+"/"+item.GetName())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nextEvent waits for the next event of a watch, failing the test if none arrives in time
+func nextEvent(t *testing.T, watcher watch.Interface) watch.Event {
+	t.Helper()
+	select {
+	case event, ok := <-watcher.ResultChan():
+		if !ok {
+			t.Fatalf("watch closed, want an event")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a watch event")
+	}
+	return watch.Event{}
+}
+
+func TestGetApplication(t *testing.T) {
+	server := NewFakeServer(testToken)
+	defer server.Close()
+	server.UpsertApplication(applicationObject("argocd", "guestbook", "default"))
+	server.UpsertApplication(applicationObject("team-a", "checkout", "shop"))
+	client := server.Client()
+
+	tests := []struct {
+		name      string
+		namespace string
+		app       string
+		want      string
+		notFound  bool
+	}{
+		{name: "any namespace", app: "guestbook", want: "argocd/guestbook"},
+		{name: "application namespace", namespace: "team-a", app: "checkout", want: "team-a/checkout"},
+		{name: "other application namespace", namespace: "team-b", app: "checkout", notFound: true},
+		{name: "missing application", app: "missing", notFound: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app, err := client.GetApplication(context.Background(), test.namespace, test.app)
+			if test.notFound {
+				if !apierrors.IsNotFound(err) {
+					t.Fatalf("GetApplication() error = %v, want NotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetApplication() error = %v", err)
+			}
+			if got := app.GetNamespace() + "/" + app.GetName(); got != test.want {
+				t.Errorf("GetApplication() = %s, want %s", got, test.want)
+			}
+			if app.GetAPIVersion() != argoprojAPIVersion || app.GetKind() != applicationKind {
+				t.Errorf("GetApplication() type = %s %s, want %s %s", app.GetAPIVersion(), app.GetKind(), argoprojAPIVersion, applicationKind)
+			}
+		})
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	server := NewFakeServer(testToken)
+	defer server.Close()
+	client, err := NewClient(ClientOptions{ServerAddr: server.URL, AuthToken: "wrong", HTTPClient: server.Server.Client()})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	if _, err := client.ListApplications(context.Background(), ListOptions{}); !apierrors.IsUnauthorized(err) {
+		t.Errorf("ListApplications() error = %v, want Unauthorized", err)
+	}
+	if _, err := client.WatchApplications(context.Background(), ListOptions{}); !apierrors.IsUnauthorized(err) {
+		t.Errorf("WatchApplications() error = %v, want Unauthorized", err)
+	}
+	if _, err := client.WatchAppProjects(context.Background()); !apierrors.IsUnauthorized(err) {
+		t.Errorf("WatchAppProjects() error = %v, want Unauthorized", err)
+	}
+}
+
+func TestListApplications(t *testing.T) {
+	server := NewFakeServer(testToken)
+	defer server.Close()
+	server.UpsertApplication(applicationObject("argocd", "guestbook", "default"))
+	server.UpsertApplication(applicationObject("team-a", "checkout", "shop"))
+	server.UpsertApplication(applicationObject("team-a", "cart", "shop"))
+	server.UpsertApplication(applicationObject("team-b", "billing", "finance"))
+	client := server.Client()
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{name: "all", want: []string{"argocd/guestbook", "team-a/cart", "team-a/checkout", "team-b/billing"}},
+		{name: "namespace", opts: ListOptions{Namespace: "team-a"}, want: []string{"team-a/cart", "team-a/checkout"}},
+		{name: "projects", opts: ListOptions{Projects: []string{"default", "finance"}}, want: []string{"argocd/guestbook", "team-b/billing"}},
+		{name: "name", opts: ListOptions{Name: "cart"}, want: []string{"team-a/cart"}},
+		{name: "no match", opts: ListOptions{Namespace: "team-b", Projects: []string{"shop"}}, want: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list, err := client.ListApplications(context.Background(), test.opts)
+			if err != nil {
+				t.Fatalf("ListApplications() error = %v", err)
+			}
+			if got := names(list.Items); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ListApplications() = %v, want %v", got, test.want)
+			}
+			for _, item := range list.Items {
+				if item.GetKind() != applicationKind {
+					t.Errorf("ListApplications() item kind = %q, want %q", item.GetKind(), applicationKind)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchApplications(t *testing.T) {
+	server := NewFakeServer(testToken)
+	defer server.Close()
+	server.UpsertApplication(applicationObject("team-a", "checkout", "shop"))
+	server.UpsertApplication(applicationObject("team-b", "billing", "finance"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := server.Client().WatchApplications(ctx, ListOptions{Namespace: "team-a"})
+	if err != nil {
+		t.Fatalf("WatchApplications() error = %v", err)
+	}
+	defer watcher.Stop()
+
+	expect := func(eventType watch.EventType, name string) {
+		t.Helper()
+		event := nextEvent(t, watcher)
+		app, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			t.Fatalf("event object = %T, want an Application", event.Object)
+		}
+		if event.Type != eventType || app.GetName() != name || app.GetKind() != applicationKind {
+			t.Fatalf("event = %s %s %s, want %s %s", event.Type, app.GetKind(), app.GetName(), eventType, name)
+		}
+	}
+
+	expect(watch.Added, "checkout")
+	// Changes outside the watched namespace are filtered by the server
+	server.UpsertApplication(applicationObject("team-b", "billing", "default"))
+	server.UpsertApplication(applicationObject("team-a", "checkout", "default"))
+	expect(watch.Modified, "checkout")
+	server.UpsertApplication(applicationObject("team-a", "cart", "shop"))
+	expect(watch.Added, "cart")
+	server.DeleteApplication("team-a", "checkout")
+	expect(watch.Deleted, "checkout")
+
+	cancel()
+	select {
+	case _, ok := <-watcher.ResultChan():
+		if ok {
+			t.Errorf("watch received an event after the context was cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("watch not closed after the context was cancelled")
+	}
+}
+
+func TestDecodeApplicationStreamEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		wantType watch.EventType
+		wantCode int32
+		wantOK   bool
+	}{
+		{name: "result", line: `{"result":{"type":"MODIFIED","application":{"metadata":{"name":"guestbook"}}}}`, wantType: watch.Modified, wantOK: true},
+		{name: "stream error", line: `{"error":{"code":7,"message":"permission denied"}}`, wantType: watch.Error, wantCode: 7, wantOK: true},
+		{name: "invalid json", line: `{"result":`, wantType: watch.Error, wantCode: http.StatusInternalServerError, wantOK: true},
+		{name: "keepalive", line: `{}`, wantOK: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, ok := decodeApplicationStreamEvent([]byte(test.line))
+			if ok != test.wantOK {
+				t.Fatalf("decodeApplicationStreamEvent() ok = %t, want %t", ok, test.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if event.Type != test.wantType {
+				t.Errorf("decodeApplicationStreamEvent() type = %s, want %s", event.Type, test.wantType)
+			}
+			if status, isStatus := event.Object.(*metav1.Status); isStatus && status.Code != test.wantCode {
+				t.Errorf("decodeApplicationStreamEvent() code = %d, want %d", status.Code, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestAppProjects(t *testing.T) {
+	server := NewFakeServer(testToken)
+	defer server.Close()
+	server.UpsertAppProject(projectObject("default", "1"))
+	client := server.Client()
+
+	project, err := client.GetAppProject(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("GetAppProject() error = %v", err)
+	}
+	if project.GetName() != "default" || project.GetKind() != appProjectKind {
+		t.Errorf("GetAppProject() = %s %s, want %s default", project.GetKind(), project.GetName(), appProjectKind)
+	}
+	if _, err := client.GetAppProject(context.Background(), "missing"); !apierrors.IsNotFound(err) {
+		t.Errorf("GetAppProject() error = %v, want NotFound", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := client.WatchAppProjects(ctx)
+	if err != nil {
+		t.Fatalf("WatchAppProjects() error = %v", err)
+	}
+	defer watcher.Stop()
+
+	expect := func(eventType watch.EventType, name string) {
+		t.Helper()
+		event := nextEvent(t, watcher)
+		project, ok := event.Object.(*unstructured.Unstructured)
+		if !ok || event.Type != eventType || project.GetName() != name {
+			t.Fatalf("event = %s %v, want %s %s", event.Type, event.Object, eventType, name)
+		}
+	}
+
+	expect(watch.Added, "default")
+	server.UpsertAppProject(projectObject("shop", "1"))
+	expect(watch.Added, "shop")
+	// Projects are compared by resource version, an unchanged project emits nothing
+	server.UpsertAppProject(projectObject("shop", "1"))
+	server.UpsertAppProject(projectObject("default", "2"))
+	expect(watch.Modified, "default")
+	server.DeleteAppProject("shop")
+	expect(watch.Deleted, "shop")
+}
+
+func TestListClusters(t *testing.T) {
+	server := NewFakeServer("")
+	defer server.Close()
+	server.UpsertCluster(argocdclusters.Identity{Name: "in-cluster", Server: "https://kubernetes.default.svc"})
+	server.UpsertCluster(argocdclusters.Identity{Name: "prod", Server: "https://prod.example.com"})
+
+	clusters, err := server.Client().ListClusters(context.Background())
+	if err != nil {
+		t.Fatalf("ListClusters() error = %v", err)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	want := []argocdclusters.Identity{
+		{Name: "in-cluster", Server: "https://kubernetes.default.svc"},
+		{Name: "prod", Server: "https://prod.example.com"},
+	}
+	if !reflect.DeepEqual(clusters, want) {
+		t.Errorf("ListClusters() = %v, want %v", clusters, want)
+	}
+}
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        int
+		path        string
+		body        string
+		wantReason  metav1.StatusReason
+		wantGR      schema.GroupResource
+		wantName    string
+		wantMessage string
+	}{
+		{
+			name:        "application not found",
+			code:        http.StatusNotFound,
+			path:        "/api/v1/applications/guestbook",
+			body:        `{"error":"applications.argoproj.io \"guestbook\" not found","code":5,"message":"applications.argoproj.io \"guestbook\" not found"}`,
+			wantReason:  metav1.StatusReasonNotFound,
+			wantGR:      schema.GroupResource{Group: "argoproj.io", Resource: "applications"},
+			wantName:    "guestbook",
+			wantMessage: `applications.argoproj.io "guestbook" not found`,
+		},
+		{
+			name:        "forbidden project",
+			code:        http.StatusForbidden,
+			path:        "/api/v1/projects/shop",
+			body:        `{"message":"permission denied"}`,
+			wantReason:  metav1.StatusReasonForbidden,
+			wantGR:      schema.GroupResource{Group: "argoproj.io", Resource: "projects"},
+			wantName:    "shop",
+			wantMessage: "permission denied",
+		},
+		{
+			name:        "unauthorized stream",
+			code:        http.StatusUnauthorized,
+			path:        "/api/v1/stream/applications",
+			body:        `{"message":"invalid session"}`,
+			wantReason:  metav1.StatusReasonUnauthorized,
+			wantGR:      schema.GroupResource{Group: "argoproj.io", Resource: "applications"},
+			wantMessage: "invalid session",
+		},
+		{
+			name:        "plain text server error",
+			code:        http.StatusInternalServerError,
+			path:        "/api/v1/clusters",
+			body:        "upstream connect error\n",
+			wantReason:  metav1.StatusReasonInternalError,
+			wantGR:      schema.GroupResource{Group: "argoproj.io", Resource: "clusters"},
+			wantMessage: "upstream connect error",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := newAPIError(test.code, test.path, []byte(test.body))
+			var statusError *apierrors.StatusError
+			if !errors.As(err, &statusError) {
+				t.Fatalf("newAPIError() = %T, want a StatusError", err)
+			}
+			status := statusError.Status()
+			if status.Reason != test.wantReason || status.Code != int32(test.code) {
+				t.Errorf("newAPIError() reason = %s code = %d, want %s %d", status.Reason, status.Code, test.wantReason, test.code)
+			}
+			if status.Details == nil {
+				t.Fatalf("newAPIError() details = nil")
+			}
+			gotGR := schema.GroupResource{Group: status.Details.Group, Resource: status.Details.Kind}
+			if gotGR != test.wantGR || status.Details.Name != test.wantName {
+				t.Errorf("newAPIError() details = %v %q, want %v %q", gotGR, status.Details.Name, test.wantGR, test.wantName)
+			}
+			if !strings.Contains(status.Message, test.wantMessage) {
+				t.Errorf("newAPIError() message = %q, want it to contain %q", status.Message, test.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	controllerconsts "argocd-pod-enrichment/pkg/consts/controller"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/pkg/enrichment"
+)
+
+// Reasons recorded in the failure reason annotation
+const (
+	lookupFailureApplicationNotFound = "ApplicationNotFound"
+	lookupFailureForbidden           = "Forbidden"
+	lookupFailureError               = "LookupError"
+)
+
+func newLookupBackoff() workqueue.TypedRateLimiter[types.NamespacedName] {
+	return workqueue.NewTypedItemExponentialFailureRateLimiter[types.NamespacedName](time.Second, 5*time.Minute)
+}
+
+// lookupFailureReason classifies an Application lookup error
+func lookupFailureReason(err error) string {
+	switch {
+	case apierrors.IsNotFound(err):
+		return lookupFailureApplicationNotFound
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return lookupFailureForbidden
+	default:
+		return lookupFailureError
+	}
+}
+
+// handleLookupFailure retries a failed Application lookup with exponential backoff. Applications that do not
+// exist yet are expected during creation races and are retried quietly, other errors are logged as errors.
+// Once the retries are exhausted the pod is marked as failed and only an Application event enqueues it again.
+func (r *PodReconciler) handleLookupFailure(ctx context.Context, pod *corev1.Pod, lookupErr error) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	reason := lookupFailureReason(lookupErr)
+
+	if r.lookupBackoff.NumRequeues(key) >= r.maxLookupRetries() {
+		r.lookupBackoff.Forget(key)
+		log.Info("Giving up enriching pod, ArgoCD Application lookup keeps failing", "reason", reason, "error", lookupErr.Error())
+		metadata := failedMetadata(pod, reason+": "+lookupErr.Error())
+		// The rollout labels do not depend on the Application, they keep following the Rollout
+		metadata.Merge(r.rolloutMetadata(ctx, pod))
+		if err := r.applyMetadata(ctx, pod, metadata); err != nil {
+			log.Error(err, "unable to mark Pod as failed")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	delay := r.lookupBackoff.When(key)
+	if reason == lookupFailureApplicationNotFound {
+		log.Info("ArgoCD Application not found yet, retrying", "delay", delay)
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+	log.Error(lookupErr, "unable to get ArgoCD Application, retrying", "reason", reason, "delay", delay)
+	return ctrl.Result{RequeueAfter: delay}, nil
+}
+
+// lookupFailed reports whether the controller gave up enriching the pod. Such pods are only reconciled again
+// by an event of their Application, or once the status annotation is removed.
+func lookupFailed(obj client.Object) bool {
+	return obj.GetAnnotations()[webhookconsts.EnrichmentStatusAnnotationKey] == webhookconsts.EnrichmentStatusFailed
+}
+
+func (r *PodReconciler) maxLookupRetries() int {
+	if r.MaxLookupRetries > 0 {
+		return r.MaxLookupRetries
+	}
+	return controllerconsts.DefaultApplicationLookupMaxRetries
+}
+
+// failedMetadata keeps the keys the controller currently manages on the pod and adds the failure status
+func failedMetadata(pod *corev1.Pod, message string) *enrichment.Metadata {
+	metadata := enrichment.NewMetadata()
+	managedKeys := enrichment.ParseManagedKeys(pod.Annotations[webhookconsts.ManagedKeysAnnotationKey])
+	for _, key := range managedKeys.Labels {
+		if value, ok := pod.Labels[key]; ok {
+			metadata.Set(enrichment.TargetLabel, key, value)
+		}
+	}
+	for _, key := range managedKeys.Annotations {
+		if value, ok := pod.Annotations[key]; ok {
+			metadata.Set(enrichment.TargetAnnotation, key, value)
+		}
+	}
+	if value, ok := pod.Annotations[webhookconsts.ManagedKeysAnnotationKey]; ok {
+		metadata.Set(enrichment.TargetAnnotation, webhookconsts.ManagedKeysAnnotationKey, value)
+	}
+	metadata.Merge(existingSyncMetadata(pod))
+	metadata.Set(enrichment.TargetAnnotation, webhookconsts.EnrichmentStatusAnnotationKey, webhookconsts.EnrichmentStatusFailed)
+	metadata.Set(enrichment.TargetAnnotation, webhookconsts.EnrichmentFailureReasonAnnotationKey, message)
+	return metadata
+}
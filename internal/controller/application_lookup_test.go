@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rolloutconsts "argocd-pod-enrichment/pkg/consts/argorollouts"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+)
+
+func TestLookupFailureReason(t *testing.T) {
+	resource := schema.GroupResource{Group: "argoproj.io", Resource: "applications"}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "not found", err: apierrors.NewNotFound(resource, "checkout"), want: lookupFailureApplicationNotFound},
+		{name: "forbidden", err: apierrors.NewForbidden(resource, "checkout", errors.New("no access")), want: lookupFailureForbidden},
+		{name: "unauthorized", err: apierrors.NewUnauthorized("expired token"), want: lookupFailureForbidden},
+		{name: "other error", err: errors.New("connection refused"), want: lookupFailureError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := lookupFailureReason(test.err); got != test.want {
+				t.Errorf("lookupFailureReason() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHandleLookupFailureExhaustsRetries(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "shop",
+		Name:      "checkout-abcde",
+		Labels:    map[string]string{webhookconsts.ApplicationLabelKey: "checkout", "example.com/team": "payments"},
+		Annotations: map[string]string{
+			webhookconsts.ManagedKeysAnnotationKey: `{"labels":["example.com/team"]}`,
+		},
+	}}
+	r := &PodReconciler{
+		Client:           fake.NewClientBuilder().WithObjects(pod.DeepCopy()).Build(),
+		MaxLookupRetries: 2,
+		lookupBackoff:    newLookupBackoff(),
+	}
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	lookupErr := apierrors.NewNotFound(schema.GroupResource{Group: "argoproj.io", Resource: "applications"}, "checkout")
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		result, err := r.handleLookupFailure(ctx, pod, lookupErr)
+		if err != nil {
+			t.Fatalf("attempt %d: handleLookupFailure() error = %v", attempt, err)
+		}
+		if result.RequeueAfter <= 0 {
+			t.Fatalf("attempt %d: result = %+v, want a delayed requeue", attempt, result)
+		}
+	}
+
+	result, err := r.handleLookupFailure(ctx, pod, lookupErr)
+	if err != nil {
+		t.Fatalf("handleLookupFailure() error = %v", err)
+	}
+	if result.RequeueAfter != 0 {
+		t.Errorf("result = %+v, want no requeue once the retries are exhausted", result)
+	}
+	if requeues := r.lookupBackoff.NumRequeues(key); requeues != 0 {
+		t.Errorf("NumRequeues() = %d, want the backoff to be reset", requeues)
+	}
+
+	var marked corev1.Pod
+	if err := r.Get(ctx, key, &marked); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got := marked.Annotations[webhookconsts.EnrichmentStatusAnnotationKey]; got != webhookconsts.EnrichmentStatusFailed {
+		t.Errorf("status annotation = %q, want %q", got, webhookconsts.EnrichmentStatusFailed)
+	}
+	if got := marked.Annotations[webhookconsts.EnrichmentFailureReasonAnnotationKey]; !strings.HasPrefix(got, lookupFailureApplicationNotFound+": ") {
+		t.Errorf("failure reason annotation = %q, want it to start with %q", got, lookupFailureApplicationNotFound)
+	}
+	if got := marked.Labels["example.com/team"]; got != "payments" {
+		t.Errorf("managed label = %q, want it kept", got)
+	}
+	if !lookupFailed(&marked) {
+		t.Errorf("lookupFailed() = false for the marked pod")
+	}
+}
+
+func TestHandleLookupFailureKeepsRolloutLabels(t *testing.T) {
+	rollout := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec":   map[string]interface{}{"strategy": map[string]interface{}{"canary": map[string]interface{}{}}},
+		"status": map[string]interface{}{"stableRS": "bbb", "currentPodHash": "bbb", "currentStepIndex": int64(4)},
+	}}
+	rollout.SetGroupVersionKind(rolloutconsts.RolloutGVK)
+	rollout.SetNamespace("shop")
+	rollout.SetName("checkout")
+	r := &PodReconciler{
+		Client:           fake.NewClientBuilder().Build(),
+		MaxLookupRetries: 1,
+		lookupBackoff:    newLookupBackoff(),
+		rolloutCache:     fake.NewClientBuilder().WithObjects(rollout).Build(),
+	}
+	ctx := context.Background()
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "shop",
+		Name:      "checkout-abcde",
+		Labels: map[string]string{
+			webhookconsts.ApplicationLabelKey:         "checkout",
+			webhookconsts.RolloutNameLabelKey:         "checkout",
+			rolloutconsts.RolloutPodTemplateHashLabel: "bbb",
+		},
+	}}
+	if err := r.Create(ctx, pod); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	// A previous reconcile applied the rollout labels with the field manager of the controller
+	if err := r.applyMetadata(ctx, pod, r.rolloutMetadata(ctx, pod)); err != nil {
+		t.Fatalf("applyMetadata() error = %v", err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pod), pod); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	lookupErr := apierrors.NewNotFound(schema.GroupResource{Group: "argoproj.io", Resource: "applications"}, "checkout")
+	for attempt := 1; attempt <= 2; attempt++ {
+		if _, err := r.handleLookupFailure(ctx, pod, lookupErr); err != nil {
+			t.Fatalf("attempt %d: handleLookupFailure() error = %v", attempt, err)
+		}
+	}
+
+	var marked corev1.Pod
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pod), &marked); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !lookupFailed(&marked) {
+		t.Fatalf("lookupFailed() = false, want the retries to be exhausted")
+	}
+	want := map[string]string{webhookconsts.RolloutRoleLabelKey: rolloutconsts.RolloutRoleStable, webhookconsts.RolloutStepIndexLabelKey: "4"}
+	for key, value := range want {
+		if got := marked.Labels[key]; got != value {
+			t.Errorf("label %s = %q, want %q", key, got, value)
+		}
+	}
+}
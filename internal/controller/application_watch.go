@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"argocd-pod-enrichment/internal/argocd"
+	argocdconsts "argocd-pod-enrichment/pkg/consts/argocd"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/pkg/enrichment"
+)
+
+// applicationSource returns the source of Application events that re-enqueue the pods of the Application.
+// Applications come from the ArgoCD API stream when an ArgoCD client is configured, otherwise from the
+// Application informer of ApplicationCache.
+func (r *PodReconciler) applicationSource(mgr ctrl.Manager) (source.Source, error) {
+	podsHandler := handler.EnqueueRequestsFromMapFunc(r.podsForApplication)
+
+	if r.ArgoCDClient != nil {
+		events := make(chan event.GenericEvent)
+		if err := mgr.Add(r.runnable(func(ctx context.Context) error {
+			defer close(events)
+			r.streamApplications(ctx, events)
+			return nil
+		})); err != nil {
+			return nil, err
+		}
+		return source.Channel(events, podsHandler), nil
+	}
+
+	applicationCache := r.ApplicationCache
+	if applicationCache == nil {
+		applicationCache = mgr.GetCache()
+	}
+
+	app := &unstructured.Unstructured{}
+	app.SetGroupVersionKind(argocdconsts.ApplicationGVK)
+
+	return source.Kind(applicationCache, client.Object(app), podsHandler, predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return r.applicationFingerprint(e.ObjectOld) != r.applicationFingerprint(e.ObjectNew)
+		},
+	}), nil
+}
+
+// streamApplications forwards Application changes from the ArgoCD API to events until ctx is done.
+// The stream is re-established with backoff when the server closes it or fails.
+func (r *PodReconciler) streamApplications(ctx context.Context, events chan<- event.GenericEvent) {
+	log := logf.FromContext(ctx).WithName("application-stream")
+	backoff := workqueue.NewTypedItemExponentialFailureRateLimiter[string](time.Second, 5*time.Minute)
+	fingerprints := map[types.NamespacedName]string{}
+
+	for ctx.Err() == nil {
+		watcher, err := r.ArgoCDClient.WatchApplications(ctx, argocd.ListOptions{})
+		if err != nil {
+			delay := backoff.When("stream")
+			log.Error(err, "unable to watch ArgoCD Applications, retrying", "delay", delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		for result := range watcher.ResultChan() {
+			app, ok := result.Object.(*unstructured.Unstructured)
+			if !ok {
+				log.Info("ArgoCD Application stream error", "event", result.Object)
+				continue
+			}
+			backoff.Forget("stream")
+
+			key := types.NamespacedName{Namespace: app.GetNamespace(), Name: app.GetName()}
+			fingerprint := r.applicationFingerprint(app)
+			if result.Type == watch.Deleted {
+				delete(fingerprints, key)
+			} else if previous, ok := fingerprints[key]; ok && previous == fingerprint {
+				continue
+			} else {
+				fingerprints[key] = fingerprint
+			}
+
+			select {
+			case events <- event.GenericEvent{Object: app}:
+			case <-ctx.Done():
+				watcher.Stop()
+				return
+			}
+		}
+		watcher.Stop()
+	}
+}
+
+// applicationFingerprint summarizes the parts of an Application that are copied to pods,
+// so that the frequent status-only updates of Applications do not re-enqueue every pod
+func (r *PodReconciler) applicationFingerprint(obj client.Object) string {
+	app, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return ""
+	}
+	spec, _, _ := unstructured.NestedMap(app.Object, "spec")
+	fingerprint := struct {
+		Labels      map[string]string
+		Annotations map[string]string
+		Spec        map[string]interface{}
+		Health      string
+		Sync        string
+	}{
+		Labels:      app.GetLabels(),
+		Annotations: app.GetAnnotations(),
+		Spec:        spec,
+	}
+	if r.enrichmentConfig().MirrorApplicationStatus {
+		fingerprint.Health, fingerprint.Sync = enrichment.ApplicationStatus(app)
+	}
+	// Maps are marshalled with sorted keys, so equal Applications have equal fingerprints
+	encoded, _ := json.Marshal(fingerprint)
+	return string(encoded)
+}
+
+// podsForApplication maps an Application to reconcile requests for its pods
+func (r *PodReconciler) podsForApplication(ctx context.Context, obj client.Object) []reconcile.Request {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.MatchingFields{applicationIndexField: applicationIndexKey(obj.GetNamespace(), obj.GetName())}); err != nil {
+		logf.FromContext(ctx).Error(err, "unable to list pods of Application", "app", obj.GetName(), "appNamespace", obj.GetNamespace())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}})
+	}
+	return requests
+}
+
+// applicationIndexField indexes pods by the namespace and name of their Application
+const applicationIndexField = "codefresh.io/application"
+
+func applicationIndexKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// indexPodsByApplication registers the pod field index used to map Applications to their pods
+func indexPodsByApplication(ctx context.Context, mgr ctrl.Manager, argocdNamespace string) error {
+	return mgr.GetFieldIndexer().IndexField(ctx, &corev1.Pod{}, applicationIndexField, func(obj client.Object) []string {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return nil
+		}
+		namespace, name := applicationRef(pod, argocdNamespace)
+		if namespace == "" || name == "" {
+			return nil
+		}
+		return []string{applicationIndexKey(namespace, name)}
+	})
+}
+
+// applicationRef returns the namespace and name of the Application a pod belongs to.
+// The namespace falls back to argocdNamespace when the pod has no namespace label.
+func applicationRef(pod *corev1.Pod, argocdNamespace string) (string, string) {
+	namespace := pod.Labels[webhookconsts.ApplicationNamespaceLabelKey]
+	if namespace == "" {
+		namespace = argocdNamespace
+	}
+	return namespace, pod.Labels[webhookconsts.ApplicationLabelKey]
+}
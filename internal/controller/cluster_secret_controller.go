@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"argocd-pod-enrichment/pkg/argocdclusters"
+	"argocd-pod-enrichment/pkg/kubernetesclient"
+)
+
+// ClusterSecretReconciler watches ArgoCD cluster secrets and runs a pod controller for every managed cluster.
+// Applications are always resolved through the control plane clients, so managed clusters need no ArgoCD CRDs.
+type ClusterSecretReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// ArgoCDNamespace is the namespace holding the ArgoCD cluster secrets
+	ArgoCDNamespace string
+	// WatchNamespaces restricts the pod controllers of managed clusters to these namespaces, all if empty
+	WatchNamespaces []string
+	// PodReconciler is the template for the pod controllers of managed clusters.
+	// Its Kubernetes and ArgoCD clients must point to the control plane.
+	PodReconciler PodReconciler
+
+	mu       sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	clusters map[string]*managedCluster
+	// restarts re-enqueues the secrets of clusters whose pod controller stopped with an error
+	restarts       chan event.GenericEvent
+	restartBackoff workqueue.TypedRateLimiter[string]
+}
+
+type managedCluster struct {
+	hash   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile starts, restarts or stops the pod controller of the cluster described by a cluster secret.
+func (r *ClusterSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	var secret corev1.Secret
+	if err := r.Get(ctx, req.NamespacedName, &secret); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to fetch cluster secret")
+			return ctrl.Result{}, err
+		}
+		r.removeCluster(ctx, req.Name)
+		return ctrl.Result{}, nil
+	}
+
+	if secret.DeletionTimestamp != nil || !argocdclusters.IsClusterSecret(&secret) {
+		r.removeCluster(ctx, req.Name)
+		return ctrl.Result{}, nil
+	}
+
+	cluster, err := argocdclusters.ClusterFromSecret(&secret)
+	if err != nil {
+		log.Error(err, "invalid cluster secret, skipping")
+		r.removeCluster(ctx, req.Name)
+		return ctrl.Result{}, nil
+	}
+
+	if cluster.IsInCluster() {
+		// Pods of the local cluster are handled by the main pod controller
+		log.Info("Skipping in-cluster destination", "cluster", cluster.Name)
+		return ctrl.Result{}, nil
+	}
+
+	r.mu.Lock()
+	running, ok := r.clusters[req.Name]
+	r.mu.Unlock()
+	if ok && running.hash == cluster.Hash() {
+		return ctrl.Result{}, nil
+	}
+
+	// Connection details changed, reconnect with the new ones
+	r.stopCluster(ctx, req.Name)
+
+	if err := r.startCluster(ctx, cluster); err != nil {
+		log.Error(err, "unable to start pod controller for cluster", "cluster", cluster.Name, "server", cluster.Server)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+func (r *ClusterSecretReconciler) startCluster(ctx context.Context, cluster *argocdclusters.Cluster) error {
+	log := logf.FromContext(ctx).WithValues("cluster", cluster.Name, "server", cluster.Server)
+
+	restConfig, err := cluster.RestConfig()
+	if err != nil {
+		return err
+	}
+
+	cacheOptions := cache.Options{ByObject: map[client.Object]cache.ByObject{
+		&corev1.Pod{}: PodCacheByObject(),
+	}}
+	if len(r.WatchNamespaces) > 0 {
+		cacheOptions.DefaultNamespaces = map[string]cache.Config{}
+		for _, namespace := range r.WatchNamespaces {
+			cacheOptions.DefaultNamespaces[namespace] = cache.Config{}
+		}
+	}
+
+	clusterMgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                 r.Scheme,
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: "0",
+		Cache:                  cacheOptions,
+		Logger:                 ctrl.Log.WithName("cluster").WithValues("cluster", cluster.Name),
+		// Every managed cluster runs its own pod controller
+		Controller: config.Controller{SkipNameValidation: ptr.To(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Owners of unlabelled pods live in the managed cluster
+	ownerClient, err := kubernetesclient.NewKubernetesClientForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	if r.PodReconciler.KubernetesClient != nil {
+		ownerClient.OwnerBridgeRules = r.PodReconciler.KubernetesClient.OwnerBridgeRules
+	}
+
+	ownerClient.Namespaces = r.WatchNamespaces
+
+	podReconciler := r.clusterPodReconciler(cluster, clusterMgr.GetClient(), clusterMgr.GetScheme(), ownerClient)
+	if err := podReconciler.SetupWithManager(clusterMgr); err != nil {
+		return err
+	}
+
+	clusterCtx, cancel := context.WithCancel(r.ctx)
+	running := &managedCluster{hash: cluster.Hash(), cancel: cancel, done: make(chan struct{})}
+
+	r.mu.Lock()
+	r.clusters[cluster.SecretName] = running
+	r.mu.Unlock()
+
+	go func() {
+		log.Info("Starting pod controller for cluster")
+		err := clusterMgr.Start(clusterCtx)
+		r.mu.Lock()
+		if r.clusters[cluster.SecretName] == running {
+			delete(r.clusters, cluster.SecretName)
+		}
+		r.mu.Unlock()
+		close(running.done)
+
+		// A stopped cluster was removed or is restarted with new connection details, a failed one is retried
+		if err == nil || clusterCtx.Err() != nil {
+			return
+		}
+		delay := r.restartBackoff.When(cluster.SecretName)
+		log.Error(err, "pod controller for cluster stopped with error, restarting", "after", delay)
+		r.requeueCluster(cluster.SecretName, delay)
+	}()
+
+	return nil
+}
+
+// clusterPodReconciler returns the pod reconciler of a managed cluster, built from the template.
+// The template may already be set up for the control plane, so its internal state is dropped, in particular
+// the Rollout cache of the control plane, and rebuilt by SetupWithManager from the manager of the cluster.
+func (r *ClusterSecretReconciler) clusterPodReconciler(cluster *argocdclusters.Cluster, clusterClient client.Client, scheme *runtime.Scheme, ownerClient *kubernetesclient.KubernetesClient) *PodReconciler {
+	identity := cluster.Identity()
+	podReconciler := r.PodReconciler
+	podReconciler.lookupBackoff = nil
+	podReconciler.policyParser = nil
+	podReconciler.untrackedOwners = nil
+	podReconciler.rolloutCache = nil
+
+	podReconciler.OwnerClient = ownerClient
+	podReconciler.Client = clusterClient
+	podReconciler.Scheme = scheme
+	podReconciler.ClusterName = cluster.Name
+	podReconciler.ClusterIdentity = &identity
+	podReconciler.ClusterResolver = nil
+	// The cluster secrets are watched by the leader only, which enriches every namespace of the managed clusters
+	podReconciler.Shard = nil
+	return &podReconciler
+}
+
+// requeueCluster enqueues the cluster secret again after delay, unless the manager shuts down first
+func (r *ClusterSecretReconciler) requeueCluster(secretName string, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.ctx.Done():
+		return
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: r.ArgoCDNamespace}}
+	select {
+	case r.restarts <- event.GenericEvent{Object: secret}:
+	case <-r.ctx.Done():
+	}
+}
+
+// removeCluster stops the pod controller of a cluster whose secret is gone, and forgets its restart failures
+func (r *ClusterSecretReconciler) removeCluster(ctx context.Context, secretName string) {
+	r.restartBackoff.Forget(secretName)
+	r.stopCluster(ctx, secretName)
+}
+
+func (r *ClusterSecretReconciler) stopCluster(ctx context.Context, secretName string) {
+	r.mu.Lock()
+	running, ok := r.clusters[secretName]
+	delete(r.clusters, secretName)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	logf.FromContext(ctx).Info("Stopping pod controller for cluster", "secret", secretName)
+	running.cancel()
+	<-running.done
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.clusters = map[string]*managedCluster{}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.restarts = make(chan event.GenericEvent)
+	r.restartBackoff = workqueue.NewTypedItemExponentialFailureRateLimiter[string](time.Second, 5*time.Minute)
+
+	// Managed cluster controllers live as long as the manager, stop them when it shuts down
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		r.cancel()
+		r.mu.Lock()
+		running := make([]*managedCluster, 0, len(r.clusters))
+		for _, cluster := range r.clusters {
+			running = append(running, cluster)
+		}
+		r.mu.Unlock()
+		for _, cluster := range running {
+			<-cluster.done
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Secret{}).
+		WatchesRawSource(source.Channel(r.restarts, &handler.EnqueueRequestForObject{})).
+		WithEventFilter(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetNamespace() == r.ArgoCDNamespace
+		})).
+		Named("cluster-secret").
+		Complete(r)
+}
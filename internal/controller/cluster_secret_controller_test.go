@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"argocd-pod-enrichment/pkg/argocdclusters"
+	"argocd-pod-enrichment/pkg/enrichmentpolicy"
+	"argocd-pod-enrichment/pkg/kubernetesclient"
+)
+
+func TestClusterPodReconcilerDropsControlPlaneState(t *testing.T) {
+	controlPlane := fake.NewClientBuilder().Build()
+	r := &ClusterSecretReconciler{PodReconciler: PodReconciler{
+		Client:        controlPlane,
+		WatchRollouts: true,
+		// The state SetupWithManager left on the template for the control plane
+		lookupBackoff:   newLookupBackoff(),
+		policyParser:    &enrichmentpolicy.Parser{},
+		untrackedOwners: utilcache.NewLRUExpireCache(untrackedOwnersCacheSize),
+		rolloutCache:    controlPlane,
+	}}
+	cluster := &argocdclusters.Cluster{SecretName: "cluster-staging", Name: "staging", Server: "https://staging.example.com"}
+	clusterClient := fake.NewClientBuilder().Build()
+	ownerClient := &kubernetesclient.KubernetesClient{}
+
+	got := r.clusterPodReconciler(cluster, clusterClient, runtime.NewScheme(), ownerClient)
+
+	if got.rolloutCache != nil {
+		t.Error("rolloutCache is the Rollout cache of the control plane, want nil until SetupWithManager")
+	}
+	if got.lookupBackoff != nil || got.policyParser != nil || got.untrackedOwners != nil {
+		t.Error("internal state of the template was copied to the cluster pod reconciler")
+	}
+	if got.Client != clusterClient || got.OwnerClient != ownerClient {
+		t.Error("clients of the cluster pod reconciler do not point to the managed cluster")
+	}
+	if got.ClusterName != "staging" || got.ClusterIdentity == nil || got.ClusterIdentity.Server != "https://staging.example.com" {
+		t.Errorf("cluster of the pod reconciler = %q, %+v, want staging", got.ClusterName, got.ClusterIdentity)
+	}
+	if !got.WatchRollouts {
+		t.Error("WatchRollouts of the template was not kept")
+	}
+	if r.PodReconciler.rolloutCache == nil || r.PodReconciler.Client != controlPlane {
+		t.Error("template was modified")
+	}
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	enrichmentconsts "argocd-pod-enrichment/pkg/consts/enrichment"
+	"argocd-pod-enrichment/pkg/enrichment"
+)
+
+// defaultProject is the AppProject of Applications without spec.project
+const defaultProject = "default"
+
+// expressionInput returns the objects the enrichment expressions are evaluated over. The owner chain and the
+// AppProject cost API calls, they are only read when references reports that an expression uses them.
+func (r *PodReconciler) expressionInput(ctx context.Context, pod *corev1.Pod, app *unstructured.Unstructured, references func(variable string) bool) (*enrichment.ExpressionInput, error) {
+	input := &enrichment.ExpressionInput{Pod: pod, App: app}
+
+	if references(enrichmentconsts.ExpressionVariableOwners) {
+		unstructuredPod, err := toUnstructuredPod(pod)
+		if err != nil {
+			return nil, err
+		}
+		chain, err := r.ownerClient().GetControllerOwnerChain(unstructuredPod)
+		if err != nil {
+			return nil, fmt.Errorf("error getting owner chain: %w", err)
+		}
+		// The chain starts with the pod itself
+		input.Owners = chain[1:]
+	}
+
+	if references(enrichmentconsts.ExpressionVariableProject) {
+		project, err := r.getAppProject(ctx, app)
+		if apierrors.IsNotFound(err) {
+			logf.FromContext(ctx).Info("AppProject of the Application not found, evaluating expressions without it", "app", app.GetName())
+		} else if err != nil {
+			return nil, fmt.Errorf("error getting AppProject: %w", err)
+		}
+		input.Project = project
+	}
+	return input, nil
+}
+
+// getAppProject fetches the AppProject of the Application from the ArgoCD API server if configured, otherwise
+// from the ArgoCD namespace, where ArgoCD keeps the AppProjects of every Application namespace
+func (r *PodReconciler) getAppProject(ctx context.Context, app *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	name, _, _ := unstructured.NestedString(app.Object, "spec", "project")
+	if name == "" {
+		name = defaultProject
+	}
+	if r.ArgoCDClient != nil {
+		return r.ArgoCDClient.GetAppProject(ctx, name)
+	}
+	namespace := r.ArgoCDNamespace
+	if namespace == "" {
+		namespace = app.GetNamespace()
+	}
+	return r.KubernetesClient.GetArgoCDAppProject(ctx, namespace, name)
+}
+
+// toUnstructuredPod converts the pod for the owner lookups, which work on unstructured objects
+func toUnstructuredPod(pod *corev1.Pod) (*unstructured.Unstructured, error) {
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		return nil, err
+	}
+	unstructuredPod := &unstructured.Unstructured{Object: object}
+	unstructuredPod.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Pod"))
+	return unstructuredPod, nil
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	policyconsts "argocd-pod-enrichment/pkg/consts/enrichmentpolicy"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/pkg/enrichmentpolicy"
+)
+
+// policyKinds are the policy kinds evaluated for every pod
+var policyKinds = []string{policyconsts.PodEnrichmentPolicyGVK.Kind, policyconsts.ClusterPodEnrichmentPolicyGVK.Kind}
+
+// policies returns the valid policies that may apply to the pods of the namespace, none if policies are disabled
+func (r *PodReconciler) policies(ctx context.Context, namespace string) ([]*enrichmentpolicy.Policy, error) {
+	if r.PolicyCache == nil {
+		return nil, nil
+	}
+	objs := []unstructured.Unstructured{}
+	for _, kind := range policyKinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(policyconsts.PodEnrichmentPolicyGVK.GroupVersion().WithKind(kind + "List"))
+		opts := []client.ListOption{}
+		if kind == policyconsts.PodEnrichmentPolicyGVK.Kind {
+			opts = append(opts, client.InNamespace(namespace))
+		}
+		if err := r.PolicyCache.List(ctx, list, opts...); err != nil {
+			return nil, err
+		}
+		objs = append(objs, list.Items...)
+	}
+	return r.policyParser.ParseAll(objs), nil
+}
+
+// evaluatePolicies returns the policies matching the pod of the Application
+func (r *PodReconciler) evaluatePolicies(ctx context.Context, pod *corev1.Pod, appNamespace, appName string) (*enrichmentpolicy.Evaluation, error) {
+	policies, err := r.policies(ctx, pod.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return enrichmentpolicy.Evaluate(policies, enrichmentpolicy.Target{
+		Namespace:            pod.Namespace,
+		Labels:               pod.Labels,
+		ApplicationNamespace: appNamespace,
+		ApplicationName:      appName,
+	}), nil
+}
+
+// policySources return the sources of policy events that re-enqueue the pods in the scope of the policy.
+// Status updates do not change the generation and are ignored.
+func (r *PodReconciler) policySources() []source.Source {
+	sources := []source.Source{}
+	for _, kind := range policyKinds {
+		policy := &unstructured.Unstructured{}
+		policy.SetGroupVersionKind(policyconsts.PodEnrichmentPolicyGVK.GroupVersion().WithKind(kind))
+		sources = append(sources, source.Kind(r.PolicyCache, client.Object(policy), handler.EnqueueRequestsFromMapFunc(r.podsForPolicy), predicate.GenerationChangedPredicate{}))
+	}
+	return sources
+}
+
+// podsForPolicy maps a policy to the pods this controller handles in its scope, except the pods the controller
+// gave up on, whose Application lookup a policy cannot fix
+func (r *PodReconciler) podsForPolicy(ctx context.Context, policy client.Object) []reconcile.Request {
+	var pods corev1.PodList
+	opts := []client.ListOption{}
+	if policy.GetNamespace() != "" {
+		opts = append(opts, client.InNamespace(policy.GetNamespace()))
+	}
+	if err := r.List(ctx, &pods, opts...); err != nil {
+		logf.FromContext(ctx).Error(err, "unable to list pods for policy", "policy", policy.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, pod := range pods.Items {
+		if r.handles(pod.Namespace) && !lookupFailed(&pod) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pod)})
+		}
+	}
+	return requests
+}
+
+// optOut removes the keys the controller manages from a pod opted out by a policy
+func (r *PodReconciler) optOut(ctx context.Context, pod *corev1.Pod, policy *enrichmentpolicy.Policy) (ctrl.Result, error) {
+	if _, ok := pod.Annotations[webhookconsts.ManagedKeysAnnotationKey]; !ok {
+		logf.FromContext(ctx).V(1).Info("Pod opted out of enrichment, skipping", "name", pod.Name, "namespace", pod.Namespace, "policy", policy.String())
+		return ctrl.Result{}, nil
+	}
+	logf.FromContext(ctx).Info("Pod opted out of enrichment, removing managed keys", "name", pod.Name, "namespace", pod.Namespace, "policy", policy.String())
+	return ctrl.Result{}, r.removeManagedKeys(ctx, pod)
+}
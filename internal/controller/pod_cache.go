@@ -0,0 +1,30 @@
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// PodCacheTransform strips pods down to the metadata the controllers use before they are stored in the cache.
+// Spec, status and managed fields make up most of a pod, and the controllers only read names, labels,
+// annotations and owner references. Pods read from the cache must therefore never be written back whole.
+func PodCacheTransform() toolscache.TransformFunc {
+	stripManagedFields := cache.TransformStripManagedFields()
+	return func(in any) (any, error) {
+		out, err := stripManagedFields(in)
+		if err != nil {
+			return out, err
+		}
+		if pod, ok := out.(*corev1.Pod); ok {
+			pod.Spec = corev1.PodSpec{}
+			pod.Status = corev1.PodStatus{}
+		}
+		return out, nil
+	}
+}
+
+// PodCacheByObject is the cache configuration of pods for managers running a PodReconciler
+func PodCacheByObject() cache.ByObject {
+	return cache.ByObject{Transform: PodCacheTransform()}
+}
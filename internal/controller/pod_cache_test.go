@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+func TestPodCacheTransform(t *testing.T) {
+	transform := PodCacheTransform()
+	tests := []struct {
+		name string
+		in   any
+		want any
+	}{
+		{
+			name: "pod keeps its metadata only",
+			in:   syntheticPod(1),
+			want: func() *corev1.Pod {
+				pod := syntheticPod(1)
+				pod.ManagedFields = nil
+				pod.Spec = corev1.PodSpec{}
+				pod.Status = corev1.PodStatus{}
+				return pod
+			}(),
+		},
+		{
+			name: "other objects only lose their managed fields",
+			in: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Labels: map[string]string{"app": "web"}, ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}},
+				Data:       map[string]string{"key": "value"},
+			},
+			want: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Labels: map[string]string{"app": "web"}},
+				Data:       map[string]string{"key": "value"},
+			},
+		},
+		{
+			name: "tombstones are left as is",
+			in:   toolscache.DeletedFinalStateUnknown{Key: "team-1/web", Obj: syntheticPod(1)},
+			want: toolscache.DeletedFinalStateUnknown{Key: "team-1/web", Obj: syntheticPod(1)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := transform(test.in)
+			if err != nil {
+				t.Fatalf("PodCacheTransform() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("PodCacheTransform() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	pod, err := transform(syntheticPod(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := syntheticPod(2)
+	if got := pod.(*corev1.Pod); !reflect.DeepEqual(got.Labels, want.Labels) || !reflect.DeepEqual(got.Annotations, want.Annotations) || !reflect.DeepEqual(got.OwnerReferences, want.OwnerReferences) {
+		t.Errorf("PodCacheTransform() changed the labels, annotations or owner references of the pod")
+	}
+}
+
+// BenchmarkPodCache measures the heap retained by a cache of b.N synthetic pods, with and without the pod
+// cache transform, and reports it per pod:
+//
+//	go test ./internal/controller -run '^$' -bench PodCache -benchtime 20000x
+func BenchmarkPodCache(b *testing.B) {
+	for _, benchmark := range []struct {
+		name      string
+		transform toolscache.TransformFunc
+	}{
+		{name: "full"},
+		{name: "transformed", transform: PodCacheTransform()},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			store := toolscache.NewStore(toolscache.MetaNamespaceKeyFunc)
+			before := heapInUse()
+			pods := make([]any, b.N)
+			for i := range pods {
+				pods[i] = syntheticPod(i)
+			}
+
+			b.ResetTimer()
+			// The pods are stored the way an informer stores them, and released once stored like the
+			// decoded objects of an informer
+			for i := range pods {
+				obj := pods[i]
+				if benchmark.transform != nil {
+					var err error
+					if obj, err = benchmark.transform(obj); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := store.Add(obj); err != nil {
+					b.Fatal(err)
+				}
+				pods[i] = nil
+			}
+			b.StopTimer()
+
+			pods = nil
+			after := heapInUse()
+			runtime.KeepAlive(store)
+			if after < before {
+				after = before
+			}
+			b.ReportMetric(float64(after-before)/float64(b.N), "heap-bytes/pod")
+		})
+	}
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// syntheticPod builds a pod shaped like a typical Deployment pod managed by ArgoCD.
+// Strings are built per pod so that they are not shared between pods, as they are not when decoded from the API.
+func syntheticPod(i int) *corev1.Pod {
+	name := fmt.Sprintf("web-%d-7d9f8b6c5d-%05d", i%500, i)
+	namespace := fmt.Sprintf("team-%d", i%50)
+	app := fmt.Sprintf("web-%d", i%500)
+
+	env := make([]corev1.EnvVar, 0, 15)
+	for e := 0; e < 15; e++ {
+		env = append(env, corev1.EnvVar{Name: fmt.Sprintf("SETTING_%d", e), Value: fmt.Sprintf("value-%d-%d", i, e)})
+	}
+	container := func(name string) corev1.Container {
+		return corev1.Container{
+			Name:    name,
+			Image:   fmt.Sprintf("registry.example.com/%s/%s:1.%d.0", namespace, name, i%20),
+			Command: []string{"/bin/" + name, "--config", "/etc/" + name + "/config.yaml"},
+			Env:     env,
+			Ports:   []corev1.ContainerPort{{Name: "http", ContainerPort: 8080, Protocol: corev1.ProtocolTCP}},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: "config", MountPath: "/etc/" + name},
+				{Name: "kube-api-access", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount", ReadOnly: true},
+			},
+			ReadinessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/healthz"}}},
+			LivenessProbe:  &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/healthz"}}},
+		}
+	}
+	containerStatus := func(name string) corev1.ContainerStatus {
+		return corev1.ContainerStatus{
+			Name:        name,
+			Ready:       true,
+			Image:       fmt.Sprintf("registry.example.com/%s/%s:1.%d.0", namespace, name, i%20),
+			ImageID:     fmt.Sprintf("registry.example.com/%s/%s@sha256:%064d", namespace, name, i),
+			ContainerID: fmt.Sprintf("containerd://%064d", i),
+			State:       corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		}
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(fmt.Sprintf("%08d-0000-4000-8000-000000000000", i)),
+			Labels: map[string]string{
+				"app.kubernetes.io/name":             app,
+				"app.kubernetes.io/instance":         app,
+				"pod-template-hash":                  "7d9f8b6c5d",
+				"codefresh.io/application-name":      app,
+				"codefresh.io/application-namespace": "argocd",
+			},
+			Annotations: map[string]string{
+				"codefresh.io/argocd-sync-revision": fmt.Sprintf("%040d", i),
+				"codefresh.io/managed-keys":         `{"labels":["codefresh.io/product"]}`,
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "ReplicaSet",
+				Name:       fmt.Sprintf("web-%d-7d9f8b6c5d", i%500),
+				UID:        types.UID(fmt.Sprintf("%08d-0000-4000-8000-000000000001", i%500)),
+				Controller: ptr.To(true),
+			}},
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate, FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{}},"f:spec":{"f:containers":{` + strings.Repeat(`"k:{\"name\":\"app\"}":{".":{},"f:env":{}},`, 20) + `}}}`)}},
+				{Manager: "kubelet", Operation: metav1.ManagedFieldsOperationUpdate, Subresource: "status", FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:status":{"f:conditions":{` + strings.Repeat(`"k:{\"type\":\"Ready\"}":{".":{},"f:status":{}},`, 10) + `}}}`)}},
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers:         []corev1.Container{container("app"), container("sidecar")},
+			ServiceAccountName: app,
+			NodeName:           fmt.Sprintf("node-%d", i%100),
+			Volumes: []corev1.Volume{
+				{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: app + "-config"}}}},
+				{Name: "kube-api-access", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{}}},
+			},
+			Tolerations: []corev1.Toleration{
+				{Key: "node.kubernetes.io/not-ready", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+				{Key: "node.kubernetes.io/unreachable", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase:  corev1.PodRunning,
+			HostIP: fmt.Sprintf("10.0.%d.%d", i%250, i%200),
+			PodIP:  fmt.Sprintf("10.1.%d.%d", i%250, i%200),
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodInitialized, Status: corev1.ConditionTrue},
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+				{Type: corev1.ContainersReady, Status: corev1.ConditionTrue},
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{containerStatus("app"), containerStatus("sidecar")},
+		},
+	}
+}
@@ -17,19 +17,35 @@ limitations under the License.
 package controller
 
 import (
-		"context"
-		"os"
-		metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-		"k8s.io/apimachinery/pkg/runtime/schema"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	argocdconsts "argocd-pod-enrichment/pkg/consts/argocd"
+	controllerconsts "argocd-pod-enrichment/pkg/consts/controller"
 	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/internal/argocd"
+	"argocd-pod-enrichment/pkg/argocdclusters"
+	"argocd-pod-enrichment/pkg/enrichment"
+	"argocd-pod-enrichment/pkg/enrichmentpolicy"
 	"argocd-pod-enrichment/pkg/kubernetesclient"
+	"argocd-pod-enrichment/pkg/namespacescope"
+	"argocd-pod-enrichment/pkg/runtimeconfig"
 )
 
 // PodReconciler reconciles a Pod object
@@ -37,24 +53,62 @@ type PodReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	KubernetesClient *kubernetesclient.KubernetesClient
+	// OwnerClient resolves the owners of unlabelled pods in the cluster the pods run in, defaults to KubernetesClient
+	OwnerClient *kubernetesclient.KubernetesClient
+	// ArgoCDClient, if set, is used to read Applications from the ArgoCD API server instead of the local cluster
+	ArgoCDClient *argocd.Client
+	// ArgoCDNamespace holds the Applications of pods whose tracking does not name a namespace, and the AppProjects
+	ArgoCDNamespace string
+	// TrackingLabel is the label ArgoCD tracks resources with, the default tracking label if empty
+	TrackingLabel string
+	// ClusterName identifies the managed cluster the pods belong to, empty for the local cluster
+	ClusterName string
+	// ClusterIdentity is stamped on every pod if set, otherwise the identity is resolved with ClusterResolver
+	ClusterIdentity *argocdclusters.Identity
+	// ClusterResolver resolves the cluster identity from the destination of the Application
+	ClusterResolver *argocdclusters.Resolver
+	// EnrichmentConfig selects the Application metadata copied to pods
+	EnrichmentConfig *enrichment.Config
+	// ApplicationCache serves the Application watch and Application lookups, defaults to the cache of the
+	// manager for the watch. Pod controllers of managed clusters use the cache of the control plane.
+	ApplicationCache cache.Cache
+	// Shard, if set, restricts the controller to the namespaces of its shard
+	Shard *Sharding
+	// RuntimeConfig, if set, holds the enrichment config and namespace filter reloaded from the runtime config
+	// ConfigMap. It takes precedence over EnrichmentConfig.
+	RuntimeConfig *runtimeconfig.Store
+	// PolicyCache, if set, serves the PodEnrichmentPolicies and ClusterPodEnrichmentPolicies evaluated for every
+	// pod. Pod controllers of managed clusters use the cache of the control plane.
+	PolicyCache cache.Cache
+	// MaxLookupRetries limits the retries of a failed Application lookup before the pod is marked as failed
+	MaxLookupRetries int
+	// OwnerKinds are the owner resources the controller reads, defaults to namespacescope.OwnerPermissions.
+	// Unlabelled pods are only resolved when their controller owner is one of them or bridged by a rule.
+	OwnerKinds []namespacescope.Permission
+	// WatchRollouts recomputes the rollout role and step index of Rollout pods whenever their Rollout changes
+	WatchRollouts bool
+
+	lookupBackoff workqueue.TypedRateLimiter[types.NamespacedName]
+	policyParser  *enrichmentpolicy.Parser
+	// untrackedOwners caches the UIDs of owners whose pods are not managed by ArgoCD
+	untrackedOwners *utilcache.LRUExpireCache
+	// rolloutCache reads the Rollouts of the Rollout watch, nil if Rollouts are not watched
+	rolloutCache client.Reader
 }
 
-// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups=core,resources=pods/finalizers,verbs=update
-
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Pod object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.22.1/pkg/reconcile
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;patch
+
+// Reconcile enriches a pod with the metadata of its ArgoCD Application: propagated keys, Application fields,
+// status, expressions, enrichment policies and the cluster identity. Pods without the Application label are
+// resolved through their tracking information first. Keys whose source no longer exists are removed, and
+// pods whose Application cannot be found after the retries are marked as failed.
 func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := logf.FromContext(ctx)
 
+	if !r.handles(req.Namespace) {
+		return ctrl.Result{}, nil
+	}
+
 	var pod corev1.Pod
 	if err := r.Get(ctx, req.NamespacedName, &pod); err != nil {
 		log.Error(err, "unable to fetch Pod")
@@ -68,73 +122,340 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		return ctrl.Result{}, nil
 	}
 
-	// Add or update the annotation
-	if pod.Annotations == nil {
-		pod.Annotations = map[string]string{}
+	if _, ok := pod.Annotations[controllerconsts.LegacyControllerAnnotationKey]; ok {
+		if err := r.removeLegacyAnnotation(ctx, &pod); err != nil {
+			log.Error(err, "unable to remove legacy controller annotation")
+			return ctrl.Result{}, err
+		}
 	}
 
-	pod.Annotations["test.codefresh.io/controller"] = "argocd-enrichment"
-
 	argocdApplicationName := pod.Labels[webhookconsts.ApplicationLabelKey]
 
 	if argocdApplicationName == "" {
-		log.Info("Pod does not have ArgoCD application label, skipping", "name", pod.Name, "namespace", pod.Namespace)
-		return ctrl.Result{}, nil
+		if _, ok := pod.Annotations[webhookconsts.ManagedKeysAnnotationKey]; ok {
+			// The pod no longer belongs to an Application, nothing the controller set before is true anymore
+			log.Info("Pod lost its ArgoCD application label, removing managed keys", "name", pod.Name, "namespace", pod.Namespace)
+			return ctrl.Result{}, r.removeManagedKeys(ctx, &pod)
+		}
+		return r.resolveTracking(ctx, &pod)
 	}
 
-	argocdApplicationNamespace := pod.Labels[webhookconsts.ApplicationNamespaceLabelKey]
+	argocdApplicationNamespace, _ := applicationRef(&pod, r.ArgoCDNamespace)
 
 	if argocdApplicationNamespace == "" {
-		// Try to get from env
-		argocdApplicationNamespace = os.Getenv("ARGOCD_NAMESPACE")
-		if argocdApplicationNamespace == "" {
-			log.Info("Unable to find ArgoCD app namespace in label or ARGOCD_NAMESPACE env, skipping", "name", pod.Name, "namespace", pod.Namespace)
-			return ctrl.Result{}, nil
-		} else {
-			log.Info("Using ArgoCD app namespace from ARGOCD_NAMESPACE env", "argoNamespace", argocdApplicationNamespace, "name", pod.Name, "namespace", pod.Namespace)
-		}
+		log.Info("Unable to find ArgoCD app namespace in label or the ArgoCD namespace setting, skipping", "name", pod.Name, "namespace", pod.Namespace)
+		return ctrl.Result{}, nil
 	}
 
-	// Fetch the ArgoCD Application using the dynamic client
-	// GVR for ArgoCD Application
-	gvr := schema.GroupVersionResource{
-		Group:    "argoproj.io",
-		Version:  "v1alpha1",
-		Resource: "applications",
+	policies, err := r.evaluatePolicies(ctx, &pod, argocdApplicationNamespace, argocdApplicationName)
+	if err != nil {
+		log.Error(err, "unable to list enrichment policies")
+		return ctrl.Result{}, err
+	}
+	if policies.OptOut != nil {
+		return r.optOut(ctx, &pod, policies.OptOut)
 	}
 
-	appObj, err := r.KubernetesClient.DynamicClient.
-		Resource(gvr).
-		Namespace(argocdApplicationNamespace).
-		Get(ctx, argocdApplicationName, metav1.GetOptions{})
+	appObj, err := r.getApplication(ctx, argocdApplicationNamespace, argocdApplicationName)
 	if err != nil {
-		log.Error(err, "unable to get ArgoCD Application", "appName", argocdApplicationName, "appNamespace", argocdApplicationNamespace)
-		return ctrl.Result{}, nil
+		return r.handleLookupFailure(logf.IntoContext(ctx, log.WithValues("appName", argocdApplicationName, "appNamespace", argocdApplicationNamespace)), &pod, err)
 	}
+	r.lookupBackoff.Forget(req.NamespacedName)
 
 	log.Info("Fetched ArgoCD Application", "app", appObj.GetName())
 
-	if appObj.GetAnnotations()["codefresh.io/product"] != "" {
-		pod.Labels["codefresh.io/product"] = appObj.GetAnnotations()["codefresh.io/product"]
+	enrichmentConfig := r.enrichmentConfig()
+
+	// Keys written on a previous reconcile do not count as set by someone else
+	managedKeys := enrichment.ParseManagedKeys(pod.Annotations[webhookconsts.ManagedKeysAnnotationKey])
+	desired := enrichment.NewMetadata()
+
+	propagated, skipped := enrichmentConfig.PropagationMetadata(appObj, managedKeys.Unmanaged(pod.Labels, pod.Annotations))
+	if len(skipped) > 0 {
+		log.Info("Skipping propagated keys that are not valid for their target", "keys", skipped, "app", appObj.GetName())
+	}
+	desired.Merge(propagated)
+
+	metadata, skipped := enrichmentConfig.ApplicationMetadata(appObj)
+	if len(skipped) > 0 {
+		log.Info("Skipping Application fields that are not valid label values", "keys", skipped, "app", appObj.GetName())
+	}
+	desired.Merge(metadata)
+
+	if enrichmentConfig.MirrorApplicationStatus {
+		health, sync := enrichment.ApplicationStatus(appObj)
+		if health != "" {
+			desired.Set(enrichment.TargetLabel, webhookconsts.HealthStatusLabelKey, health)
+		}
+		if sync != "" {
+			desired.Set(enrichment.TargetLabel, webhookconsts.SyncStatusLabelKey, sync)
+		}
 	}
 
-	if err := r.Update(ctx, &pod); err != nil {
-		log.Error(err, "unable to update Pod with annotation")
+	input, err := r.expressionInput(ctx, &pod, appObj, func(variable string) bool {
+		return enrichmentConfig.References(variable) || policies.References(variable)
+	})
+	if err != nil {
+		log.Error(err, "unable to resolve the inputs of enrichment expressions")
+		return ctrl.Result{}, err
+	}
+	computed, skipped, err := enrichmentConfig.ExpressionMetadata(input)
+	if err != nil {
+		log.Info("Skipping enrichment expressions that failed", "error", err.Error(), "app", appObj.GetName())
+	}
+	if len(skipped) > 0 {
+		log.Info("Skipping computed values that are not valid label values", "keys", skipped, "app", appObj.GetName())
+	}
+	desired.Merge(computed)
+
+	// Policies come after the global config, their conflict policy decides which value wins
+	skipped, err = policies.Apply(desired, input, managedKeys.Unmanaged(pod.Labels, pod.Annotations))
+	if err != nil {
+		log.Info("Skipping policy expressions that failed", "error", err.Error(), "app", appObj.GetName())
+	}
+	if len(skipped) > 0 {
+		log.Info("Skipping policy keys that are not valid for their target", "keys", skipped, "app", appObj.GetName())
+	}
+
+	if clusterIdentity := r.clusterIdentity(ctx, appObj); clusterIdentity != nil {
+		if !desired.Set(enrichment.TargetLabel, webhookconsts.ClusterNameLabelKey, clusterIdentity.Name) {
+			log.Info("Cluster name is not a valid label value, skipping cluster name label", "cluster", clusterIdentity.Name)
+		}
+		desired.Set(enrichment.TargetAnnotation, webhookconsts.ClusterServerAnnotationKey, clusterIdentity.Server)
+	}
+
+	stale := managedKeys.Stale(desired)
+	if len(stale.Labels) > 0 || len(stale.Annotations) > 0 {
+		// The keys the webhook set at admission are owned by the manager that created the pod,
+		// leaving them out of the apply would not remove them
+		log.Info("Removing keys whose source no longer exists", "labels", stale.Labels, "annotations", stale.Annotations)
+		if err := r.removeKeys(ctx, &pod, stale); err != nil {
+			log.Error(err, "unable to remove stale keys from Pod")
+			return ctrl.Result{}, err
+		}
+	}
+
+	applied := enrichment.NewMetadata()
+	applied.Merge(desired)
+	applied.Annotations[webhookconsts.ManagedKeysAnnotationKey] = desired.Keys().Encode()
+	// Sync details describe the deployment the pod came from, so they are recorded once, never updated and never removed
+	applied.Merge(r.syncMetadata(&pod, appObj))
+	// The rollout role and step index follow the Rollout, they are part of every apply like the sync details
+	applied.Merge(r.rolloutMetadata(ctx, &pod))
+
+	_, failed := pod.Annotations[webhookconsts.EnrichmentStatusAnnotationKey]
+	if len(stale.Labels) == 0 && len(stale.Annotations) == 0 && !failed && hasMetadata(&pod, applied) {
+		log.V(1).Info("Pod is up to date, skipping", "name", pod.Name, "namespace", pod.Namespace)
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.applyMetadata(ctx, &pod, applied); err != nil {
+		log.Error(err, "unable to apply enrichment metadata to Pod")
 		return ctrl.Result{}, err
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// syncMetadata returns the sync annotations already recorded on the pod, or the ones of the Application
+// if there are none. They are part of every apply so that the field manager keeps owning them.
+func (r *PodReconciler) syncMetadata(pod *corev1.Pod, app *unstructured.Unstructured) *enrichment.Metadata {
+	if !enrichment.HasSyncMetadata(pod.Annotations) {
+		return enrichment.SyncMetadata(app)
+	}
+	return existingSyncMetadata(pod)
+}
+
+// existingSyncMetadata returns the sync annotations recorded on the pod
+func existingSyncMetadata(pod *corev1.Pod) *enrichment.Metadata {
+	metadata := enrichment.NewMetadata()
+	for _, key := range enrichment.SyncAnnotationKeys {
+		if value, ok := pod.Annotations[key]; ok {
+			metadata.Set(enrichment.TargetAnnotation, key, value)
+		}
+	}
+	return metadata
+}
+
+// removeManagedKeys removes every label and annotation the controller manages from the pod.
+// The managed keys are deleted explicitly, then only the sync annotations are applied, so the field manager
+// releases everything else.
+func (r *PodReconciler) removeManagedKeys(ctx context.Context, pod *corev1.Pod) error {
+	keys := enrichment.ParseManagedKeys(pod.Annotations[webhookconsts.ManagedKeysAnnotationKey])
+	keys.Annotations = append(keys.Annotations, webhookconsts.ManagedKeysAnnotationKey)
+	if err := r.removeKeys(ctx, pod, keys); err != nil {
+		return err
+	}
+	return r.applyMetadata(ctx, pod, existingSyncMetadata(pod))
+}
+
+// removeKeys deletes the given labels and annotations from the pod with a merge patch.
+// Unlike an apply, it removes the keys whatever field manager owns them, such as the keys the webhook set at admission.
+func (r *PodReconciler) removeKeys(ctx context.Context, pod *corev1.Pod, keys enrichment.ManagedKeys) error {
+	labels := map[string]interface{}{}
+	for _, key := range keys.Labels {
+		if _, ok := pod.Labels[key]; ok {
+			labels[key] = nil
+		}
+	}
+	annotations := map[string]interface{}{}
+	for _, key := range keys.Annotations {
+		if _, ok := pod.Annotations[key]; ok {
+			annotations[key] = nil
+		}
+	}
+	if len(labels) == 0 && len(annotations) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels, "annotations": annotations},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode the patch removing keys: %w", err)
+	}
+	return r.Patch(ctx, pod, client.RawPatch(types.MergePatchType, patch))
+}
+
+// removeLegacyAnnotation removes the annotation earlier versions of the controller wrote with full updates,
+// which the field manager does not own and therefore cannot remove with an apply
+func (r *PodReconciler) removeLegacyAnnotation(ctx context.Context, pod *corev1.Pod) error {
+	patch := []byte(`{"metadata":{"annotations":{"` + controllerconsts.LegacyControllerAnnotationKey + `":null}}}`)
+	return r.Patch(ctx, pod, client.RawPatch(types.MergePatchType, patch))
+}
+
+// applyMetadata server-side applies the labels and annotations owned by the controller.
+// Keys the field manager applied before and that are missing from metadata are removed from the pod.
+func (r *PodReconciler) applyMetadata(ctx context.Context, pod *corev1.Pod, metadata *enrichment.Metadata) error {
+	configuration := corev1ac.Pod(pod.Name, pod.Namespace).
+		WithLabels(metadata.Labels).
+		WithAnnotations(metadata.Annotations)
+	return r.Apply(ctx, configuration, client.FieldOwner(controllerconsts.FieldManager), client.ForceOwnership)
+}
+
+// hasMetadata reports whether the pod already carries every label and annotation of metadata
+func hasMetadata(pod *corev1.Pod, metadata *enrichment.Metadata) bool {
+	for key, value := range metadata.Labels {
+		if current, ok := pod.Labels[key]; !ok || current != value {
+			return false
+		}
+	}
+	for key, value := range metadata.Annotations {
+		if current, ok := pod.Annotations[key]; !ok || current != value {
+			return false
+		}
+	}
+	return true
+}
+
+// enrichmentConfig returns the active runtime enrichment config, the configured one, or the default one
+func (r *PodReconciler) enrichmentConfig() *enrichment.Config {
+	if r.RuntimeConfig != nil {
+		if config := r.RuntimeConfig.Current().Enrichment; config != nil {
+			return config
+		}
+	}
+	if r.EnrichmentConfig == nil {
+		return enrichment.DefaultConfig()
+	}
+	return r.EnrichmentConfig
+}
+
+// getApplication fetches the ArgoCD Application from the ArgoCD API server if configured, otherwise from
+// the Application informer of ApplicationCache. Applications missing from the cache, because they were just
+// created or live outside the cached namespaces, are read from the cluster using the dynamic client.
+func (r *PodReconciler) getApplication(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	if r.ArgoCDClient != nil {
+		return r.ArgoCDClient.GetApplication(ctx, namespace, name)
+	}
+	if r.ApplicationCache != nil {
+		app := &unstructured.Unstructured{}
+		app.SetGroupVersionKind(argocdconsts.ApplicationGVK)
+		err := r.ApplicationCache.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, app)
+		if err == nil {
+			return app, nil
+		}
+		logf.FromContext(ctx).V(1).Info("ArgoCD Application not in cache, reading it from the API server", "reason", err.Error())
+	}
+	return r.KubernetesClient.GetArgoCDApplication(ctx, namespace, name)
+}
+
+// clusterIdentity returns the identity of the cluster the pod runs in, or nil if it is unknown
+func (r *PodReconciler) clusterIdentity(ctx context.Context, app *unstructured.Unstructured) *argocdclusters.Identity {
+	if r.ClusterIdentity != nil {
+		return r.ClusterIdentity
+	}
+	if r.ClusterResolver == nil {
+		return nil
+	}
+	identity, err := r.ClusterResolver.Resolve(ctx, app)
+	if errors.Is(err, argocdclusters.ErrListBackoff) {
+		// The failure was logged when it happened
+		logf.FromContext(ctx).V(1).Info("Skipping cluster identity", "reason", err.Error(), "app", app.GetName())
+		return nil
+	}
+	if err != nil {
+		logf.FromContext(ctx).Error(err, "unable to resolve cluster identity", "app", app.GetName())
+		return nil
+	}
+	return identity
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Pod{}).
-		WithEventFilter(predicate.NewPredicateFuncs(func(obj client.Object) bool {
-			labels := obj.GetLabels()
-			_, ok := labels[webhookconsts.ApplicationLabelKey]
-			return ok
-		})).
-		Named("pod").
-		Complete(r)
+	name := "pod"
+	if r.ClusterName != "" {
+		name = "pod-" + r.ClusterName
+	}
+	r.lookupBackoff = newLookupBackoff()
+	r.policyParser = &enrichmentpolicy.Parser{}
+	r.untrackedOwners = utilcache.NewLRUExpireCache(untrackedOwnersCacheSize)
+	// Set by rolloutSource when the cluster of the manager serves Rollouts
+	r.rolloutCache = nil
+
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Pod{}, builder.WithPredicates(podPredicate(r.resolvable(mgr.GetRESTMapper())))).
+		Named(name).
+		WithOptions(controller.Options{NeedLeaderElection: ptr.To(r.needLeaderElection())})
+
+	// Re-enrich the pods of an Application whenever its metadata changes
+	if err := indexPodsByApplication(context.Background(), mgr, r.ArgoCDNamespace); err != nil {
+		return err
+	}
+	applicationSource, err := r.applicationSource(mgr)
+	if err != nil {
+		return err
+	}
+
+	controllerBuilder = controllerBuilder.WatchesRawSource(applicationSource)
+
+	// Follow the promotions and aborts of Rollouts in the role labels of their pods
+	if r.WatchRollouts {
+		if rolloutSource := r.rolloutSource(mgr); rolloutSource != nil {
+			controllerBuilder = controllerBuilder.WatchesRawSource(rolloutSource)
+		}
+	}
+
+	// Re-enqueue the pods taken over from another replica, or enriched differently by a new runtime config
+	if r.Shard != nil {
+		shardSource, err := r.resyncSource(mgr, r.Shard.Subscribe())
+		if err != nil {
+			return err
+		}
+		controllerBuilder = controllerBuilder.WatchesRawSource(shardSource)
+	}
+	if r.PolicyCache != nil {
+		for _, policySource := range r.policySources() {
+			controllerBuilder = controllerBuilder.WatchesRawSource(policySource)
+		}
+	}
+	if r.RuntimeConfig != nil {
+		runtimeConfigSource, err := r.resyncSource(mgr, r.RuntimeConfig.Subscribe())
+		if err != nil {
+			return err
+		}
+		controllerBuilder = controllerBuilder.WatchesRawSource(runtimeConfigSource)
+	}
+
+	return controllerBuilder.Complete(r)
 }
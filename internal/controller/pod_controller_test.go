@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/pkg/enrichment"
+)
+
+// admittedPod creates a pod carrying the keys the webhook set at admission, owned by the manager creating the pod,
+// then applies the same keys with the controller's field manager
+func admittedPod(t *testing.T, r *PodReconciler) *corev1.Pod {
+	t.Helper()
+	ctx := context.Background()
+	managed := enrichment.ManagedKeys{Labels: []string{"example.com/team", "example.com/tier"}, Annotations: []string{"example.com/runbook"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "checkout-abcde",
+		Namespace: "shop",
+		Labels:    map[string]string{"app": "checkout", "example.com/team": "payments", "example.com/tier": "frontend"},
+		Annotations: map[string]string{
+			"example.com/runbook":                  "https://runbooks.example.com/checkout",
+			webhookconsts.ManagedKeysAnnotationKey: managed.Encode(),
+		},
+	}}
+	if err := r.Create(ctx, pod, client.FieldOwner("kube-controller-manager")); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	metadata := &enrichment.Metadata{
+		Labels:      map[string]string{"example.com/team": "payments", "example.com/tier": "frontend"},
+		Annotations: map[string]string{"example.com/runbook": "https://runbooks.example.com/checkout", webhookconsts.ManagedKeysAnnotationKey: managed.Encode()},
+	}
+	if err := r.applyMetadata(ctx, pod, metadata); err != nil {
+		t.Fatalf("applyMetadata() error = %v", err)
+	}
+	return pod
+}
+
+func TestRemoveStaleKeysOwnedByAnotherManager(t *testing.T) {
+	ctx := context.Background()
+	r := &PodReconciler{Client: fake.NewClientBuilder().Build()}
+	pod := admittedPod(t, r)
+
+	// The same steps as Reconcile when the tier and the runbook no longer have a source
+	desired := &enrichment.Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{}}
+	stale := enrichment.ParseManagedKeys(pod.Annotations[webhookconsts.ManagedKeysAnnotationKey]).Stale(desired)
+	if err := r.removeKeys(ctx, pod, stale); err != nil {
+		t.Fatalf("removeKeys() error = %v", err)
+	}
+	applied := enrichment.NewMetadata()
+	applied.Merge(desired)
+	applied.Annotations[webhookconsts.ManagedKeysAnnotationKey] = desired.Keys().Encode()
+	if err := r.applyMetadata(ctx, pod, applied); err != nil {
+		t.Fatalf("applyMetadata() error = %v", err)
+	}
+
+	var got corev1.Pod
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pod), &got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if _, ok := got.Labels["example.com/tier"]; ok {
+		t.Errorf("stale label example.com/tier was kept: %v", got.Labels)
+	}
+	if _, ok := got.Annotations["example.com/runbook"]; ok {
+		t.Errorf("stale annotation example.com/runbook was kept: %v", got.Annotations)
+	}
+	if got.Labels["example.com/team"] != "payments" || got.Labels["app"] != "checkout" {
+		t.Errorf("labels = %v, want the desired and unmanaged labels kept", got.Labels)
+	}
+}
+
+func TestRemoveManagedKeysOwnedByAnotherManager(t *testing.T) {
+	ctx := context.Background()
+	r := &PodReconciler{Client: fake.NewClientBuilder().Build()}
+	pod := admittedPod(t, r)
+
+	if err := r.removeManagedKeys(ctx, pod); err != nil {
+		t.Fatalf("removeManagedKeys() error = %v", err)
+	}
+
+	var got corev1.Pod
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pod), &got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if want := map[string]string{"app": "checkout"}; len(got.Labels) != len(want) || got.Labels["app"] != "checkout" {
+		t.Errorf("labels = %v, want %v", got.Labels, want)
+	}
+	if len(got.Annotations) != 0 {
+		t.Errorf("annotations = %v, want none", got.Annotations)
+	}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// handles reports whether the pods of the namespace are enriched by this controller: the namespace belongs
+// to its shard and passes the namespace filter of the runtime config
+func (r *PodReconciler) handles(namespace string) bool {
+	if r.Shard != nil && !r.Shard.Owns(namespace) {
+		return false
+	}
+	if r.RuntimeConfig != nil && !r.RuntimeConfig.Current().Watches(namespace) {
+		return false
+	}
+	return true
+}
+
+// resyncSource returns a source re-enqueueing the pods this controller handles whenever changes is notified,
+// so that pods are enriched again without waiting for an event. Pods the controller gave up on are left alone.
+func (r *PodReconciler) resyncSource(mgr ctrl.Manager, changes <-chan struct{}) (source.Source, error) {
+	events := make(chan event.GenericEvent)
+	if err := mgr.Add(r.runnable(func(ctx context.Context) error {
+		defer close(events)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-changes:
+			}
+			var pods corev1.PodList
+			if err := mgr.GetCache().List(ctx, &pods); err != nil {
+				logf.FromContext(ctx).Error(err, "unable to list pods to resync")
+				continue
+			}
+			for i := range pods.Items {
+				if !r.handles(pods.Items[i].Namespace) || lookupFailed(&pods.Items[i]) {
+					continue
+				}
+				select {
+				case events <- event.GenericEvent{Object: &pods.Items[i]}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	})); err != nil {
+		return nil, err
+	}
+	return source.Channel(events, &handler.EnqueueRequestForObject{}), nil
+}
+
+// needLeaderElection reports whether the controller only runs on the leader. Sharded controllers run on
+// every replica, the leader only runs the controllers writing shared state, like the policy status.
+func (r *PodReconciler) needLeaderElection() bool {
+	return r.Shard == nil
+}
+
+// runnable wraps a runnable feeding the controller, so that it runs on the same replicas as the controller
+func (r *PodReconciler) runnable(fn manager.RunnableFunc) manager.Runnable {
+	return leaderElectionRunnable{RunnableFunc: fn, needLeaderElection: r.needLeaderElection()}
+}
+
+type leaderElectionRunnable struct {
+	manager.RunnableFunc
+	needLeaderElection bool
+}
+
+func (r leaderElectionRunnable) NeedLeaderElection() bool {
+	return r.needLeaderElection
+}
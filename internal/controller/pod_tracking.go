@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	argocdtracking "argocd-pod-enrichment/pkg/argocdresourcetracking"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/pkg/kubernetesclient"
+	"argocd-pod-enrichment/pkg/namespacescope"
+	"argocd-pod-enrichment/pkg/ownerbridge"
+	"argocd-pod-enrichment/pkg/podtracking"
+)
+
+// resolveTracking labels a pod the webhook missed, because it was down or not installed yet when the pod was
+// created. The owner chain and tracking resolution are the same as the webhook's. Once labelled, the pod is
+// reconciled again and enriched like any other pod.
+func (r *PodReconciler) resolveTracking(ctx context.Context, pod *corev1.Pod) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	owner := metav1.GetControllerOf(pod)
+	if owner != nil && r.untrackedOwner(owner.UID) {
+		log.V(1).Info("Owner of Pod is not managed by ArgoCD, skipping", "name", pod.Name, "namespace", pod.Namespace, "owner", owner.Name)
+		return ctrl.Result{}, nil
+	}
+
+	unstructuredPod, err := toUnstructuredPod(pod)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	resolution, err := podtracking.Resolve(ctx, r.ownerClient(), unstructuredPod, r.getApplication, podtracking.Options{
+		ArgoCDNamespace: r.ArgoCDNamespace,
+		TrackingLabel:   r.TrackingLabel,
+	})
+	if apierrors.IsNotFound(err) || errors.Is(err, kubernetesclient.ErrOwnerOutOfScope) {
+		// Retrying does not help: the owner is being deleted, and the pod with it, or it cannot be read
+		log.V(1).Info("Owner chain of unlabelled Pod cannot be resolved, skipping", "name", pod.Name, "namespace", pod.Namespace, "reason", err.Error())
+		r.addUntrackedOwner(owner)
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		log.Error(err, "unable to resolve ArgoCD tracking of unlabelled Pod")
+		return ctrl.Result{}, err
+	}
+	if resolution == nil {
+		log.V(1).Info("Pod is not managed by ArgoCD, skipping", "name", pod.Name, "namespace", pod.Namespace)
+		r.addUntrackedOwner(owner)
+		return ctrl.Result{}, nil
+	}
+	appNamespace := resolution.Tracking.ApplicationNamespace
+	if appNamespace == "" {
+		appNamespace = r.ArgoCDNamespace
+	}
+	policies, err := r.evaluatePolicies(ctx, pod, appNamespace, resolution.Tracking.ApplicationName)
+	if err != nil {
+		log.Error(err, "unable to list enrichment policies")
+		return ctrl.Result{}, err
+	}
+	if policies.OptOut != nil {
+		log.V(1).Info("Pod opted out of enrichment, not labelling it", "name", pod.Name, "namespace", pod.Namespace, "policy", policies.OptOut.String())
+		return ctrl.Result{}, nil
+	}
+	if resolution.RolloutError != nil {
+		log.Info("Labelling Rollout Pod without its role", "error", resolution.RolloutError.Error())
+	}
+	if resolution.HookApplicationError != nil {
+		log.Info("Labelling hook Pod without its sync operation", "error", resolution.HookApplicationError.Error())
+	}
+
+	// A merge patch, like the admission patch of the webhook, leaves the labels unowned by the field manager
+	original := pod.DeepCopy()
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	for key, value := range resolution.Labels() {
+		pod.Labels[key] = value
+	}
+	if len(resolution.ExtraAnnotations) > 0 && pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	for key, value := range resolution.ExtraAnnotations {
+		pod.Annotations[key] = value
+	}
+
+	log.Info("Labelling Pod missed by the webhook", "name", pod.Name, "namespace", pod.Namespace, "app", resolution.Tracking.ApplicationName)
+	if err := r.Patch(ctx, pod, client.MergeFrom(original)); err != nil {
+		log.Error(err, "unable to label Pod")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// ownerClient returns the client resolving the owners of pods in the cluster they run in
+func (r *PodReconciler) ownerClient() *kubernetesclient.KubernetesClient {
+	if r.OwnerClient != nil {
+		return r.OwnerClient
+	}
+	return r.KubernetesClient
+}
+
+// Owners whose pods are not managed by ArgoCD are remembered for a while, so that the pods of a large
+// ReplicaSet or Job do not walk the same owner chain one after the other. Owners adopted by ArgoCD later
+// are resolved again once the entry expires.
+const (
+	untrackedOwnersCacheSize = 4096
+	untrackedOwnersTTL       = 10 * time.Minute
+)
+
+func (r *PodReconciler) untrackedOwner(uid types.UID) bool {
+	if r.untrackedOwners == nil {
+		return false
+	}
+	_, ok := r.untrackedOwners.Get(uid)
+	return ok
+}
+
+func (r *PodReconciler) addUntrackedOwner(owner *metav1.OwnerReference) {
+	if r.untrackedOwners == nil || owner == nil {
+		return
+	}
+	r.untrackedOwners.Add(owner.UID, struct{}{}, untrackedOwnersTTL)
+}
+
+// resolvable returns whether the owner chain of an unlabelled pod can lead to an ArgoCD Application: the pod
+// carries the ArgoCD tracking itself, an owner bridge rule links it to a parent, or its controller owner is one
+// of the owner kinds or bridged by a rule. Other pods, like the pods of controllers whose owners the controller
+// cannot read, are never resolved.
+func (r *PodReconciler) resolvable(mapper meta.RESTMapper) func(obj client.Object) bool {
+	ownerKinds := r.OwnerKinds
+	if len(ownerKinds) == 0 {
+		ownerKinds = namespacescope.OwnerPermissions
+	}
+	return func(obj client.Object) bool {
+		var rules []ownerbridge.Rule
+		if ownerClient := r.ownerClient(); ownerClient != nil {
+			rules = ownerClient.OwnerBridgeRules
+		}
+
+		pod := &unstructured.Unstructured{}
+		pod.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Pod"))
+		pod.SetLabels(obj.GetLabels())
+		pod.SetAnnotations(obj.GetAnnotations())
+		if argocdtracking.ExtractArgoCDTrackingInfo(*pod, r.TrackingLabel) != nil {
+			return true
+		}
+		if rule, _ := ownerbridge.FindParent(rules, pod); rule != nil {
+			return true
+		}
+
+		owner := metav1.GetControllerOf(obj)
+		if owner == nil || r.untrackedOwner(owner.UID) {
+			return false
+		}
+		for _, rule := range rules {
+			if rule.Kind == owner.Kind && (rule.APIVersion == "" || rule.APIVersion == owner.APIVersion) {
+				return true
+			}
+		}
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil {
+			return false
+		}
+		mapping, err := mapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: owner.Kind}, gv.Version)
+		if err != nil {
+			return false
+		}
+		return namespacescope.IncludesResource(ownerKinds, mapping.Resource.Group, mapping.Resource.Resource)
+	}
+}
+
+// podPredicate reconciles labelled pods and pods with managed keys when their labels, annotations or owners
+// change. Unlabelled pods are only reconciled when they are first seen, on creation and on the initial list, to
+// label the ones the webhook missed, if resolvable reports that their owner chain can lead to an Application.
+// Pods the controller gave up on wait for an event of their Application.
+func podPredicate(resolvable func(obj client.Object) bool) predicate.Predicate {
+	tracked := func(obj client.Object) bool {
+		_, ok := obj.GetLabels()[webhookconsts.ApplicationLabelKey]
+		// Pods with managed keys are reconciled even without the label, to clean up after them
+		_, managed := obj.GetAnnotations()[webhookconsts.ManagedKeysAnnotationKey]
+		return ok || managed
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return (tracked(e.Object) || resolvable(e.Object)) && !lookupFailed(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !tracked(e.ObjectNew) || lookupFailed(e.ObjectNew) {
+				return false
+			}
+			// Status changes are the bulk of pod updates and never change the enrichment
+			return !maps.Equal(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
+				!maps.Equal(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()) ||
+				!equality.Semantic.DeepEqual(e.ObjectOld.GetOwnerReferences(), e.ObjectNew.GetOwnerReferences())
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return tracked(e.Object)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return tracked(e.Object) && !lookupFailed(e.Object)
+		},
+	}
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	argocdconsts "argocd-pod-enrichment/pkg/consts/argocd"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/pkg/kubernetesclient"
+	"argocd-pod-enrichment/pkg/namespacescope"
+	"argocd-pod-enrichment/pkg/ownerbridge"
+)
+
+func TestPodPredicate(t *testing.T) {
+	tracked := func(mutate func(pod *corev1.Pod)) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "shop",
+			Name:        "checkout-abcde",
+			Labels:      map[string]string{webhookconsts.ApplicationLabelKey: "checkout"},
+			Annotations: map[string]string{},
+		}}
+		if mutate != nil {
+			mutate(pod)
+		}
+		return pod
+	}
+	failed := func(pod *corev1.Pod) {
+		pod.Annotations[webhookconsts.EnrichmentStatusAnnotationKey] = webhookconsts.EnrichmentStatusFailed
+	}
+
+	predicate := podPredicate(func(obj client.Object) bool { return obj.GetName() == "resolvable" })
+
+	updates := []struct {
+		name string
+		old  *corev1.Pod
+		new  *corev1.Pod
+		want bool
+	}{
+		{name: "no metadata change", old: tracked(nil), new: tracked(func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodRunning }), want: false},
+		{name: "label change", old: tracked(nil), new: tracked(func(pod *corev1.Pod) { pod.Labels["tier"] = "backend" }), want: true},
+		{name: "annotation change", old: tracked(nil), new: tracked(func(pod *corev1.Pod) { pod.Annotations["note"] = "x" }), want: true},
+		{name: "owner change", old: tracked(nil), new: tracked(func(pod *corev1.Pod) {
+			pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "checkout-7d9f8"}}
+		}), want: true},
+		{name: "untracked pod", old: &corev1.Pod{}, new: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"tier": "backend"}}}, want: false},
+		{name: "pod marked failed", old: tracked(nil), new: tracked(failed), want: false},
+		{name: "failed pod changed", old: tracked(failed), new: tracked(func(pod *corev1.Pod) { failed(pod); pod.Labels["tier"] = "backend" }), want: false},
+		{name: "failed status removed", old: tracked(failed), new: tracked(nil), want: true},
+	}
+	for _, test := range updates {
+		t.Run(test.name, func(t *testing.T) {
+			if got := predicate.Update(event.UpdateEvent{ObjectOld: test.old, ObjectNew: test.new}); got != test.want {
+				t.Errorf("Update() = %t, want %t", got, test.want)
+			}
+		})
+	}
+
+	if !predicate.Create(event.CreateEvent{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "resolvable"}}}) {
+		t.Errorf("Create() = false for a resolvable unlabelled pod, want it labelled if the webhook missed it")
+	}
+	if predicate.Create(event.CreateEvent{Object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "operator-pod"}}}) {
+		t.Errorf("Create() = true for an unlabelled pod that cannot be resolved")
+	}
+	if !predicate.Create(event.CreateEvent{Object: tracked(nil)}) {
+		t.Errorf("Create() = false for a labelled pod")
+	}
+	if predicate.Create(event.CreateEvent{Object: tracked(failed)}) {
+		t.Errorf("Create() = true for a failed pod, want it to wait for its Application")
+	}
+	if predicate.Generic(event.GenericEvent{Object: tracked(failed)}) {
+		t.Errorf("Generic() = true for a failed pod, want it to wait for its Application")
+	}
+	if !predicate.Delete(event.DeleteEvent{Object: tracked(failed)}) {
+		t.Errorf("Delete() = false for a tracked pod")
+	}
+}
+
+func TestResolvable(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+		{Group: "batch", Version: "v1", Kind: "Job"},
+		{Group: "example.com", Version: "v1", Kind: "Workload"},
+	} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	r := &PodReconciler{
+		OwnerKinds: []namespacescope.Permission{{Group: "apps", Resource: "replicasets"}},
+		KubernetesClient: &kubernetesclient.KubernetesClient{OwnerBridgeRules: []ownerbridge.Rule{
+			{Kind: "Pod", Label: "example.com/runner", ParentAPIVersion: "example.com/v1", ParentKind: "Runner"},
+			{APIVersion: "batch/v1", Kind: "Job", Label: "example.com/pipeline", ParentAPIVersion: "example.com/v1", ParentKind: "Pipeline"},
+		}},
+		untrackedOwners: utilcache.NewLRUExpireCache(untrackedOwnersCacheSize),
+	}
+	r.addUntrackedOwner(&metav1.OwnerReference{UID: "untracked"})
+	resolvable := r.resolvable(mapper)
+
+	owned := func(apiVersion, kind string, uid types.UID) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: apiVersion, Kind: kind, Name: "owner", UID: uid, Controller: ptr.To(true)},
+		}}}
+	}
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want bool
+	}{
+		{name: "bare pod", pod: &corev1.Pod{}, want: false},
+		{name: "pod tracked by ArgoCD", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{argocdconsts.ArgoCDTrackingIDAnnotation: "checkout:/Pod:shop/checkout"},
+		}}, want: true},
+		{name: "pod bridged by a rule", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"example.com/runner": "ci"}}}, want: true},
+		{name: "owner kind", pod: owned("apps/v1", "ReplicaSet", "rs"), want: true},
+		{name: "owner kind not listed", pod: owned("example.com/v1", "Workload", "workload"), want: false},
+		{name: "owner bridged by a rule", pod: owned("batch/v1", "Job", "job"), want: true},
+		{name: "unknown owner kind", pod: owned("example.com/v1", "Unknown", "unknown"), want: false},
+		{name: "owner not managed by ArgoCD", pod: owned("apps/v1", "ReplicaSet", "untracked"), want: false},
+		{name: "owner that is not the controller", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "owner", UID: "rs"},
+		}}}, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := resolvable(test.pod); got != test.want {
+				t.Errorf("resolvable() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	policyconsts "argocd-pod-enrichment/pkg/consts/enrichmentpolicy"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/pkg/enrichmentpolicy"
+	"argocd-pod-enrichment/pkg/runtimeconfig"
+)
+
+// policyStatusInterval is how often the matched pods of a policy are counted again
+const policyStatusInterval = time.Minute
+
+// PolicyStatus is the status of PodEnrichmentPolicy and ClusterPodEnrichmentPolicy
+type PolicyStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// MatchedPods counts the pods handled by the controller that the policy selects
+	MatchedPods int `json:"matchedPods"`
+	// Conditions hold the Valid condition, false with the validation error if the spec is invalid
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PolicyStatusReconciler reports in the status of the policies of Kind whether they are valid and how many
+// pods they match. It runs on the leader only, also when the pod controller is sharded.
+type PolicyStatusReconciler struct {
+	client.Client
+	// Kind is PodEnrichmentPolicy or ClusterPodEnrichmentPolicy
+	Kind string
+	// Counter counts the matched pods of the policies, shared by the reconcilers of both kinds
+	Counter *PolicyMatchCounter
+}
+
+// +kubebuilder:rbac:groups=enrichment.codefresh.io,resources=podenrichmentpolicies;clusterpodenrichmentpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=enrichment.codefresh.io,resources=podenrichmentpolicies/status;clusterpodenrichmentpolicies/status,verbs=get;update;patch
+
+func (r *PolicyStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logf.FromContext(ctx)
+
+	policy := r.newPolicy()
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var current PolicyStatus
+	if status, ok, _ := unstructured.NestedMap(policy.Object, "status"); ok {
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(status, &current)
+	}
+	status := PolicyStatus{ObservedGeneration: policy.GetGeneration(), Conditions: append([]metav1.Condition{}, current.Conditions...)}
+
+	_, err := enrichmentpolicy.Parse(policy)
+	if err != nil {
+		reason := policyconsts.ReasonInvalidSpec
+		if errors.Is(err, enrichmentpolicy.ErrReservedKey) {
+			reason = policyconsts.ReasonReservedKey
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               policyconsts.ConditionValid,
+			Status:             metav1.ConditionFalse,
+			Reason:             reason,
+			Message:            err.Error(),
+			ObservedGeneration: policy.GetGeneration(),
+		})
+	} else {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               policyconsts.ConditionValid,
+			Status:             metav1.ConditionTrue,
+			Reason:             policyconsts.ReasonValid,
+			ObservedGeneration: policy.GetGeneration(),
+		})
+		if status.MatchedPods, err = r.Counter.MatchedPods(ctx, policy); err != nil {
+			log.Error(err, "unable to count the pods matched by the policy")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if equality.Semantic.DeepEqual(current, status) {
+		return ctrl.Result{RequeueAfter: policyStatusInterval}, nil
+	}
+	encoded, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	original := policy.DeepCopy()
+	if err := unstructured.SetNestedMap(policy.Object, encoded, "status"); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.Status().Patch(ctx, policy, client.MergeFrom(original)); err != nil {
+		log.Error(err, "unable to update policy status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: policyStatusInterval}, nil
+}
+
+// PolicyMatchCounter counts the pods every policy applies to. All policies are counted from a single list of
+// the cached pods, which is reused for policyStatusInterval, so a resync of the statuses lists the pods once.
+type PolicyMatchCounter struct {
+	client.Reader
+	// ArgoCDNamespace holds the Applications of pods whose tracking does not name a namespace
+	ArgoCDNamespace string
+	// RuntimeConfig filters the namespaces whose pods are enriched, nil to count the pods of every namespace
+	RuntimeConfig *runtimeconfig.Store
+
+	mu      sync.Mutex
+	parser  enrichmentpolicy.Parser
+	counted time.Time
+	counts  map[types.UID]policyCount
+}
+
+type policyCount struct {
+	generation int64
+	pods       int
+}
+
+// MatchedPods returns the number of pods the policy applies to. The pods are counted again once the counts
+// are older than policyStatusInterval, or when they do not cover the current generation of the policy.
+func (c *PolicyMatchCounter) MatchedPods(ctx context.Context, policy client.Object) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count, ok := c.counts[policy.GetUID()]
+	if !ok || count.generation != policy.GetGeneration() || time.Since(c.counted) >= policyStatusInterval {
+		if err := c.count(ctx); err != nil {
+			return 0, err
+		}
+		count = c.counts[policy.GetUID()]
+	}
+	return count.pods, nil
+}
+
+// count evaluates the policies for every pod the controller enriches: pods with an Application label in the
+// watched namespaces, except the pods it gave up on. A pod opted out of the enrichment only counts for the
+// policy opting it out.
+func (c *PolicyMatchCounter) count(ctx context.Context) error {
+	objs := []unstructured.Unstructured{}
+	for _, kind := range policyKinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(policyconsts.PodEnrichmentPolicyGVK.GroupVersion().WithKind(kind + "List"))
+		if err := c.List(ctx, list); err != nil {
+			return err
+		}
+		objs = append(objs, list.Items...)
+	}
+	policies := make([]*enrichmentpolicy.Policy, 0, len(objs))
+	counts := make(map[types.UID]policyCount, len(objs))
+	uids := make(map[*enrichmentpolicy.Policy]types.UID, len(objs))
+	for i := range objs {
+		counts[objs[i].GetUID()] = policyCount{generation: objs[i].GetGeneration()}
+		if policy, err := c.parser.Parse(&objs[i]); err == nil {
+			policies = append(policies, policy)
+			uids[policy] = objs[i].GetUID()
+		}
+	}
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Labels[webhookconsts.ApplicationLabelKey] == "" || lookupFailed(pod) {
+			continue
+		}
+		if c.RuntimeConfig != nil && !c.RuntimeConfig.Current().Watches(pod.Namespace) {
+			continue
+		}
+		appNamespace, appName := applicationRef(pod, c.ArgoCDNamespace)
+		evaluation := enrichmentpolicy.Evaluate(policies, enrichmentpolicy.Target{
+			Namespace:            pod.Namespace,
+			Labels:               pod.Labels,
+			ApplicationNamespace: appNamespace,
+			ApplicationName:      appName,
+		})
+		matched := evaluation.Matched
+		if evaluation.OptOut != nil {
+			matched = []*enrichmentpolicy.Policy{evaluation.OptOut}
+		}
+		for _, policy := range matched {
+			count := counts[uids[policy]]
+			count.pods++
+			counts[uids[policy]] = count
+		}
+	}
+
+	c.counts = counts
+	c.counted = time.Now()
+	return nil
+}
+
+func (r *PolicyStatusReconciler) newPolicy() *unstructured.Unstructured {
+	policy := &unstructured.Unstructured{}
+	policy.SetGroupVersionKind(policyconsts.PodEnrichmentPolicyGVK.GroupVersion().WithKind(r.Kind))
+	return policy
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PolicyStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(r.newPolicy()).
+		Named(strings.ToLower(r.Kind) + "-status").
+		Complete(r)
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	policyconsts "argocd-pod-enrichment/pkg/consts/enrichmentpolicy"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+)
+
+func policyObject(t *testing.T, kind, namespace, name, spec string) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if err := yaml.Unmarshal([]byte(spec), &obj.Object); err != nil {
+		t.Fatalf("invalid test spec: %v", err)
+	}
+	obj.Object = map[string]interface{}{"spec": obj.Object}
+	obj.SetGroupVersionKind(policyconsts.PodEnrichmentPolicyGVK.GroupVersion().WithKind(kind))
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetUID(types.UID(namespace + "/" + name))
+	obj.SetGeneration(1)
+	return obj
+}
+
+func TestPolicyMatchCounter(t *testing.T) {
+	pod := func(namespace, name string, labels, annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels, Annotations: annotations}}
+	}
+	app := func(name string, labels map[string]string) map[string]string {
+		all := map[string]string{webhookconsts.ApplicationLabelKey: name}
+		for key, value := range labels {
+			all[key] = value
+		}
+		return all
+	}
+	team := policyObject(t, policyconsts.PodEnrichmentPolicyGVK.Kind, "shop", "team", `applicationFields: [{field: project, key: team.example.com/project}]`)
+	optOut := policyObject(t, policyconsts.PodEnrichmentPolicyGVK.Kind, "shop", "batch-opt-out", `
+selector: {podSelector: {matchLabels: {tier: batch}}}
+optOut: true`)
+	cluster := policyObject(t, policyconsts.ClusterPodEnrichmentPolicyGVK.Kind, "", "everywhere", `applicationFields: [{field: path, key: team.example.com/path}]`)
+	invalid := policyObject(t, policyconsts.PodEnrichmentPolicyGVK.Kind, "shop", "invalid", `applicationFields: [{field: unknown, key: team.example.com/unknown}]`)
+
+	counter := &PolicyMatchCounter{Reader: fake.NewClientBuilder().WithObjects(
+		team, optOut, cluster, invalid,
+		pod("shop", "checkout", app("checkout", nil), nil),
+		pod("shop", "cart", app("cart", nil), nil),
+		pod("shop", "report", app("report", map[string]string{"tier": "batch"}), nil),
+		pod("shop", "unlabelled", nil, nil),
+		pod("shop", "failed", app("checkout", nil), map[string]string{webhookconsts.EnrichmentStatusAnnotationKey: webhookconsts.EnrichmentStatusFailed}),
+		pod("billing", "invoice", app("invoice", nil), nil),
+	).Build()}
+
+	tests := []struct {
+		policy *unstructured.Unstructured
+		want   int
+	}{
+		{policy: team, want: 2},
+		{policy: optOut, want: 1},
+		{policy: cluster, want: 3},
+		{policy: invalid, want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.policy.GetName(), func(t *testing.T) {
+			got, err := counter.MatchedPods(context.Background(), test.policy)
+			if err != nil {
+				t.Fatalf("MatchedPods() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("MatchedPods() = %d, want %d", got, test.want)
+			}
+		})
+	}
+
+	// The counts of every policy come from the same list of pods
+	counted := counter.counted
+	if _, err := counter.MatchedPods(context.Background(), cluster); err != nil {
+		t.Fatalf("MatchedPods() error = %v", err)
+	}
+	if counter.counted != counted {
+		t.Errorf("MatchedPods() counted the pods again before policyStatusInterval")
+	}
+
+	// A new generation of a policy is counted again
+	updated := team.DeepCopy()
+	updated.SetGeneration(2)
+	if err := unstructured.SetNestedMap(updated.Object, map[string]interface{}{"applications": []interface{}{"cart"}}, "spec", "selector"); err != nil {
+		t.Fatal(err)
+	}
+	if err := counter.Reader.(client.Client).Update(context.Background(), updated); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := counter.Get(context.Background(), client.ObjectKeyFromObject(updated), updated); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got, err := counter.MatchedPods(context.Background(), updated)
+	if err != nil {
+		t.Fatalf("MatchedPods() error = %v", err)
+	}
+	if got != 1 {
+		t.Errorf("MatchedPods() = %d after the update, want 1", got)
+	}
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"argocd-pod-enrichment/pkg/argorollouts"
+	rolloutconsts "argocd-pod-enrichment/pkg/consts/argorollouts"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/pkg/enrichment"
+)
+
+// rolloutLabelKeys are the labels recomputed from the status of the Rollout of a pod
+var rolloutLabelKeys = []string{webhookconsts.RolloutRoleLabelKey, webhookconsts.RolloutStepIndexLabelKey}
+
+// rolloutSource returns the source of Rollout events re-enqueueing the pods of a Rollout whenever it promotes,
+// aborts or steps, or nil if the cluster does not serve Rollouts
+func (r *PodReconciler) rolloutSource(mgr ctrl.Manager) source.Source {
+	gvk := rolloutconsts.RolloutGVK
+	if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+		mgr.GetLogger().Info("Argo Rollouts are not served, the rollout role of pods is only set by the webhook", "reason", err.Error())
+		return nil
+	}
+	r.rolloutCache = mgr.GetCache()
+
+	rollout := &unstructured.Unstructured{}
+	rollout.SetGroupVersionKind(gvk)
+	return source.Kind(mgr.GetCache(), client.Object(rollout), handler.EnqueueRequestsFromMapFunc(r.podsForRollout), predicate.Funcs{
+		// New Rollouts have no pods yet, and the pods of deleted Rollouts are deleted with them
+		CreateFunc: func(event.CreateEvent) bool { return false },
+		DeleteFunc: func(event.DeleteEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return rolloutFingerprint(e.ObjectOld) != rolloutFingerprint(e.ObjectNew)
+		},
+	})
+}
+
+// rolloutFingerprint summarizes the status fields the rollout labels are computed from
+func rolloutFingerprint(obj client.Object) string {
+	rollout, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return ""
+	}
+	status, _, _ := unstructured.NestedMap(rollout.Object, "status")
+	return fmt.Sprintf("%v/%v/%v", status["stableRS"], status["currentPodHash"], status["currentStepIndex"])
+}
+
+// podsForRollout maps a Rollout to reconcile requests for its pods
+func (r *PodReconciler) podsForRollout(ctx context.Context, obj client.Object) []reconcile.Request {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(obj.GetNamespace()), client.MatchingLabels{webhookconsts.RolloutNameLabelKey: obj.GetName()}); err != nil {
+		logf.FromContext(ctx).Error(err, "unable to list pods of Rollout", "rollout", obj.GetName(), "namespace", obj.GetNamespace())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for i := range pods.Items {
+		if r.handles(pods.Items[i].Namespace) && !lookupFailed(&pods.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pods.Items[i])})
+		}
+	}
+	return requests
+}
+
+// rolloutMetadata recomputes the rollout role and step index of a Rollout pod from the status of its Rollout,
+// so the labels set by the webhook follow promotions and aborts. The labels of the pod are kept when the
+// Rollouts are not watched or the Rollout cannot be read.
+func (r *PodReconciler) rolloutMetadata(ctx context.Context, pod *corev1.Pod) *enrichment.Metadata {
+	metadata := enrichment.NewMetadata()
+	rolloutName := pod.Labels[webhookconsts.RolloutNameLabelKey]
+	if rolloutName == "" || r.rolloutCache == nil {
+		return metadata
+	}
+
+	rollout := &unstructured.Unstructured{}
+	rollout.SetGroupVersionKind(rolloutconsts.RolloutGVK)
+	if err := r.rolloutCache.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: rolloutName}, rollout); err != nil {
+		logf.FromContext(ctx).V(1).Info("Keeping the rollout labels of the Pod, unable to get its Rollout", "rollout", rolloutName, "reason", err.Error())
+		for _, key := range rolloutLabelKeys {
+			if value, ok := pod.Labels[key]; ok {
+				metadata.Set(enrichment.TargetLabel, key, value)
+			}
+		}
+		return metadata
+	}
+
+	podLabels := &unstructured.Unstructured{}
+	podLabels.SetLabels(pod.Labels)
+	info := argorollouts.ExtractRolloutInfo(podLabels, rollout)
+	if info.Role != "" {
+		metadata.Set(enrichment.TargetLabel, webhookconsts.RolloutRoleLabelKey, info.Role)
+	}
+	if info.StepIndex != "" {
+		metadata.Set(enrichment.TargetLabel, webhookconsts.RolloutStepIndexLabelKey, info.StepIndex)
+	}
+	return metadata
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rolloutconsts "argocd-pod-enrichment/pkg/consts/argorollouts"
+	webhookconsts "argocd-pod-enrichment/pkg/consts/webhook"
+)
+
+func TestRolloutMetadata(t *testing.T) {
+	rollout := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec":   map[string]interface{}{"strategy": map[string]interface{}{"canary": map[string]interface{}{}}},
+		"status": map[string]interface{}{"stableRS": "bbb", "currentPodHash": "bbb", "currentStepIndex": int64(4)},
+	}}
+	rollout.SetGroupVersionKind(rolloutconsts.RolloutGVK)
+	rollout.SetNamespace("shop")
+	rollout.SetName("checkout")
+	r := &PodReconciler{rolloutCache: fake.NewClientBuilder().WithObjects(rollout).Build()}
+
+	pod := func(rolloutName string, labels map[string]string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "checkout-abcde", Labels: map[string]string{}}}
+		if rolloutName != "" {
+			pod.Labels[webhookconsts.RolloutNameLabelKey] = rolloutName
+		}
+		for key, value := range labels {
+			pod.Labels[key] = value
+		}
+		return pod
+	}
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want map[string]string
+	}{
+		{
+			name: "canary promoted to stable",
+			pod: pod("checkout", map[string]string{
+				rolloutconsts.RolloutPodTemplateHashLabel: "bbb",
+				webhookconsts.RolloutRoleLabelKey:         rolloutconsts.RolloutRoleCanary,
+				webhookconsts.RolloutStepIndexLabelKey:    "1",
+			}),
+			want: map[string]string{webhookconsts.RolloutRoleLabelKey: rolloutconsts.RolloutRoleStable, webhookconsts.RolloutStepIndexLabelKey: "4"},
+		},
+		{
+			name: "pod of a replaced revision loses its role",
+			pod: pod("checkout", map[string]string{
+				rolloutconsts.RolloutPodTemplateHashLabel: "aaa",
+				webhookconsts.RolloutRoleLabelKey:         rolloutconsts.RolloutRoleStable,
+			}),
+			want: map[string]string{webhookconsts.RolloutStepIndexLabelKey: "4"},
+		},
+		{
+			name: "Rollout not found keeps the labels",
+			pod:  pod("deleted", map[string]string{webhookconsts.RolloutRoleLabelKey: rolloutconsts.RolloutRoleCanary}),
+			want: map[string]string{webhookconsts.RolloutRoleLabelKey: rolloutconsts.RolloutRoleCanary},
+		},
+		{
+			name: "pod without Rollout",
+			pod:  pod("", nil),
+			want: map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := r.rolloutMetadata(context.Background(), test.pod)
+			if !reflect.DeepEqual(got.Labels, test.want) {
+				t.Errorf("rolloutMetadata() labels = %v, want %v", got.Labels, test.want)
+			}
+			if len(got.Annotations) != 0 {
+				t.Errorf("rolloutMetadata() annotations = %v, want none", got.Annotations)
+			}
+		})
+	}
+
+	if got := (&PodReconciler{}).rolloutMetadata(context.Background(), tests[0].pod); len(got.Labels) != 0 {
+		t.Errorf("rolloutMetadata() without the Rollout watch = %v, want the labels left alone", got.Labels)
+	}
+}
+
+func TestRolloutFingerprint(t *testing.T) {
+	rollout := func(status map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+	}
+	base := map[string]interface{}{"stableRS": "aaa", "currentPodHash": "bbb", "currentStepIndex": int64(1)}
+	if rolloutFingerprint(rollout(base)) != rolloutFingerprint(rollout(map[string]interface{}{
+		"stableRS": "aaa", "currentPodHash": "bbb", "currentStepIndex": int64(1), "readyReplicas": int64(3),
+	})) {
+		t.Errorf("fingerprint changed with an unrelated status field")
+	}
+	for _, changed := range []map[string]interface{}{
+		{"stableRS": "bbb", "currentPodHash": "bbb", "currentStepIndex": int64(1)},
+		{"stableRS": "aaa", "currentPodHash": "ccc", "currentStepIndex": int64(1)},
+		{"stableRS": "aaa", "currentPodHash": "bbb", "currentStepIndex": int64(2)},
+	} {
+		if rolloutFingerprint(rollout(base)) == rolloutFingerprint(rollout(changed)) {
+			t.Errorf("fingerprint unchanged for status %v", changed)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// shardLeaseLabel marks the Leases of the shard members
+const shardLeaseLabel = "codefresh.io/pod-enrichment-shard"
+
+// Sharding splits the namespaces between the controller replicas. Every replica renews its own Lease, the
+// replicas with a live Lease are the members, and a namespace belongs to the member with the highest hash of
+// the namespace and the member (rendezvous hashing), so that a replica joining or leaving only moves the
+// namespaces it takes or gives away. Sharding does not scope the caches: every replica still watches and
+// caches the pods of all the watched namespaces, only the reconciles and the API writes are split.
+type Sharding struct {
+	// Client writes the Lease of the replica. Reader reads the Leases, it must not be backed by a cache
+	// so that no Lease informer is started.
+	Client client.Client
+	Reader client.Reader
+	// Namespace holds the Leases
+	Namespace string
+	// ReplicaID identifies the replica, it must be unique and stable for the life of the replica
+	ReplicaID string
+	// LeaseDuration is how long a member stays in the shard without renewing its Lease
+	LeaseDuration time.Duration
+	// RenewInterval is how often the Lease is renewed and the members are listed
+	RenewInterval time.Duration
+
+	mu          sync.RWMutex
+	members     []string
+	index       int
+	subscribers []chan struct{}
+}
+
+// Owns reports whether the namespace belongs to this replica. Nothing is owned until the members are known.
+func (s *Sharding) Owns(namespace string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.members) == 0 || s.index < 0 {
+		return false
+	}
+	return shardOf(namespace, s.members) == s.ReplicaID
+}
+
+// Subscribe returns a channel notified whenever the shard of the replica changes
+func (s *Sharding) Subscribe() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscriber := make(chan struct{}, 1)
+	s.subscribers = append(s.subscribers, subscriber)
+	return subscriber
+}
+
+// NeedLeaderElection is false, every replica is a shard member
+func (s *Sharding) NeedLeaderElection() bool {
+	return false
+}
+
+// Start renews the Lease of the replica and tracks the members until ctx is done, then releases the Lease
+// so the other members take over its namespaces without waiting for it to expire.
+func (s *Sharding) Start(ctx context.Context) error {
+	log := logf.FromContext(ctx).WithName("sharding").WithValues("replica", s.ReplicaID)
+	ticker := time.NewTicker(s.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.renew(ctx); err != nil {
+			log.Error(err, "unable to renew shard Lease")
+		}
+		if err := s.refreshMembers(ctx); err != nil {
+			log.Error(err, "unable to list shard members")
+		}
+
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.Client.Delete(releaseCtx, s.lease()); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "unable to release shard Lease")
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Sharding) lease() *coordinationv1.Lease {
+	return &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{
+		Name:      "argocd-pod-enrichment-shard-" + s.ReplicaID,
+		Namespace: s.Namespace,
+		Labels:    map[string]string{shardLeaseLabel: "true"},
+	}}
+}
+
+func (s *Sharding) renew(ctx context.Context) error {
+	now := metav1.NewMicroTime(time.Now())
+	lease := s.lease()
+	err := s.Reader.Get(ctx, client.ObjectKeyFromObject(lease), lease)
+	if apierrors.IsNotFound(err) {
+		lease.Spec = coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To(s.ReplicaID),
+			LeaseDurationSeconds: ptr.To(int32(s.LeaseDuration.Seconds())),
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		}
+		return s.Client.Create(ctx, lease)
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = ptr.To(s.ReplicaID)
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(s.LeaseDuration.Seconds()))
+	lease.Spec.RenewTime = &now
+	return s.Client.Update(ctx, lease)
+}
+
+// refreshMembers lists the replicas with a live Lease and notifies the subscribers if the shard changed
+func (s *Sharding) refreshMembers(ctx context.Context) error {
+	var leases coordinationv1.LeaseList
+	if err := s.Reader.List(ctx, &leases, client.InNamespace(s.Namespace), client.MatchingLabels{shardLeaseLabel: "true"}); err != nil {
+		return err
+	}
+
+	members := []string{}
+	for _, lease := range leases.Items {
+		if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		if time.Now().Before(expiry) {
+			members = append(members, *lease.Spec.HolderIdentity)
+		}
+	}
+	slices.Sort(members)
+	members = slices.Compact(members)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.Equal(members, s.members) {
+		return nil
+	}
+	s.members = members
+	s.index = slices.Index(members, s.ReplicaID)
+	logf.FromContext(ctx).WithName("sharding").Info("Shard members changed", "replica", s.ReplicaID, "members", members, "index", s.index)
+	for _, subscriber := range s.subscribers {
+		select {
+		case subscriber <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// shardOf returns the member owning a namespace, the one with the highest hash of the namespace and the member
+func shardOf(namespace string, members []string) string {
+	owner := ""
+	var highest uint64
+	for _, member := range members {
+		hash := fnv.New64a()
+		hash.Write([]byte(namespace))
+		hash.Write([]byte{0})
+		hash.Write([]byte(member))
+		if sum := mix(hash.Sum64()); owner == "" || sum > highest {
+			owner, highest = member, sum
+		}
+	}
+	return owner
+}
+
+// mix is the finalizer of MurmurHash3, FNV alone spreads poorly names which only differ by their last characters
+func mix(hash uint64) uint64 {
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestShardOf(t *testing.T) {
+	namespaces := make([]string, 3000)
+	for i := range namespaces {
+		namespaces[i] = fmt.Sprintf("namespace-%d", i)
+	}
+	owners := func(members []string) map[string]string {
+		owners := map[string]string{}
+		for _, namespace := range namespaces {
+			owners[namespace] = shardOf(namespace, members)
+		}
+		return owners
+	}
+
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+	}{
+		{name: "replica joins", before: []string{"replica-a", "replica-b", "replica-c"}, after: []string{"replica-a", "replica-b", "replica-c", "replica-d"}},
+		{name: "replica leaves", before: []string{"replica-a", "replica-b", "replica-c"}, after: []string{"replica-a", "replica-c"}},
+		{name: "replica replaced", before: []string{"replica-a", "replica-b", "replica-c"}, after: []string{"replica-a", "replica-c", "replica-d"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before, after := owners(test.before), owners(test.after)
+			counts := map[string]int{}
+			for _, namespace := range namespaces {
+				counts[after[namespace]]++
+				// A namespace only moves from a replica which left, or to a replica which joined
+				if before[namespace] != after[namespace] && slices.Contains(test.after, before[namespace]) && slices.Contains(test.before, after[namespace]) {
+					t.Errorf("namespace %s moved from %s to %s, both members before and after", namespace, before[namespace], after[namespace])
+				}
+			}
+			// Every member owns a fair share of the namespaces
+			for _, member := range test.after {
+				if share := len(namespaces) / len(test.after); counts[member] < share*3/4 || counts[member] > share*5/4 {
+					t.Errorf("member %s owns %d namespaces, want about %d", member, counts[member], share)
+				}
+			}
+		})
+	}
+
+	if owner := shardOf("shop", nil); owner != "" {
+		t.Errorf("shardOf() without members = %q, want none", owner)
+	}
+}
+
+func TestShardingOwns(t *testing.T) {
+	members := []string{"replica-a", "replica-b"}
+	owner := shardOf("shop", members)
+	tests := []struct {
+		name    string
+		members []string
+		replica string
+		want    bool
+	}{
+		{name: "owner", members: members, replica: owner, want: true},
+		{name: "other member", members: members, replica: other(members, owner), want: false},
+		{name: "not a member", members: members, replica: "replica-c", want: false},
+		{name: "members unknown", replica: owner, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Sharding{ReplicaID: test.replica, members: test.members, index: -1}
+			for i, member := range test.members {
+				if member == test.replica {
+					s.index = i
+				}
+			}
+			if got := s.Owns("shop"); got != test.want {
+				t.Errorf("Owns() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func other(values []string, value string) string {
+	for _, v := range values {
+		if v != value {
+			return v
+		}
+	}
+	return ""
+}
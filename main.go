@@ -6,11 +6,24 @@ import (
 	"github.com/spf13/cobra"
 	"argocd-pod-enrichment/cmd/webhook"
 	"argocd-pod-enrichment/cmd/controller"
+	"argocd-pod-enrichment/cmd/rbac"
+	"argocd-pod-enrichment/pkg/config"
+	argocdconsts "argocd-pod-enrichment/pkg/consts/argocd"
+	policyconsts "argocd-pod-enrichment/pkg/consts/enrichmentpolicy"
+	namespacescopeconsts "argocd-pod-enrichment/pkg/consts/namespacescope"
+	ownerbridgeconsts "argocd-pod-enrichment/pkg/consts/ownerbridge"
+	runtimeconfigconsts "argocd-pod-enrichment/pkg/consts/runtimeconfig"
 )
 
 var rootCmd = &cobra.Command{
    Use:   "argocd-pod-enrichment",
    Short: "ArgoCD Pod Enrichment",
+   // Errors are printed once by main
+   SilenceErrors: true,
+   // Usage is only printed for invalid command lines, not for errors of the subcommands
+   PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	   cmd.SilenceUsage = true
+   },
    Run: func(cmd *cobra.Command, args []string) {
 	   fmt.Println("Available subcommands:")
 	   for _, c := range cmd.Commands() {
@@ -21,8 +34,31 @@ var rootCmd = &cobra.Command{
 }
 
 func init() {
+	// Flags shared by the webhook and the controller
+	flags := rootCmd.PersistentFlags()
+	config.AddConfigFileFlag(rootCmd)
+	flags.String("kubeconfig", "", "Kubeconfig file, the in-cluster config is used if unset")
+	config.BindEnv(flags, "kubeconfig", "KUBECONFIG")
+	flags.String("argocd-namespace", "", "Namespace of ArgoCD")
+	config.BindEnv(flags, "argocd-namespace", argocdconsts.ArgoCDNamespaceEnvironmentVariable)
+	flags.String("argocd-tracking-label", argocdconsts.ArgoCDDefaultTrackingLabel, "Label ArgoCD tracks resources with")
+	config.BindEnv(flags, "argocd-tracking-label", argocdconsts.ArgoCDTrackingLabelEnvironmentVariable)
+	flags.String("owner-bridge-rules-file", "", "Owner bridge rules file")
+	config.BindEnv(flags, "owner-bridge-rules-file", ownerbridgeconsts.OwnerBridgeRulesFileEnvironmentVariable)
+	flags.StringSlice("watch-namespaces", nil, "Namespaces whose pods are enriched, all namespaces if unset")
+	config.BindEnv(flags, "watch-namespaces", namespacescopeconsts.WatchNamespacesEnvironmentVariable)
+	flags.String("watch-namespace-selector", "", "Label selector of the namespaces whose pods are enriched, resolved at startup")
+	config.BindEnv(flags, "watch-namespace-selector", namespacescopeconsts.WatchNamespaceSelectorEnvironmentVariable)
+	flags.StringSlice("owner-kinds", nil, "Owner kinds of pods as resource.group, the built-in workload kinds if unset")
+	config.BindEnv(flags, "owner-kinds", namespacescopeconsts.OwnerKindsEnvironmentVariable)
+	flags.Bool("enrichment-policies", false, "Evaluate PodEnrichmentPolicies and ClusterPodEnrichmentPolicies, their CRDs must be installed")
+	config.BindEnv(flags, "enrichment-policies", policyconsts.EnrichmentPoliciesEnvironmentVariable)
+	flags.String("runtime-config-map", "", "ConfigMap, as namespace/name, holding the configuration reloaded without restarting")
+	config.BindEnv(flags, "runtime-config-map", runtimeconfigconsts.RuntimeConfigMapEnvironmentVariable)
+
 	rootCmd.AddCommand(webhook.WebhookCmd)
 	rootCmd.AddCommand(controller.ControllerCmd)
+	rootCmd.AddCommand(rbac.RbacCmd)
 }
 
 func main() {
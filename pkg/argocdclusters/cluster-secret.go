@@ -0,0 +1,156 @@
+package argocdclusters
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	consts "argocd-pod-enrichment/pkg/consts/argocd"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Cluster is a destination cluster declared in an ArgoCD cluster secret
+type Cluster struct {
+	// SecretName is the name of the secret the cluster was read from
+	SecretName string
+	Name       string
+	Server     string
+	Config     ClusterConfig
+}
+
+// ClusterConfig mirrors the config field of ArgoCD cluster secrets
+type ClusterConfig struct {
+	Username           string              `json:"username,omitempty"`
+	Password           string              `json:"password,omitempty"`
+	BearerToken        string              `json:"bearerToken,omitempty"`
+	TLSClientConfig    TLSClientConfig     `json:"tlsClientConfig"`
+	ExecProviderConfig *ExecProviderConfig `json:"execProviderConfig,omitempty"`
+	AWSAuthConfig      json.RawMessage     `json:"awsAuthConfig,omitempty"`
+	ProxyURL           string              `json:"proxyUrl,omitempty"`
+	DisableCompression bool                `json:"disableCompression,omitempty"`
+}
+
+type TLSClientConfig struct {
+	Insecure   bool   `json:"insecure"`
+	ServerName string `json:"serverName,omitempty"`
+	CAData     []byte `json:"caData,omitempty"`
+	CertData   []byte `json:"certData,omitempty"`
+	KeyData    []byte `json:"keyData,omitempty"`
+}
+
+type ExecProviderConfig struct {
+	Command     string            `json:"command,omitempty"`
+	Args        []string          `json:"args,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	APIVersion  string            `json:"apiVersion,omitempty"`
+	InstallHint string            `json:"installHint,omitempty"`
+}
+
+// IsClusterSecret reports whether the secret is labelled as an ArgoCD cluster secret
+func IsClusterSecret(secret *corev1.Secret) bool {
+	return secret.Labels[consts.ArgoCDSecretTypeLabel] == consts.ArgoCDSecretTypeCluster
+}
+
+// ClusterFromSecret parses an ArgoCD cluster secret
+func ClusterFromSecret(secret *corev1.Secret) (*Cluster, error) {
+	cluster := &Cluster{
+		SecretName: secret.Name,
+		Name:       string(secret.Data["name"]),
+		Server:     string(secret.Data["server"]),
+	}
+	if cluster.Server == "" {
+		return nil, fmt.Errorf("cluster secret %s/%s has no server", secret.Namespace, secret.Name)
+	}
+	if cluster.Name == "" {
+		cluster.Name = cluster.Server
+	}
+	if config := secret.Data["config"]; len(config) > 0 {
+		if err := json.Unmarshal(config, &cluster.Config); err != nil {
+			return nil, fmt.Errorf("failed to parse config of cluster secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		}
+	}
+	return cluster, nil
+}
+
+// IsInCluster reports whether the cluster is the one ArgoCD runs in
+func (c *Cluster) IsInCluster() bool {
+	return c.Server == consts.ArgoCDInClusterServer
+}
+
+// RestConfig builds a client config to connect to the cluster
+func (c *Cluster) RestConfig() (*rest.Config, error) {
+	if len(c.Config.AWSAuthConfig) > 0 && string(c.Config.AWSAuthConfig) != "null" {
+		return nil, fmt.Errorf("cluster %s uses awsAuthConfig, which is not supported, use execProviderConfig instead", c.Name)
+	}
+
+	config := &rest.Config{
+		Host:        c.Server,
+		Username:    c.Config.Username,
+		Password:    c.Config.Password,
+		BearerToken: c.Config.BearerToken,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure:   c.Config.TLSClientConfig.Insecure,
+			ServerName: c.Config.TLSClientConfig.ServerName,
+			CAData:     c.Config.TLSClientConfig.CAData,
+			CertData:   c.Config.TLSClientConfig.CertData,
+			KeyData:    c.Config.TLSClientConfig.KeyData,
+		},
+		DisableCompression: c.Config.DisableCompression,
+	}
+
+	if c.Config.ProxyURL != "" {
+		proxyURL, err := parseProxyURL(c.Config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxyUrl for cluster %s: %w", c.Name, err)
+		}
+		config.Proxy = proxyURL
+	}
+
+	if exec := c.Config.ExecProviderConfig; exec != nil {
+		envNames := make([]string, 0, len(exec.Env))
+		for name := range exec.Env {
+			envNames = append(envNames, name)
+		}
+		sort.Strings(envNames)
+		env := []clientcmdapi.ExecEnvVar{}
+		for _, name := range envNames {
+			env = append(env, clientcmdapi.ExecEnvVar{Name: name, Value: exec.Env[name]})
+		}
+		config.ExecProvider = &clientcmdapi.ExecConfig{
+			Command:         exec.Command,
+			Args:            exec.Args,
+			Env:             env,
+			APIVersion:      exec.APIVersion,
+			InstallHint:     exec.InstallHint,
+			InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+		}
+	}
+
+	return config, nil
+}
+
+// Hash returns a digest of the connection details, used to detect changes that require reconnecting
+func (c *Cluster) Hash() string {
+	data, _ := json.Marshal(struct {
+		Name   string
+		Server string
+		Config ClusterConfig
+	}{c.Name, c.Server, c.Config})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func parseProxyURL(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	return http.ProxyURL(parsed), nil
+}
@@ -0,0 +1,140 @@
+package argocdclusters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	consts "argocd-pod-enrichment/pkg/consts/argocd"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// Identity unambiguously names a destination cluster of ArgoCD
+type Identity struct {
+	Name   string
+	Server string
+}
+
+// ClusterLister lists the clusters known to ArgoCD
+type ClusterLister func(ctx context.Context) ([]Identity, error)
+
+// Resolver maps the destination of an Application to the cluster identity.
+// The cluster list is cached, since every reconciled pod needs it. Failures are cached too, with a backoff,
+// so that a missing permission does not turn every reconcile into a failing list call.
+type Resolver struct {
+	List ClusterLister
+	TTL  time.Duration
+
+	mu        sync.Mutex
+	clusters  []Identity
+	fetchedAt time.Time
+	err       error
+	retryAt   time.Time
+	backoff   time.Duration
+}
+
+const (
+	defaultResolverTTL = time.Minute
+	// minResolverBackoff is the first delay before listing the clusters again after a failure. It doubles with
+	// every failure, up to the TTL.
+	minResolverBackoff = 5 * time.Second
+)
+
+// ErrListBackoff is returned, wrapping the last failure, while the Resolver waits before listing the clusters again
+var ErrListBackoff = errors.New("waiting before listing ArgoCD clusters again")
+
+// NewResolver returns a Resolver caching the result of list
+func NewResolver(list ClusterLister) *Resolver {
+	return &Resolver{List: list, TTL: defaultResolverTTL}
+}
+
+// Resolve returns the identity of the cluster an Application is deployed to
+func (r *Resolver) Resolve(ctx context.Context, app *unstructured.Unstructured) (*Identity, error) {
+	server, _, _ := unstructured.NestedString(app.Object, "spec", "destination", "server")
+	name, _, _ := unstructured.NestedString(app.Object, "spec", "destination", "name")
+	if server == "" && name == "" {
+		return nil, fmt.Errorf("application %s/%s has no destination", app.GetNamespace(), app.GetName())
+	}
+
+	clusters, err := r.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cluster := range clusters {
+		if (server != "" && cluster.Server == server) || (server == "" && cluster.Name == name) {
+			return &cluster, nil
+		}
+	}
+
+	// ArgoCD knows the local cluster even without a cluster secret
+	if server == consts.ArgoCDInClusterServer || (server == "" && name == consts.ArgoCDInClusterName) {
+		return &Identity{Name: consts.ArgoCDInClusterName, Server: consts.ArgoCDInClusterServer}, nil
+	}
+
+	return nil, fmt.Errorf("no ArgoCD cluster matches destination server %q name %q", server, name)
+}
+
+func (r *Resolver) list(ctx context.Context) ([]Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.clusters != nil && now.Sub(r.fetchedAt) < r.TTL {
+		return r.clusters, nil
+	}
+	if r.err != nil && now.Before(r.retryAt) {
+		return nil, fmt.Errorf("%w: %w", ErrListBackoff, r.err)
+	}
+	clusters, err := r.List(ctx)
+	if err != nil {
+		r.backoff = min(max(2*r.backoff, minResolverBackoff), r.TTL)
+		r.err = fmt.Errorf("failed to list ArgoCD clusters: %w", err)
+		r.retryAt = now.Add(r.backoff)
+		return nil, r.err
+	}
+	if clusters == nil {
+		clusters = []Identity{}
+	}
+	r.clusters = clusters
+	r.fetchedAt = now
+	r.err = nil
+	r.backoff = 0
+	return clusters, nil
+}
+
+// Identity returns the identity of a cluster read from a cluster secret
+func (c *Cluster) Identity() Identity {
+	return Identity{Name: c.Name, Server: c.Server}
+}
+
+// SecretClusterLister lists clusters from the ArgoCD cluster secrets in namespace. Only the name and server
+// of each secret are read, its credentials are dropped right away.
+func SecretClusterLister(client corev1client.SecretsGetter, namespace string) ClusterLister {
+	selector := labels.SelectorFromSet(labels.Set{consts.ArgoCDSecretTypeLabel: consts.ArgoCDSecretTypeCluster}).String()
+
+	return func(ctx context.Context) ([]Identity, error) {
+		list, err := client.Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		clusters := []Identity{}
+		for _, secret := range list.Items {
+			identity := Identity{Name: string(secret.Data["name"]), Server: string(secret.Data["server"])}
+			if identity.Server == "" {
+				// A single broken secret should not hide every other cluster
+				continue
+			}
+			if identity.Name == "" {
+				identity.Name = identity.Server
+			}
+			clusters = append(clusters, identity)
+		}
+		return clusters, nil
+	}
+}
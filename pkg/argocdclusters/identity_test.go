@@ -0,0 +1,150 @@
+package argocdclusters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	consts "argocd-pod-enrichment/pkg/consts/argocd"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func application(server, name string) *unstructured.Unstructured {
+	app := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	app.SetNamespace("argocd")
+	app.SetName("guestbook")
+	if server != "" {
+		_ = unstructured.SetNestedField(app.Object, server, "spec", "destination", "server")
+	}
+	if name != "" {
+		_ = unstructured.SetNestedField(app.Object, name, "spec", "destination", "name")
+	}
+	return app
+}
+
+func TestResolve(t *testing.T) {
+	clusters := []Identity{
+		{Name: "production", Server: "https://production.example.com"},
+		{Name: "staging", Server: "https://staging.example.com"},
+	}
+	resolver := NewResolver(func(ctx context.Context) ([]Identity, error) { return clusters, nil })
+
+	tests := []struct {
+		name    string
+		server  string
+		cluster string
+		want    *Identity
+	}{
+		{name: "by server", server: "https://staging.example.com", want: &clusters[1]},
+		{name: "by name", cluster: "production", want: &clusters[0]},
+		{name: "server takes precedence", server: "https://production.example.com", cluster: "staging", want: &clusters[0]},
+		{name: "in-cluster server", server: consts.ArgoCDInClusterServer, want: &Identity{Name: consts.ArgoCDInClusterName, Server: consts.ArgoCDInClusterServer}},
+		{name: "in-cluster name", cluster: consts.ArgoCDInClusterName, want: &Identity{Name: consts.ArgoCDInClusterName, Server: consts.ArgoCDInClusterServer}},
+		{name: "unknown", server: "https://unknown.example.com"},
+		{name: "no destination"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := resolver.Resolve(context.Background(), application(test.server, test.cluster))
+			if test.want == nil {
+				if err == nil {
+					t.Fatalf("Resolve() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if *got != *test.want {
+				t.Errorf("Resolve() = %v, want %v", *got, *test.want)
+			}
+		})
+	}
+}
+
+func TestResolverCachesFailures(t *testing.T) {
+	calls := 0
+	failing := true
+	resolver := NewResolver(func(ctx context.Context) ([]Identity, error) {
+		calls++
+		if failing {
+			return nil, errors.New("forbidden")
+		}
+		return []Identity{{Name: "production", Server: "https://production.example.com"}}, nil
+	})
+	app := application("https://production.example.com", "")
+
+	if _, err := resolver.Resolve(context.Background(), app); err == nil || errors.Is(err, ErrListBackoff) {
+		t.Fatalf("first Resolve() error = %v, want the list error", err)
+	}
+	if _, err := resolver.Resolve(context.Background(), app); !errors.Is(err, ErrListBackoff) {
+		t.Fatalf("second Resolve() error = %v, want ErrListBackoff", err)
+	}
+	if calls != 1 {
+		t.Fatalf("clusters listed %d times during the backoff, want 1", calls)
+	}
+	if resolver.backoff != minResolverBackoff {
+		t.Errorf("backoff = %s, want %s", resolver.backoff, minResolverBackoff)
+	}
+
+	// The backoff doubles with every failure, up to the TTL
+	for i := 0; i < 10; i++ {
+		resolver.retryAt = time.Time{}
+		_, _ = resolver.Resolve(context.Background(), app)
+	}
+	if resolver.backoff != resolver.TTL {
+		t.Errorf("backoff = %s after repeated failures, want the TTL %s", resolver.backoff, resolver.TTL)
+	}
+
+	failing = false
+	resolver.retryAt = time.Time{}
+	identity, err := resolver.Resolve(context.Background(), app)
+	if err != nil || identity.Name != "production" {
+		t.Fatalf("Resolve() after recovery = %v, %v", identity, err)
+	}
+	if resolver.backoff != 0 || resolver.err != nil {
+		t.Errorf("backoff = %s, err = %v after a success, want them reset", resolver.backoff, resolver.err)
+	}
+}
+
+func TestSecretClusterLister(t *testing.T) {
+	secret := func(name string, labels map[string]string, data map[string]string) *corev1.Secret {
+		s := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "argocd", Name: name, Labels: labels},
+			Data:       map[string][]byte{},
+		}
+		for key, value := range data {
+			s.Data[key] = []byte(value)
+		}
+		return s
+	}
+	clusterLabels := map[string]string{consts.ArgoCDSecretTypeLabel: consts.ArgoCDSecretTypeCluster}
+	client := fake.NewClientset(
+		secret("production", clusterLabels, map[string]string{"name": "production", "server": "https://production.example.com", "config": `{"bearerToken":"secret"}`}),
+		secret("unnamed", clusterLabels, map[string]string{"server": "https://unnamed.example.com"}),
+		secret("broken", clusterLabels, map[string]string{"name": "broken"}),
+		secret("repository", map[string]string{consts.ArgoCDSecretTypeLabel: "repository"}, map[string]string{"name": "repo", "server": "https://git.example.com"}),
+	)
+
+	clusters, err := SecretClusterLister(client.CoreV1(), "argocd")(context.Background())
+	if err != nil {
+		t.Fatalf("list error = %v", err)
+	}
+	want := map[Identity]bool{
+		{Name: "production", Server: "https://production.example.com"}:               true,
+		{Name: "https://unnamed.example.com", Server: "https://unnamed.example.com"}: true,
+	}
+	if len(clusters) != len(want) {
+		t.Fatalf("clusters = %v, want %v", clusters, want)
+	}
+	for _, cluster := range clusters {
+		if !want[cluster] {
+			t.Errorf("unexpected cluster %v", cluster)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package argocdhooks
+
+import (
+	"strings"
+
+	consts "argocd-pod-enrichment/pkg/consts/argocd"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+type HookInfo struct {
+	// Types are the hook types from the hook annotation, e.g. PreSync or PostSync
+	Types        []string
+	DeletePolicy string
+	ResourceKind string
+	ResourceName string
+}
+
+type SyncOperationInfo struct {
+	Revision  string
+	Phase     string
+	StartedAt string
+}
+
+// FindHook returns the first resource in an owner chain annotated as an ArgoCD sync hook, or nil if there is none.
+func FindHook(chain []*unstructured.Unstructured) *HookInfo {
+	for _, res := range chain {
+		annotations := res.GetAnnotations()
+		hook, ok := annotations[consts.ArgoCDHookAnnotation]
+		if !ok {
+			continue
+		}
+
+		info := &HookInfo{
+			DeletePolicy: annotations[consts.ArgoCDHookDeletePolicyAnnotation],
+			ResourceKind: res.GetKind(),
+			ResourceName: res.GetName(),
+		}
+		for _, hookType := range strings.Split(hook, ",") {
+			if hookType = strings.TrimSpace(hookType); hookType != "" {
+				info.Types = append(info.Types, hookType)
+			}
+		}
+		return info
+	}
+	return nil
+}
+
+// LabelValue returns the hook types in a form usable as a label value.
+func (h *HookInfo) LabelValue() string {
+	return strings.Join(h.Types, "_")
+}
+
+// ExtractSyncOperation returns the current or last sync operation of an ArgoCD Application,
+// or nil if the Application has no operation state.
+func ExtractSyncOperation(app *unstructured.Unstructured) *SyncOperationInfo {
+	operationState, found, _ := unstructured.NestedMap(app.Object, "status", "operationState")
+	if !found {
+		return nil
+	}
+
+	info := &SyncOperationInfo{}
+	info.Phase, _, _ = unstructured.NestedString(operationState, "phase")
+	info.StartedAt, _, _ = unstructured.NestedString(operationState, "startedAt")
+	info.Revision, _, _ = unstructured.NestedString(operationState, "operation", "sync", "revision")
+	if info.Revision == "" {
+		info.Revision, _, _ = unstructured.NestedString(operationState, "syncResult", "revision")
+	}
+
+	return info
+}
+
+// IsValidLabelValue reports whether value can be used as a label value as is.
+func IsValidLabelValue(value string) bool {
+	return len(validation.IsValidLabelValue(value)) == 0
+}
@@ -0,0 +1,98 @@
+package argocdhooks
+
+import (
+	"reflect"
+	"testing"
+
+	consts "argocd-pod-enrichment/pkg/consts/argocd"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func resource(kind, name string, annotations map[string]string) *unstructured.Unstructured {
+	res := &unstructured.Unstructured{}
+	res.SetKind(kind)
+	res.SetName(name)
+	res.SetAnnotations(annotations)
+	return res
+}
+
+func TestFindHook(t *testing.T) {
+	pod := resource("Pod", "migrate-abcde", nil)
+	tests := []struct {
+		name  string
+		chain []*unstructured.Unstructured
+		want  *HookInfo
+	}{
+		{
+			name:  "no hook",
+			chain: []*unstructured.Unstructured{pod, resource("Job", "migrate", nil)},
+		},
+		{
+			name:  "empty chain",
+			chain: nil,
+		},
+		{
+			name: "hook owner",
+			chain: []*unstructured.Unstructured{pod, resource("Job", "migrate", map[string]string{
+				consts.ArgoCDHookAnnotation:             "PreSync",
+				consts.ArgoCDHookDeletePolicyAnnotation: "HookSucceeded",
+			})},
+			want: &HookInfo{Types: []string{"PreSync"}, DeletePolicy: "HookSucceeded", ResourceKind: "Job", ResourceName: "migrate"},
+		},
+		{
+			name:  "hook pod",
+			chain: []*unstructured.Unstructured{resource("Pod", "smoke-test", map[string]string{consts.ArgoCDHookAnnotation: "PostSync"})},
+			want:  &HookInfo{Types: []string{"PostSync"}, ResourceKind: "Pod", ResourceName: "smoke-test"},
+		},
+		{
+			name:  "several hook types",
+			chain: []*unstructured.Unstructured{pod, resource("Job", "migrate", map[string]string{consts.ArgoCDHookAnnotation: "PreSync, Sync,,"})},
+			want:  &HookInfo{Types: []string{"PreSync", "Sync"}, ResourceKind: "Job", ResourceName: "migrate"},
+		},
+		{
+			name: "first hook of the chain",
+			chain: []*unstructured.Unstructured{
+				pod,
+				resource("Job", "migrate", map[string]string{consts.ArgoCDHookAnnotation: "PreSync"}),
+				resource("CronJob", "nightly", map[string]string{consts.ArgoCDHookAnnotation: "PostSync"}),
+			},
+			want: &HookInfo{Types: []string{"PreSync"}, ResourceKind: "Job", ResourceName: "migrate"},
+		},
+		{
+			name:  "empty hook annotation",
+			chain: []*unstructured.Unstructured{pod, resource("Job", "migrate", map[string]string{consts.ArgoCDHookAnnotation: ""})},
+			want:  &HookInfo{ResourceKind: "Job", ResourceName: "migrate"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := FindHook(test.chain); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("FindHook() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		types []string
+		want  string
+	}{
+		{name: "no type", want: ""},
+		{name: "one type", types: []string{"PreSync"}, want: "PreSync"},
+		{name: "several types", types: []string{"PreSync", "PostSync"}, want: "PreSync_PostSync"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := (&HookInfo{Types: test.types}).LabelValue()
+			if got != test.want {
+				t.Errorf("LabelValue() = %q, want %q", got, test.want)
+			}
+			if !IsValidLabelValue(got) {
+				t.Errorf("LabelValue() = %q, not a valid label value", got)
+			}
+		})
+	}
+}
@@ -3,7 +3,6 @@ package argocdresourcetracking
 import (
 	"argocd-pod-enrichment/pkg/consts/argocd"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"os"
 	"strings"
 )
 
@@ -13,7 +12,9 @@ type ArgoCDTrackingInfo struct {
 	ApplicationNamespace string
 }
 
-func ExtractArgoCDTrackingInfo(resource unstructured.Unstructured) *ArgoCDTrackingInfo {
+// ExtractArgoCDTrackingInfo reads the ArgoCD tracking of a resource from its tracking id annotation, or from
+// trackingLabel, the default tracking label if empty
+func ExtractArgoCDTrackingInfo(resource unstructured.Unstructured, trackingLabel string) *ArgoCDTrackingInfo {
 	annotations := resource.GetAnnotations()
 
 	var (
@@ -44,13 +45,10 @@ func ExtractArgoCDTrackingInfo(resource unstructured.Unstructured) *ArgoCDTracki
 			applicationNamespace = appNameAndNamespaceSlice[0]
 		}
 	} else {
-		// Check if ArgoCDTrackingLabelEnvironmentVariable is set in the environment
-		labelEnv := os.Getenv(consts.ArgoCDTrackingLabelEnvironmentVariable)
-
 		labels := resource.GetLabels()
 
-		if labelEnv != "" {
-			if val, ok := labels[labelEnv]; ok {
+		if trackingLabel != "" {
+			if val, ok := labels[trackingLabel]; ok {
 				applicationName = val
 			}
 		} else {
@@ -0,0 +1,61 @@
+package argorollouts
+
+import (
+	"strconv"
+
+	consts "argocd-pod-enrichment/pkg/consts/argorollouts"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type RolloutInfo struct {
+	RolloutName string
+	// Role is stable, canary or preview, empty if the pod hash matches neither stable nor current ReplicaSet
+	Role string
+	// StepIndex is the current canary step index, empty if the Rollout has no steps
+	StepIndex string
+}
+
+// FindRollout returns the first Argo Rollout in an owner chain, or nil if there is none.
+func FindRollout(chain []*unstructured.Unstructured) *unstructured.Unstructured {
+	for _, res := range chain {
+		gv, err := schema.ParseGroupVersion(res.GetAPIVersion())
+		if err != nil {
+			continue
+		}
+		if gv.Group == consts.RolloutGroup && res.GetKind() == consts.RolloutKind {
+			return res
+		}
+	}
+	return nil
+}
+
+// ExtractRolloutInfo determines which side of a Rollout the pod belongs to,
+// based on its rollouts-pod-template-hash label and the Rollout status.
+func ExtractRolloutInfo(pod *unstructured.Unstructured, rollout *unstructured.Unstructured) *RolloutInfo {
+	info := &RolloutInfo{RolloutName: rollout.GetName()}
+
+	podHash := pod.GetLabels()[consts.RolloutPodTemplateHashLabel]
+	stableHash, _, _ := unstructured.NestedString(rollout.Object, "status", "stableRS")
+	currentHash, _, _ := unstructured.NestedString(rollout.Object, "status", "currentPodHash")
+
+	if podHash != "" {
+		switch podHash {
+		case stableHash:
+			info.Role = consts.RolloutRoleStable
+		case currentHash:
+			if _, isBlueGreen, _ := unstructured.NestedMap(rollout.Object, "spec", "strategy", "blueGreen"); isBlueGreen {
+				info.Role = consts.RolloutRolePreview
+			} else {
+				info.Role = consts.RolloutRoleCanary
+			}
+		}
+	}
+
+	if stepIndex, found, _ := unstructured.NestedInt64(rollout.Object, "status", "currentStepIndex"); found {
+		info.StepIndex = strconv.FormatInt(stepIndex, 10)
+	}
+
+	return info
+}
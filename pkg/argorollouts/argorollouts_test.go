@@ -0,0 +1,111 @@
+package argorollouts
+
+import (
+	"testing"
+
+	consts "argocd-pod-enrichment/pkg/consts/argorollouts"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func rolloutObject(strategy string, status map[string]interface{}) *unstructured.Unstructured {
+	rollout := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec":   map[string]interface{}{"strategy": map[string]interface{}{strategy: map[string]interface{}{}}},
+		"status": status,
+	}}
+	rollout.SetAPIVersion("argoproj.io/v1alpha1")
+	rollout.SetKind(consts.RolloutKind)
+	rollout.SetName("checkout")
+	return rollout
+}
+
+func podObject(hash string) *unstructured.Unstructured {
+	pod := &unstructured.Unstructured{}
+	if hash != "" {
+		pod.SetLabels(map[string]string{consts.RolloutPodTemplateHashLabel: hash})
+	}
+	return pod
+}
+
+func TestExtractRolloutInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		status   map[string]interface{}
+		podHash  string
+		want     RolloutInfo
+	}{
+		{
+			name:     "stable pod",
+			strategy: "canary",
+			status:   map[string]interface{}{"stableRS": "aaa", "currentPodHash": "bbb"},
+			podHash:  "aaa",
+			want:     RolloutInfo{RolloutName: "checkout", Role: consts.RolloutRoleStable},
+		},
+		{
+			name:     "canary pod",
+			strategy: "canary",
+			status:   map[string]interface{}{"stableRS": "aaa", "currentPodHash": "bbb", "currentStepIndex": int64(2)},
+			podHash:  "bbb",
+			want:     RolloutInfo{RolloutName: "checkout", Role: consts.RolloutRoleCanary, StepIndex: "2"},
+		},
+		{
+			name:     "preview pod of a blue-green Rollout",
+			strategy: "blueGreen",
+			status:   map[string]interface{}{"stableRS": "aaa", "currentPodHash": "bbb"},
+			podHash:  "bbb",
+			want:     RolloutInfo{RolloutName: "checkout", Role: consts.RolloutRolePreview},
+		},
+		{
+			name:     "fully promoted Rollout",
+			strategy: "canary",
+			status:   map[string]interface{}{"stableRS": "bbb", "currentPodHash": "bbb", "currentStepIndex": int64(3)},
+			podHash:  "bbb",
+			want:     RolloutInfo{RolloutName: "checkout", Role: consts.RolloutRoleStable, StepIndex: "3"},
+		},
+		{
+			name:     "pod of an older revision",
+			strategy: "canary",
+			status:   map[string]interface{}{"stableRS": "aaa", "currentPodHash": "bbb"},
+			podHash:  "ccc",
+			want:     RolloutInfo{RolloutName: "checkout"},
+		},
+		{
+			name:     "pod without hash",
+			strategy: "canary",
+			status:   map[string]interface{}{"stableRS": "", "currentPodHash": ""},
+			want:     RolloutInfo{RolloutName: "checkout"},
+		},
+		{
+			name:     "Rollout without status",
+			strategy: "canary",
+			podHash:  "aaa",
+			want:     RolloutInfo{RolloutName: "checkout"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ExtractRolloutInfo(podObject(test.podHash), rolloutObject(test.strategy, test.status))
+			if *got != test.want {
+				t.Errorf("ExtractRolloutInfo() = %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
+
+func TestFindRollout(t *testing.T) {
+	replicaSet := &unstructured.Unstructured{}
+	replicaSet.SetAPIVersion("apps/v1")
+	replicaSet.SetKind("ReplicaSet")
+	rollout := rolloutObject("canary", nil)
+	otherGroup := &unstructured.Unstructured{}
+	otherGroup.SetAPIVersion("example.com/v1")
+	otherGroup.SetKind(consts.RolloutKind)
+
+	if got := FindRollout([]*unstructured.Unstructured{podObject(""), replicaSet, rollout}); got != rollout {
+		t.Errorf("FindRollout() = %v, want the Rollout", got)
+	}
+	if got := FindRollout([]*unstructured.Unstructured{podObject(""), replicaSet, otherGroup}); got != nil {
+		t.Errorf("FindRollout() = %v, want nil for a Rollout kind of another group", got)
+	}
+}
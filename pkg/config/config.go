@@ -0,0 +1,287 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	consts "argocd-pod-enrichment/pkg/consts/config"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// BindEnv binds a flag to an environment variable. The variable is read when the flag is not set on the
+// command line, and it is shown in the usage of the flag.
+func BindEnv(flags *pflag.FlagSet, name, env string) {
+	flag := flags.Lookup(name)
+	if flag == nil {
+		panic(fmt.Sprintf("config: unknown flag %q", name))
+	}
+	if err := flags.SetAnnotation(name, consts.EnvironmentVariableAnnotation, []string{env}); err != nil {
+		panic(err)
+	}
+	flag.Usage = fmt.Sprintf("%s [$%s]", flag.Usage, env)
+}
+
+// AddConfigFileFlag adds the --config flag naming the configuration file to the persistent flags of the root command
+func AddConfigFileFlag(root *cobra.Command) {
+	root.PersistentFlags().String(consts.ConfigFileFlag, "", "Configuration file, top-level keys are flag names and a section per command overrides them")
+	BindEnv(root.PersistentFlags(), consts.ConfigFileFlag, consts.ConfigFileEnvironmentVariable)
+}
+
+// Config is the configuration of a command resolved from its flags, environment variables and configuration file.
+// Settings without a flag on the command keep their zero value.
+type Config struct {
+	// Shared by every command
+	Kubeconfig             string
+	ArgoCDNamespace        string
+	ArgoCDTrackingLabel    string
+	OwnerBridgeRulesFile   string
+	WatchNamespaces        []string
+	WatchNamespaceSelector string
+	OwnerKinds             []string
+	EnrichmentPolicies     bool
+	RuntimeConfigMap       string
+
+	// ArgoCD API server, Applications are read from the cluster if ArgoCDServer is empty
+	ArgoCDServer                string
+	ArgoCDAuthToken             string
+	ArgoCDPlainText             bool
+	ArgoCDInsecure              bool
+	ArgoCDServerCAFile          string
+	ArgoCDApplicationNamespaces []string
+
+	MultiCluster                bool
+	ClusterName                 string
+	ClusterServer               string
+	ClusterIdentityFromArgoCD   bool
+	EnrichmentConfigFile        string
+	ApplicationLookupMaxRetries int
+	Sharding                    bool
+	ShardNamespace              string
+	ShardReplicaID              string
+}
+
+// Load resolves every flag of cmd with the precedence command line, environment variable, configuration file,
+// default, and returns the resolved configuration. The environment is only read, never written.
+func Load(cmd *cobra.Command) (*Config, error) {
+	flags := cmd.Flags()
+
+	path := os.Getenv(consts.ConfigFileEnvironmentVariable)
+	if flag := flags.Lookup(consts.ConfigFileFlag); flag != nil && flag.Changed {
+		path = flag.Value.String()
+	}
+	fileValues := map[string]interface{}{}
+	if path != "" {
+		var err error
+		if fileValues, err = loadFile(cmd, path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == consts.ConfigFileFlag || flag.Changed {
+			return
+		}
+		env := envOf(flag)
+		if value := os.Getenv(env); env != "" && value != "" {
+			if err := flag.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for $%s: %w", value, env, err))
+			}
+		} else if value, ok := fileValues[flag.Name]; ok {
+			if err := setFromFile(flag, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value for %s in %s: %w", flag.Name, path, err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return newConfig(flags)
+}
+
+// newConfig reads the resolved flags into a Config
+func newConfig(flags *pflag.FlagSet) (*Config, error) {
+	values := flagValues{flags: flags}
+	config := &Config{
+		Kubeconfig:             values.string("kubeconfig"),
+		ArgoCDNamespace:        values.string("argocd-namespace"),
+		ArgoCDTrackingLabel:    values.string("argocd-tracking-label"),
+		OwnerBridgeRulesFile:   values.string("owner-bridge-rules-file"),
+		WatchNamespaces:        values.stringSlice("watch-namespaces"),
+		WatchNamespaceSelector: values.string("watch-namespace-selector"),
+		OwnerKinds:             values.stringSlice("owner-kinds"),
+		EnrichmentPolicies:     values.bool("enrichment-policies"),
+		RuntimeConfigMap:       values.string("runtime-config-map"),
+
+		ArgoCDServer:                values.string("argocd-server"),
+		ArgoCDAuthToken:             values.string("argocd-auth-token"),
+		ArgoCDPlainText:             values.bool("argocd-plaintext"),
+		ArgoCDInsecure:              values.bool("argocd-insecure"),
+		ArgoCDServerCAFile:          values.string("argocd-server-ca-file"),
+		ArgoCDApplicationNamespaces: values.stringSlice("argocd-application-namespaces"),
+
+		MultiCluster:                values.bool("multicluster"),
+		ClusterName:                 values.string("cluster-name"),
+		ClusterServer:               values.string("cluster-server"),
+		ClusterIdentityFromArgoCD:   values.bool("cluster-identity-from-argocd"),
+		EnrichmentConfigFile:        values.string("enrichment-config-file"),
+		ApplicationLookupMaxRetries: values.int("application-lookup-max-retries"),
+		Sharding:                    values.bool("sharding"),
+		ShardNamespace:              values.string("shard-namespace"),
+		ShardReplicaID:              values.string("shard-replica-id"),
+	}
+	return config, errors.Join(values.errs...)
+}
+
+// flagValues reads typed flag values, collecting the errors of flags of an unexpected type
+type flagValues struct {
+	flags *pflag.FlagSet
+	errs  []error
+}
+
+func (v *flagValues) lookup(name string) *pflag.Flag {
+	return v.flags.Lookup(name)
+}
+
+func (v *flagValues) string(name string) string {
+	if flag := v.lookup(name); flag != nil {
+		return flag.Value.String()
+	}
+	return ""
+}
+
+func (v *flagValues) bool(name string) bool {
+	if v.lookup(name) == nil {
+		return false
+	}
+	value, err := v.flags.GetBool(name)
+	v.errs = append(v.errs, err)
+	return value
+}
+
+func (v *flagValues) int(name string) int {
+	if v.lookup(name) == nil {
+		return 0
+	}
+	value, err := v.flags.GetInt(name)
+	v.errs = append(v.errs, err)
+	return value
+}
+
+func (v *flagValues) stringSlice(name string) []string {
+	flag := v.lookup(name)
+	if flag == nil {
+		return nil
+	}
+	sliceValue, ok := flag.Value.(pflag.SliceValue)
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("flag %s is a %s, not a list", name, flag.Value.Type()))
+		return nil
+	}
+	return sliceValue.GetSlice()
+}
+
+// loadFile reads the configuration file and returns the values of the flags of cmd, the section of cmd
+// overriding the top-level keys. Every key must be a flag of some command, so that typos are not ignored.
+func loadFile(cmd *cobra.Command, path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	file := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	root := cmd.Root()
+	commands := map[string]*cobra.Command{}
+	for _, command := range root.Commands() {
+		commands[command.Name()] = command
+	}
+
+	var errs []error
+	values := map[string]interface{}{}
+	keys := make([]string, 0, len(file))
+	for key := range file {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := file[key]
+		if command, ok := commands[key]; ok {
+			section, ok := value.(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: expected a section of %s flags", key, key))
+				continue
+			}
+			names := make([]string, 0, len(section))
+			for name := range section {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if command.Flags().Lookup(name) == nil && root.PersistentFlags().Lookup(name) == nil {
+					errs = append(errs, fmt.Errorf("%s.%s: unknown flag of %s", key, name, key))
+				}
+			}
+			continue
+		}
+		if !isFlagOfAnyCommand(root, key) {
+			errs = append(errs, fmt.Errorf("%s: unknown flag", key))
+			continue
+		}
+		values[key] = value
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if section, ok := file[cmd.Name()].(map[string]interface{}); ok && cmd != root {
+		for name, value := range section {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+func isFlagOfAnyCommand(root *cobra.Command, name string) bool {
+	if root.PersistentFlags().Lookup(name) != nil {
+		return true
+	}
+	for _, command := range root.Commands() {
+		if command.Flags().Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func setFromFile(flag *pflag.Flag, value interface{}) error {
+	if list, ok := value.([]interface{}); ok {
+		sliceValue, ok := flag.Value.(pflag.SliceValue)
+		if !ok {
+			return fmt.Errorf("expected a %s, not a list", flag.Value.Type())
+		}
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		return sliceValue.Replace(items)
+	}
+	if _, ok := value.(map[string]interface{}); ok {
+		return fmt.Errorf("expected a %s, not a map", flag.Value.Type())
+	}
+	return flag.Value.Set(fmt.Sprint(value))
+}
+
+func envOf(flag *pflag.Flag) string {
+	if env := flag.Annotations[consts.EnvironmentVariableAnnotation]; len(env) > 0 {
+		return env[0]
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	consts "argocd-pod-enrichment/pkg/consts/config"
+
+	"github.com/spf13/cobra"
+)
+
+// loadCommand runs the controller subcommand of a small command tree and returns the configuration it loaded
+func loadCommand(t *testing.T, args []string) (*Config, error) {
+	t.Helper()
+	root := &cobra.Command{Use: "root", SilenceErrors: true, SilenceUsage: true}
+	AddConfigFileFlag(root)
+	root.PersistentFlags().String("argocd-namespace", "", "Namespace of ArgoCD")
+	BindEnv(root.PersistentFlags(), "argocd-namespace", "TEST_ARGOCD_NAMESPACE")
+
+	var config *Config
+	controller := &cobra.Command{Use: "controller", RunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		config, err = Load(cmd)
+		return err
+	}}
+	controller.Flags().String("argocd-server", "", "ArgoCD API server")
+	BindEnv(controller.Flags(), "argocd-server", "TEST_ARGOCD_SERVER")
+	controller.Flags().StringSlice("argocd-application-namespaces", nil, "Application namespaces")
+	BindEnv(controller.Flags(), "argocd-application-namespaces", "TEST_ARGOCD_APPLICATION_NAMESPACES")
+	controller.Flags().Int("application-lookup-max-retries", 3, "Lookup retries")
+	BindEnv(controller.Flags(), "application-lookup-max-retries", "TEST_APPLICATION_LOOKUP_MAX_RETRIES")
+	controller.Flags().Bool("sharding", false, "Sharding")
+	BindEnv(controller.Flags(), "sharding", "TEST_SHARDING")
+
+	webhook := &cobra.Command{Use: "webhook", Run: func(cmd *cobra.Command, args []string) {}}
+	webhook.Flags().Int("port", 8443, "Port")
+	root.AddCommand(controller, webhook)
+
+	root.SetArgs(append([]string{"controller"}, args...))
+	err := root.Execute()
+	return config, err
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: Config{ApplicationLookupMaxRetries: 3},
+		},
+		{
+			name: "config file",
+			file: "argocd-namespace: argocd\nargocd-application-namespaces: [team-a, team-b]\nsharding: true\n",
+			want: Config{ArgoCDNamespace: "argocd", ArgoCDApplicationNamespaces: []string{"team-a", "team-b"}, Sharding: true, ApplicationLookupMaxRetries: 3},
+		},
+		{
+			name: "command section overrides top-level keys",
+			file: "application-lookup-max-retries: 4\ncontroller:\n  application-lookup-max-retries: 5\nwebhook:\n  port: 9443\n",
+			want: Config{ApplicationLookupMaxRetries: 5},
+		},
+		{
+			name: "environment overrides config file",
+			env:  map[string]string{"TEST_ARGOCD_NAMESPACE": "from-env", "TEST_ARGOCD_APPLICATION_NAMESPACES": "team-c,team-d"},
+			file: "argocd-namespace: from-file\nargocd-application-namespaces: [team-a]\n",
+			want: Config{ArgoCDNamespace: "from-env", ArgoCDApplicationNamespaces: []string{"team-c", "team-d"}, ApplicationLookupMaxRetries: 3},
+		},
+		{
+			name: "empty environment variable is ignored",
+			env:  map[string]string{"TEST_ARGOCD_NAMESPACE": ""},
+			file: "argocd-namespace: from-file\n",
+			want: Config{ArgoCDNamespace: "from-file", ApplicationLookupMaxRetries: 3},
+		},
+		{
+			name: "flag overrides environment and config file",
+			args: []string{"--argocd-namespace=from-flag", "--argocd-server=flag:443", "--application-lookup-max-retries=7"},
+			env:  map[string]string{"TEST_ARGOCD_NAMESPACE": "from-env", "TEST_ARGOCD_SERVER": "env:443"},
+			file: "argocd-namespace: from-file\ncontroller:\n  application-lookup-max-retries: 5\n",
+			want: Config{ArgoCDNamespace: "from-flag", ArgoCDServer: "flag:443", ApplicationLookupMaxRetries: 7},
+		},
+		{
+			name:    "invalid environment variable",
+			env:     map[string]string{"TEST_APPLICATION_LOOKUP_MAX_RETRIES": "many"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid config file value",
+			file:    "sharding: maybe\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown config file key",
+			file:    "argocd-namespaces: argocd\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown flag in a command section",
+			file:    "webhook:\n  argocd-server: argocd:443\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(consts.ConfigFileEnvironmentVariable, "")
+			for _, name := range []string{"TEST_ARGOCD_NAMESPACE", "TEST_ARGOCD_SERVER", "TEST_ARGOCD_APPLICATION_NAMESPACES", "TEST_APPLICATION_LOOKUP_MAX_RETRIES", "TEST_SHARDING"} {
+				t.Setenv(name, test.env[name])
+			}
+			args := test.args
+			if test.file != "" {
+				args = append(args, "--config", writeConfigFile(t, test.file))
+			}
+
+			got, err := loadCommand(t, args)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("Load() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got.ArgoCDApplicationNamespaces == nil {
+				got.ArgoCDApplicationNamespaces = []string{}
+			}
+			if test.want.ArgoCDApplicationNamespaces == nil {
+				test.want.ArgoCDApplicationNamespaces = []string{}
+			}
+			if !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("Load() = %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFromEnvironment(t *testing.T) {
+	t.Setenv("TEST_ARGOCD_NAMESPACE", "")
+	t.Setenv(consts.ConfigFileEnvironmentVariable, writeConfigFile(t, "argocd-namespace: from-env-file\n"))
+
+	got, err := loadCommand(t, nil)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.ArgoCDNamespace != "from-env-file" {
+		t.Errorf("Load() ArgoCDNamespace = %q, want the value of the file named by %s", got.ArgoCDNamespace, consts.ConfigFileEnvironmentVariable)
+	}
+
+	got, err = loadCommand(t, []string{"--config", writeConfigFile(t, "argocd-namespace: from-flag-file\n")})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.ArgoCDNamespace != "from-flag-file" {
+		t.Errorf("Load() ArgoCDNamespace = %q, want the value of the file named by --config", got.ArgoCDNamespace)
+	}
+}
+
+func TestLoadLeavesEnvironmentUnchanged(t *testing.T) {
+	t.Setenv(consts.ConfigFileEnvironmentVariable, "")
+	t.Setenv("TEST_ARGOCD_NAMESPACE", "")
+	t.Setenv("TEST_ARGOCD_SERVER", "")
+
+	if _, err := loadCommand(t, []string{"--argocd-server=argocd:443", "--config", writeConfigFile(t, "argocd-namespace: argocd\n")}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for _, name := range []string{"TEST_ARGOCD_NAMESPACE", "TEST_ARGOCD_SERVER"} {
+		if value := os.Getenv(name); value != "" {
+			t.Errorf("Load() set $%s = %q, want the environment left unchanged", name, value)
+		}
+	}
+}
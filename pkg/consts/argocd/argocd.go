@@ -1,8 +1,57 @@
 package consts
 
+import "k8s.io/apimachinery/pkg/runtime/schema"
+
 const (
 	ArgoCDTrackingLabelEnvironmentVariable = "ARGOCD_TRACKING_LABEL"
-	ArgoCDTrackingIDAnnotation = "argocd.argoproj.io/tracking-id"
-	ArgoCDInstallationIDAnnotation = "argocd.argoproj.io/installation-id"
-	ArgoCDDefaultTrackingLabel = "app.kubernetes.io/instance"
+	ArgoCDNamespaceEnvironmentVariable     = "ARGOCD_NAMESPACE"
+	ArgoCDTrackingIDAnnotation             = "argocd.argoproj.io/tracking-id"
+	ArgoCDInstallationIDAnnotation         = "argocd.argoproj.io/installation-id"
+	ArgoCDDefaultTrackingLabel             = "app.kubernetes.io/instance"
+	ArgoCDHookAnnotation                   = "argocd.argoproj.io/hook"
+	ArgoCDHookDeletePolicyAnnotation       = "argocd.argoproj.io/hook-delete-policy"
+)
+
+// ApplicationGVR is the GroupVersionResource of ArgoCD Applications
+var ApplicationGVR = schema.GroupVersionResource{
+	Group:    "argoproj.io",
+	Version:  "v1alpha1",
+	Resource: "applications",
+}
+
+const (
+	ArgoCDServerEnvironmentVariable       = "ARGOCD_SERVER"
+	ArgoCDAuthTokenEnvironmentVariable    = "ARGOCD_AUTH_TOKEN"
+	ArgoCDPlainTextEnvironmentVariable    = "ARGOCD_PLAINTEXT"
+	ArgoCDInsecureEnvironmentVariable     = "ARGOCD_INSECURE"
+	ArgoCDServerCAFileEnvironmentVariable = "ARGOCD_SERVER_CA_FILE"
+)
+
+const (
+	ArgoCDSecretTypeLabel                 = "argocd.argoproj.io/secret-type"
+	ArgoCDSecretTypeCluster               = "cluster"
+	ArgoCDInClusterServer                 = "https://kubernetes.default.svc"
+	ArgoCDMultiClusterEnvironmentVariable = "ARGOCD_MULTICLUSTER"
 )
+
+// ArgoCDApplicationNamespacesEnvironmentVariable lists, comma separated, the namespaces besides ARGOCD_NAMESPACE
+// that hold Applications, like the application.namespaces setting of ArgoCD
+const ArgoCDApplicationNamespacesEnvironmentVariable = "ARGOCD_APPLICATION_NAMESPACES"
+
+const (
+	ArgoCDInClusterName = "in-cluster"
+)
+
+// AppProjectGVR is the GroupVersionResource of ArgoCD AppProjects
+var AppProjectGVR = schema.GroupVersionResource{
+	Group:    "argoproj.io",
+	Version:  "v1alpha1",
+	Resource: "appprojects",
+}
+
+// ApplicationGVK is the GroupVersionKind of ArgoCD Applications
+var ApplicationGVK = schema.GroupVersionKind{
+	Group:   "argoproj.io",
+	Version: "v1alpha1",
+	Kind:    "Application",
+}
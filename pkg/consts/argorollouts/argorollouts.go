@@ -0,0 +1,17 @@
+package consts
+
+import "k8s.io/apimachinery/pkg/runtime/schema"
+
+const (
+	RolloutGroup                = "argoproj.io"
+	RolloutKind                 = "Rollout"
+	RolloutResource             = "rollouts"
+	RolloutPodTemplateHashLabel = "rollouts-pod-template-hash"
+
+	RolloutRoleStable  = "stable"
+	RolloutRoleCanary  = "canary"
+	RolloutRolePreview = "preview"
+)
+
+// RolloutGVK is the GroupVersionKind of Argo Rollouts
+var RolloutGVK = schema.GroupVersionKind{Group: RolloutGroup, Version: "v1alpha1", Kind: RolloutKind}
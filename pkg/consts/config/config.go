@@ -0,0 +1,9 @@
+package consts
+
+const (
+	// ConfigFileEnvironmentVariable is the path of the optional configuration file, like the --config flag
+	ConfigFileEnvironmentVariable = "CONFIG_FILE"
+	ConfigFileFlag                = "config"
+	// EnvironmentVariableAnnotation is the flag annotation holding the environment variable bound to the flag
+	EnvironmentVariableAnnotation = "argocd-pod-enrichment/env"
+)
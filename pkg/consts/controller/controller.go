@@ -0,0 +1,51 @@
+package consts
+
+const (
+	ClusterNameEnvironmentVariable   = "CLUSTER_NAME"
+	ClusterServerEnvironmentVariable = "CLUSTER_SERVER"
+	// ClusterIdentityFromArgoCDEnvironmentVariable enables resolving the cluster identity from the ArgoCD
+	// clusters, when CLUSTER_NAME is not set
+	ClusterIdentityFromArgoCDEnvironmentVariable = "CLUSTER_IDENTITY_FROM_ARGOCD"
+)
+
+// FieldManager is the server-side apply field manager owning the enrichment keys on pods
+const FieldManager = "argocd-pod-enrichment"
+
+// LegacyControllerAnnotationKey was set on every pod by earlier versions of the controller
+const LegacyControllerAnnotationKey = "test.codefresh.io/controller"
+
+// ApplicationLookupMaxRetriesEnvironmentVariable limits the retries of a failed Application lookup before
+// the pod is marked as failed
+const ApplicationLookupMaxRetriesEnvironmentVariable = "APPLICATION_LOOKUP_MAX_RETRIES"
+
+// DefaultApplicationLookupMaxRetries retries for about 17 minutes with the default backoff
+const DefaultApplicationLookupMaxRetries = 10
+
+const (
+	// ShardingEnvironmentVariable enables sharding the namespaces between the controller replicas
+	ShardingEnvironmentVariable = "SHARDING_ENABLED"
+	// ShardNamespaceEnvironmentVariable is the namespace of the shard Leases, defaults to POD_NAMESPACE
+	// or the namespace of the service account
+	ShardNamespaceEnvironmentVariable = "SHARD_NAMESPACE"
+	// ShardReplicaIDEnvironmentVariable identifies the replica, defaults to the hostname
+	ShardReplicaIDEnvironmentVariable = "SHARD_REPLICA_ID"
+	PodNamespaceEnvironmentVariable   = "POD_NAMESPACE"
+)
+
+// ServiceAccountNamespaceFile holds the namespace of the pod running the controller
+const ServiceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Environment variables of the manager flags
+const (
+	MetricsBindAddressEnvironmentVariable     = "METRICS_BIND_ADDRESS"
+	HealthProbeBindAddressEnvironmentVariable = "HEALTH_PROBE_BIND_ADDRESS"
+	LeaderElectEnvironmentVariable            = "LEADER_ELECT"
+	MetricsSecureEnvironmentVariable          = "METRICS_SECURE"
+	MetricsCertPathEnvironmentVariable        = "METRICS_CERT_PATH"
+	MetricsCertNameEnvironmentVariable        = "METRICS_CERT_NAME"
+	MetricsCertKeyEnvironmentVariable         = "METRICS_CERT_KEY"
+	WebhookCertPathEnvironmentVariable        = "WEBHOOK_CERT_PATH"
+	WebhookCertNameEnvironmentVariable        = "WEBHOOK_CERT_NAME"
+	WebhookCertKeyEnvironmentVariable         = "WEBHOOK_CERT_KEY"
+	EnableHTTP2EnvironmentVariable            = "ENABLE_HTTP2"
+)
@@ -0,0 +1,29 @@
+package consts
+
+const (
+	EnrichmentConfigFileEnvironmentVariable = "ENRICHMENT_CONFIG_FILE"
+)
+
+// ReservedKeyPrefix is the prefix of the keys the webhook and the controller set themselves, which the enrichment
+// config and policies cannot set
+const ReservedKeyPrefix = "codefresh.io/"
+
+// Application fields that can be mapped to pod labels or annotations
+const (
+	ApplicationFieldProject              = "project"
+	ApplicationFieldRepoURL              = "repoURL"
+	ApplicationFieldPath                 = "path"
+	ApplicationFieldChart                = "chart"
+	ApplicationFieldTargetRevision       = "targetRevision"
+	ApplicationFieldDestinationNamespace = "destinationNamespace"
+	ApplicationFieldDestinationName      = "destinationName"
+	ApplicationFieldDestinationServer    = "destinationServer"
+)
+
+// Variables of enrichment expressions
+const (
+	ExpressionVariablePod         = "pod"
+	ExpressionVariableOwners      = "owners"
+	ExpressionVariableApplication = "app"
+	ExpressionVariableProject     = "project"
+)
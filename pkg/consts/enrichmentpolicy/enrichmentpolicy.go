@@ -0,0 +1,39 @@
+package consts
+
+import (
+	enrichmentconsts "argocd-pod-enrichment/pkg/consts/enrichment"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// EnrichmentPoliciesEnvironmentVariable enables the PodEnrichmentPolicy and ClusterPodEnrichmentPolicy resources
+	EnrichmentPoliciesEnvironmentVariable = "ENRICHMENT_POLICIES_ENABLED"
+)
+
+// ReservedKeyPrefix is the prefix of the keys the webhook and the controller set themselves, which policies
+// cannot set
+const ReservedKeyPrefix = enrichmentconsts.ReservedKeyPrefix
+
+const (
+	Group   = "enrichment.codefresh.io"
+	Version = "v1alpha1"
+)
+
+// PodEnrichmentPolicyGVK is the namespaced policy, applying to the pods of its namespace
+var PodEnrichmentPolicyGVK = schema.GroupVersionKind{Group: Group, Version: Version, Kind: "PodEnrichmentPolicy"}
+
+// ClusterPodEnrichmentPolicyGVK is the cluster-scoped policy, applying to the pods of every namespace
+var ClusterPodEnrichmentPolicyGVK = schema.GroupVersionKind{Group: Group, Version: Version, Kind: "ClusterPodEnrichmentPolicy"}
+
+var PodEnrichmentPolicyGVR = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "podenrichmentpolicies"}
+
+var ClusterPodEnrichmentPolicyGVR = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "clusterpodenrichmentpolicies"}
+
+// Condition of the policy status reporting whether the spec is valid
+const (
+	ConditionValid    = "Valid"
+	ReasonValid       = "Valid"
+	ReasonInvalidSpec = "InvalidSpec"
+	ReasonReservedKey = "ReservedKey"
+)
@@ -0,0 +1,11 @@
+package consts
+
+const (
+	// WatchNamespacesEnvironmentVariable lists, comma separated, the namespaces whose pods are enriched
+	WatchNamespacesEnvironmentVariable = "WATCH_NAMESPACES"
+	// WatchNamespaceSelectorEnvironmentVariable selects the namespaces whose pods are enriched by label
+	WatchNamespaceSelectorEnvironmentVariable = "WATCH_NAMESPACE_SELECTOR"
+	// OwnerKindsEnvironmentVariable lists, comma separated as resource.group, the owner kinds of pods,
+	// e.g. replicasets.apps,rollouts.argoproj.io. It defaults to the built-in workload kinds.
+	OwnerKindsEnvironmentVariable = "OWNER_KINDS"
+)
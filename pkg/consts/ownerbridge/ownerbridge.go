@@ -0,0 +1,5 @@
+package consts
+
+const (
+	OwnerBridgeRulesFileEnvironmentVariable = "OWNER_BRIDGE_RULES_FILE"
+)
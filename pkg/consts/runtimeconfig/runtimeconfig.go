@@ -0,0 +1,22 @@
+package consts
+
+const (
+	// RuntimeConfigMapEnvironmentVariable names, as namespace/name, the ConfigMap holding the runtime config
+	RuntimeConfigMapEnvironmentVariable = "RUNTIME_CONFIG_MAP"
+)
+
+// Keys of the runtime config ConfigMap. Missing keys keep the configuration the component started with.
+const (
+	// EnrichmentConfigKey holds the enrichment config, in the format of ENRICHMENT_CONFIG_FILE
+	EnrichmentConfigKey = "enrichment.yaml"
+	// WatchNamespacesKey lists, comma separated, the namespaces whose pods are enriched
+	WatchNamespacesKey = "watch-namespaces"
+	// WatchNamespaceSelectorKey selects the namespaces whose pods are enriched by label
+	WatchNamespaceSelectorKey = "watch-namespace-selector"
+)
+
+// Reasons of the Events recorded on the runtime config ConfigMap
+const (
+	ReasonConfigReloaded = "ConfigReloaded"
+	ReasonConfigRejected = "ConfigRejected"
+)
@@ -5,3 +5,54 @@ const (
 	ApplicationNamespaceLabelKey = "codefresh.io/application-namespace"
 	InstallationIDLabelKey       = "codefresh.io/installation-id"
 )
+
+const (
+	RolloutNameLabelKey      = "codefresh.io/rollout-name"
+	RolloutRoleLabelKey      = "codefresh.io/rollout-role"
+	RolloutStepIndexLabelKey = "codefresh.io/rollout-step-index"
+)
+
+const (
+	HookLabelKey                   = "codefresh.io/argocd-hook"
+	HookSyncRevisionLabelKey       = "codefresh.io/argocd-hook-sync-revision"
+	HookSyncStartedAtAnnotationKey = "codefresh.io/argocd-hook-sync-started-at"
+)
+
+const (
+	ClusterNameLabelKey        = "codefresh.io/cluster-name"
+	ClusterServerAnnotationKey = "codefresh.io/cluster-server"
+)
+
+// Sync annotations are written once, when the controller first reconciles a pod
+const (
+	SyncRevisionAnnotationKey            = "codefresh.io/argocd-sync-revision"
+	SyncOperationPhaseAnnotationKey      = "codefresh.io/argocd-sync-operation-phase"
+	SyncOperationStartedAtAnnotationKey  = "codefresh.io/argocd-sync-operation-started-at"
+	SyncOperationFinishedAtAnnotationKey = "codefresh.io/argocd-sync-operation-finished-at"
+	SyncHistoryIDAnnotationKey           = "codefresh.io/argocd-sync-history-id"
+)
+
+// Status labels are kept in sync with the Application when status mirroring is enabled
+const (
+	HealthStatusLabelKey = "codefresh.io/argocd-health-status"
+	SyncStatusLabelKey   = "codefresh.io/argocd-sync-status"
+)
+
+// ManagedKeysAnnotationKey lists the labels and annotations the controller manages on a pod,
+// so keys whose source disappeared can be removed
+const ManagedKeysAnnotationKey = "codefresh.io/managed-keys"
+
+// Enrichment status annotations are set when the controller gives up enriching a pod,
+// and removed once enrichment succeeds
+const (
+	EnrichmentStatusAnnotationKey        = "codefresh.io/enrichment-status"
+	EnrichmentFailureReasonAnnotationKey = "codefresh.io/enrichment-failure-reason"
+	EnrichmentStatusFailed               = "failed"
+)
+
+// Environment variables of the webhook server flags
+const (
+	TLSCertEnvironmentVariable = "WEBHOOK_TLS_CERT"
+	TLSKeyEnvironmentVariable  = "WEBHOOK_TLS_KEY"
+	PortEnvironmentVariable    = "WEBHOOK_PORT"
+)
@@ -0,0 +1,66 @@
+package enrichment
+
+import (
+	consts "argocd-pod-enrichment/pkg/consts/enrichment"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ApplicationFieldValue returns the value of a mappable field of an ArgoCD Application.
+// Source fields are read from the primary source.
+func ApplicationFieldValue(app *unstructured.Unstructured, field string, primary *Source) string {
+	var path []string
+	switch field {
+	case consts.ApplicationFieldProject:
+		path = []string{"spec", "project"}
+	case consts.ApplicationFieldRepoURL:
+		return primary.RepoURL
+	case consts.ApplicationFieldPath:
+		return primary.Path
+	case consts.ApplicationFieldChart:
+		return primary.Chart
+	case consts.ApplicationFieldTargetRevision:
+		return primary.TargetRevision
+	case consts.ApplicationFieldDestinationNamespace:
+		path = []string{"spec", "destination", "namespace"}
+	case consts.ApplicationFieldDestinationName:
+		path = []string{"spec", "destination", "name"}
+	case consts.ApplicationFieldDestinationServer:
+		path = []string{"spec", "destination", "server"}
+	default:
+		return ""
+	}
+	value, _, _ := unstructured.NestedString(app.Object, path...)
+	return value
+}
+
+// ApplicationMetadata returns the pod metadata derived from the Application according to the config.
+// It also returns the keys that were skipped because their value is not a valid label value.
+func (c *Config) ApplicationMetadata(app *unstructured.Unstructured) (*Metadata, []string) {
+	metadata := NewMetadata()
+	skipped := []string{}
+
+	sources := ApplicationSources(app)
+	primary, _ := c.Sources.Primary.Select(sources)
+	if c.Sources.Annotation != "" && len(sources) > 0 {
+		metadata.Set(TargetAnnotation, c.Sources.Annotation, EncodeSources(sources))
+	}
+
+	for _, mapping := range c.ApplicationFields {
+		value := ApplicationFieldValue(app, mapping.Field, &primary)
+		if value == "" {
+			continue
+		}
+		if !metadata.Set(mapping.Target, mapping.Key, value) {
+			skipped = append(skipped, mapping.Key)
+		}
+	}
+	return metadata, skipped
+}
+
+// ApplicationStatus returns the health and sync status of an Application
+func ApplicationStatus(app *unstructured.Unstructured) (string, string) {
+	health, _, _ := unstructured.NestedString(app.Object, "status", "health", "status")
+	sync, _, _ := unstructured.NestedString(app.Object, "status", "sync", "status")
+	return health, sync
+}
@@ -0,0 +1,135 @@
+package enrichment
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	consts "argocd-pod-enrichment/pkg/consts/enrichment"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config describes which ArgoCD Application metadata the controller copies to pods
+type Config struct {
+	// ApplicationFields map fields of the Application spec to pod labels or annotations
+	ApplicationFields []FieldMapping `json:"applicationFields,omitempty"`
+	// Sources controls the representation of Application sources, including multi-source Applications
+	Sources SourcesConfig `json:"sources,omitempty"`
+	// MirrorApplicationStatus keeps the health and sync status of the Application mirrored on its pods
+	MirrorApplicationStatus bool `json:"mirrorApplicationStatus,omitempty"`
+	// PropagationRules copy Application labels and annotations to the pod, DefaultPropagationRules if unset
+	PropagationRules []PropagationRule `json:"propagationRules,omitempty"`
+	// Expressions compute pod labels or annotations from the pod, its owners, the Application and its AppProject
+	Expressions []Expression `json:"expressions,omitempty"`
+}
+
+// DefaultConfig returns the config used when no config file is given
+func DefaultConfig() *Config {
+	return &Config{PropagationRules: DefaultPropagationRules()}
+}
+
+// FieldMapping copies one Application field to a pod label or annotation
+type FieldMapping struct {
+	Field  string `json:"field"`
+	Key    string `json:"key"`
+	Target Target `json:"target,omitempty"`
+}
+
+var applicationFields = []string{
+	consts.ApplicationFieldProject,
+	consts.ApplicationFieldRepoURL,
+	consts.ApplicationFieldPath,
+	consts.ApplicationFieldChart,
+	consts.ApplicationFieldTargetRevision,
+	consts.ApplicationFieldDestinationNamespace,
+	consts.ApplicationFieldDestinationName,
+	consts.ApplicationFieldDestinationServer,
+}
+
+// urlApplicationFields hold URLs, they default to annotations since URLs are not valid label values
+var urlApplicationFields = []string{
+	consts.ApplicationFieldRepoURL,
+	consts.ApplicationFieldDestinationServer,
+}
+
+// ParseConfig decodes and validates the enrichment config from YAML or JSON
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse enrichment config: %w", err)
+	}
+	if config.PropagationRules == nil {
+		config.PropagationRules = DefaultPropagationRules()
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid enrichment config: %w", err)
+	}
+	return config, nil
+}
+
+// LoadConfigFile reads the enrichment config from the file at path.
+// It returns the default config if path is empty.
+func LoadConfigFile(path string) (*Config, error) {
+	if path == "" {
+		return DefaultConfig(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read enrichment config file %s: %w", path, err)
+	}
+	return ParseConfig(data)
+}
+
+// Validate checks the config and fills in the defaults of its mappings and rules
+func (c *Config) Validate() error {
+	if c.Sources.Annotation != "" {
+		if errs := validateKey(c.Sources.Annotation); len(errs) > 0 {
+			return fmt.Errorf("sources: invalid annotation %q: %s", c.Sources.Annotation, strings.Join(errs, "; "))
+		}
+	}
+	if err := c.Sources.Primary.validate(); err != nil {
+		return fmt.Errorf("sources.primary: %w", err)
+	}
+	for i := range c.PropagationRules {
+		if err := c.PropagationRules[i].validate(); err != nil {
+			return fmt.Errorf("propagationRules[%d]: %w", i, err)
+		}
+	}
+	for i := range c.Expressions {
+		if err := c.Expressions[i].compile(); err != nil {
+			return fmt.Errorf("expressions[%d]: %w", i, err)
+		}
+	}
+	for i := range c.ApplicationFields {
+		mapping := &c.ApplicationFields[i]
+		if !isApplicationField(mapping.Field) {
+			return fmt.Errorf("applicationFields[%d]: unknown field %q, expected one of %s", i, mapping.Field, strings.Join(applicationFields, ", "))
+		}
+		if mapping.Target == "" {
+			mapping.Target = TargetLabel
+			if slices.Contains(urlApplicationFields, mapping.Field) {
+				mapping.Target = TargetAnnotation
+			}
+		} else if mapping.Target == TargetLabel && slices.Contains(urlApplicationFields, mapping.Field) {
+			return fmt.Errorf("applicationFields[%d]: field %s holds URLs, which are not valid label values, map it to an annotation", i, mapping.Field)
+		}
+		if errs := validateKey(mapping.Key); len(errs) > 0 {
+			return fmt.Errorf("applicationFields[%d]: invalid key %q: %s", i, mapping.Key, strings.Join(errs, "; "))
+		}
+		if !validateTarget(mapping.Target) {
+			return fmt.Errorf("applicationFields[%d]: invalid target %q, expected label or annotation", i, mapping.Target)
+		}
+	}
+	return c.validateKeys()
+}
+
+func isApplicationField(field string) bool {
+	for _, known := range applicationFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package enrichment
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplicationFieldTargets(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		wantTarget Target
+		wantErr    bool
+	}{
+		{name: "label by default", config: "applicationFields:\n  - field: project\n    key: example.com/project\n", wantTarget: TargetLabel},
+		{name: "repoURL defaults to annotation", config: "applicationFields:\n  - field: repoURL\n    key: example.com/repo-url\n", wantTarget: TargetAnnotation},
+		{name: "destinationServer defaults to annotation", config: "applicationFields:\n  - field: destinationServer\n    key: example.com/server\n", wantTarget: TargetAnnotation},
+		{name: "explicit annotation", config: "applicationFields:\n  - field: path\n    key: example.com/path\n    target: annotation\n", wantTarget: TargetAnnotation},
+		{name: "repoURL as a label", config: "applicationFields:\n  - field: repoURL\n    key: example.com/repo-url\n    target: label\n", wantErr: true},
+		{name: "destinationServer as a label", config: "applicationFields:\n  - field: destinationServer\n    key: example.com/server\n    target: label\n", wantErr: true},
+		{name: "unknown field", config: "applicationFields:\n  - field: repo\n    key: example.com/repo\n", wantErr: true},
+		{name: "invalid target", config: "applicationFields:\n  - field: project\n    key: example.com/project\n    target: env\n", wantErr: true},
+		{name: "invalid key", config: "applicationFields:\n  - field: project\n    key: not a key\n", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := ParseConfig([]byte(test.config))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("ParseConfig() = %+v, want an error", config.ApplicationFields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			if target := config.ApplicationFields[0].Target; target != test.wantTarget {
+				t.Errorf("ParseConfig() target = %q, want %q", target, test.wantTarget)
+			}
+		})
+	}
+}
+
+func TestConfigReservedKeys(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		wantReserved bool
+	}{
+		{name: "default config", config: "{}"},
+		{name: "default rule listed with other rules", config: "propagationRules:\n  - sourceKey: codefresh.io/product\n  - sourceKey: example.com/team\n"},
+		{name: "reserved application field key", config: "applicationFields:\n  - field: project\n    key: codefresh.io/argocd-project\n", wantReserved: true},
+		{name: "reserved propagation target key", config: "propagationRules:\n  - sourceKey: example.com/team\n    targetKey: codefresh.io/team\n", wantReserved: true},
+		{name: "reserved propagation source key", config: "propagationRules:\n  - sourceKey: codefresh.io/owner\n", wantReserved: true},
+		{name: "product annotation copied to an annotation", config: "propagationRules:\n  - sourceKey: codefresh.io/product\n    target: annotation\n", wantReserved: true},
+		{name: "reserved propagation prefix", config: "propagationRules:\n  - sourcePrefix: team.example.com/\n    targetPrefix: codefresh.io/team-\n", wantReserved: true},
+		{name: "propagation prefix covering the reserved keys", config: "propagationRules:\n  - sourcePrefix: codefresh.\n", wantReserved: true},
+		{name: "reserved expression key", config: "expressions:\n  - key: codefresh.io/app-id\n    expression: app.name\n", wantReserved: true},
+		{name: "subdomain of the reserved prefix", config: "applicationFields:\n  - field: project\n    key: team.codefresh.io/project\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(test.config))
+			if test.wantReserved {
+				if !errors.Is(err, ErrReservedKey) {
+					t.Fatalf("ParseConfig() error = %v, want %v", err, ErrReservedKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+		})
+	}
+}
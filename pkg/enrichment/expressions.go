@@ -0,0 +1,331 @@
+package enrichment
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	consts "argocd-pod-enrichment/pkg/consts/enrichment"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// expressionCostLimit bounds the work of one evaluation, so that an expression cannot stall the controller
+const expressionCostLimit = 100000
+
+// Expression computes the value of a pod label or annotation. Exactly one of Expression and Template is set.
+type Expression struct {
+	Key string `json:"key"`
+	// Expression is a CEL expression returning a string or an optional string,
+	// e.g. regex.extract(app.repoURL, "github.com/([^/]+)/")
+	Expression string `json:"expression,omitempty"`
+	// Template is a string whose {{ }} placeholders hold CEL expressions, e.g. "{{app.namespace}}-{{app.name}}"
+	Template string `json:"template,omitempty"`
+	Target   Target `json:"target,omitempty"`
+
+	segments   []segment
+	references map[string]bool
+}
+
+// segment is a literal part of a template, or a compiled expression if program is set
+type segment struct {
+	literal string
+	program cel.Program
+}
+
+// ExpressionPod is the pod as seen by expressions. Pods are read as metadata only: the controller cache
+// strips their spec and status, see controller.PodCacheTransform.
+type ExpressionPod struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ExpressionOwner is an owner of the pod as seen by expressions. Owners are read as metadata only.
+type ExpressionOwner struct {
+	APIVersion  string            `json:"apiVersion"`
+	Kind        string            `json:"kind"`
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ExpressionApplication is the Application as seen by expressions. The source fields are those of the
+// primary source, like the application fields of the config. The status is left out, as status updates do
+// not trigger a reconcile of the pods unless the config mirrors it.
+type ExpressionApplication struct {
+	Name                 string             `json:"name"`
+	Namespace            string             `json:"namespace"`
+	Labels               map[string]string  `json:"labels"`
+	Annotations          map[string]string  `json:"annotations"`
+	Project              string             `json:"project"`
+	RepoURL              string             `json:"repoURL"`
+	Path                 string             `json:"path"`
+	Chart                string             `json:"chart"`
+	TargetRevision       string             `json:"targetRevision"`
+	DestinationNamespace string             `json:"destinationNamespace"`
+	DestinationName      string             `json:"destinationName"`
+	DestinationServer    string             `json:"destinationServer"`
+	Sources              []ExpressionSource `json:"sources"`
+}
+
+// ExpressionSource is one source of the Application as seen by expressions
+type ExpressionSource struct {
+	RepoURL        string `json:"repoURL"`
+	Path           string `json:"path"`
+	Chart          string `json:"chart"`
+	TargetRevision string `json:"targetRevision"`
+	Ref            string `json:"ref"`
+}
+
+// ExpressionProject is the AppProject of the Application as seen by expressions
+type ExpressionProject struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ExpressionInput holds the objects expressions are evaluated over
+type ExpressionInput struct {
+	Pod *corev1.Pod
+	// Owners are the controller owners of the pod, from its direct owner to the topmost one. They are only
+	// resolved when an expression references them, see Config.References.
+	Owners []*unstructured.Unstructured
+	App    *unstructured.Unstructured
+	// Project is the AppProject of the Application. It is only read when an expression references it, and nil
+	// if it does not exist.
+	Project *unstructured.Unstructured
+}
+
+// expressionEnvironment declares the variables and functions available to expressions. It is shared by
+// every config, as compiling it is expensive.
+var expressionEnvironment = sync.OnceValues(func() (*cel.Env, error) {
+	// The native type provider does not accept more types, it comes after the libraries registering theirs
+	return cel.NewEnv(
+		cel.OptionalTypes(),
+		ext.Strings(),
+		ext.Regex(),
+		ext.NativeTypes(
+			reflect.TypeFor[ExpressionPod](),
+			reflect.TypeFor[ExpressionOwner](),
+			reflect.TypeFor[ExpressionApplication](),
+			reflect.TypeFor[ExpressionProject](),
+			ext.ParseStructTag("json"),
+		),
+		cel.Variable(consts.ExpressionVariablePod, cel.ObjectType("enrichment.ExpressionPod")),
+		cel.Variable(consts.ExpressionVariableOwners, cel.ListType(cel.ObjectType("enrichment.ExpressionOwner"))),
+		cel.Variable(consts.ExpressionVariableApplication, cel.ObjectType("enrichment.ExpressionApplication")),
+		cel.Variable(consts.ExpressionVariableProject, cel.ObjectType("enrichment.ExpressionProject")),
+	)
+})
+
+func (e *Expression) compile() error {
+	if e.Target == "" {
+		e.Target = TargetLabel
+	}
+	if errs := validateKey(e.Key); len(errs) > 0 {
+		return fmt.Errorf("invalid key %q: %s", e.Key, strings.Join(errs, "; "))
+	}
+	if !validateTarget(e.Target) {
+		return fmt.Errorf("invalid target %q, expected label or annotation", e.Target)
+	}
+	if (e.Expression == "") == (e.Template == "") {
+		return fmt.Errorf("exactly one of expression and template is required")
+	}
+
+	env, err := expressionEnvironment()
+	if err != nil {
+		return fmt.Errorf("failed to create expression environment: %w", err)
+	}
+	e.segments = nil
+	e.references = map[string]bool{}
+	if e.Expression != "" {
+		program, err := e.compileExpression(env, e.Expression)
+		if err != nil {
+			return fmt.Errorf("expression: %w", err)
+		}
+		e.segments = []segment{{program: program}}
+		return nil
+	}
+
+	rest := e.Template
+	for rest != "" {
+		literal, after, found := strings.Cut(rest, "{{")
+		if literal != "" {
+			e.segments = append(e.segments, segment{literal: literal})
+		}
+		if !found {
+			break
+		}
+		source, remainder, closed := strings.Cut(after, "}}")
+		if !closed {
+			return fmt.Errorf("template: unterminated {{ in %q", e.Template)
+		}
+		if strings.TrimSpace(source) == "" {
+			return fmt.Errorf("template: empty {{}} in %q", e.Template)
+		}
+		program, err := e.compileExpression(env, source)
+		if err != nil {
+			return fmt.Errorf("template: {{%s}}: %w", source, err)
+		}
+		e.segments = append(e.segments, segment{program: program})
+		rest = remainder
+	}
+	return nil
+}
+
+// compileExpression parses and type-checks a CEL expression and records the variables it references
+func (e *Expression) compileExpression(env *cel.Env, source string) (cel.Program, error) {
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if output := ast.OutputType(); !output.IsExactType(cel.StringType) && !output.IsExactType(cel.OptionalType(cel.StringType)) {
+		return nil, fmt.Errorf("must return a string or an optional string, not %s", output)
+	}
+	for _, reference := range ast.NativeRep().ReferenceMap() {
+		if reference.Name != "" {
+			e.references[reference.Name] = true
+		}
+	}
+	return env.Program(ast, cel.CostLimit(expressionCostLimit))
+}
+
+// evaluate returns the value of the expression. ok is false when an optional result is empty.
+func (e *Expression) evaluate(activation map[string]any) (string, bool, error) {
+	var value strings.Builder
+	for _, segment := range e.segments {
+		if segment.program == nil {
+			value.WriteString(segment.literal)
+			continue
+		}
+		out, _, err := segment.program.Eval(activation)
+		if err != nil {
+			return "", false, err
+		}
+		if optional, isOptional := out.(*types.Optional); isOptional {
+			if !optional.HasValue() {
+				return "", false, nil
+			}
+			out = optional.GetValue()
+		}
+		result, isString := out.Value().(string)
+		if !isString {
+			return "", false, fmt.Errorf("returned %s instead of a string", out.Type().TypeName())
+		}
+		value.WriteString(result)
+	}
+	return value.String(), true, nil
+}
+
+// References reports whether an expression of the config references the variable. The owners and the project
+// cost API calls to resolve and are only read when referenced.
+func (c *Config) References(variable string) bool {
+	for _, expression := range c.Expressions {
+		if expression.references[variable] {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpressionMetadata returns the pod metadata computed by the expressions of the config. Expressions returning
+// an empty string or an empty optional set nothing. It also returns the keys that were skipped because their
+// value is not a valid label value, and the errors of the expressions that failed.
+func (c *Config) ExpressionMetadata(input *ExpressionInput) (*Metadata, []string, error) {
+	metadata := NewMetadata()
+	skipped := []string{}
+	if len(c.Expressions) == 0 {
+		return metadata, skipped, nil
+	}
+
+	activation := c.expressionActivation(input)
+	errs := []error{}
+	for _, expression := range c.Expressions {
+		value, ok, err := expression.evaluate(activation)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", expression.Key, err))
+			continue
+		}
+		if !ok || value == "" {
+			continue
+		}
+		if !metadata.Set(expression.Target, expression.Key, value) {
+			skipped = append(skipped, expression.Key)
+		}
+	}
+	return metadata, skipped, errors.Join(errs...)
+}
+
+// expressionActivation converts the input to the variables of the expressions
+func (c *Config) expressionActivation(input *ExpressionInput) map[string]any {
+	pod := ExpressionPod{
+		Name:        input.Pod.Name,
+		Namespace:   input.Pod.Namespace,
+		Labels:      orEmpty(input.Pod.Labels),
+		Annotations: orEmpty(input.Pod.Annotations),
+	}
+
+	owners := make([]ExpressionOwner, 0, len(input.Owners))
+	for _, owner := range input.Owners {
+		owners = append(owners, ExpressionOwner{
+			APIVersion:  owner.GetAPIVersion(),
+			Kind:        owner.GetKind(),
+			Name:        owner.GetName(),
+			Labels:      orEmpty(owner.GetLabels()),
+			Annotations: orEmpty(owner.GetAnnotations()),
+		})
+	}
+
+	sources := ApplicationSources(input.App)
+	primary, _ := c.Sources.Primary.Select(sources)
+	app := ExpressionApplication{
+		Name:                 input.App.GetName(),
+		Namespace:            input.App.GetNamespace(),
+		Labels:               orEmpty(input.App.GetLabels()),
+		Annotations:          orEmpty(input.App.GetAnnotations()),
+		Project:              ApplicationFieldValue(input.App, consts.ApplicationFieldProject, &primary),
+		RepoURL:              primary.RepoURL,
+		Path:                 primary.Path,
+		Chart:                primary.Chart,
+		TargetRevision:       primary.TargetRevision,
+		DestinationNamespace: ApplicationFieldValue(input.App, consts.ApplicationFieldDestinationNamespace, &primary),
+		DestinationName:      ApplicationFieldValue(input.App, consts.ApplicationFieldDestinationName, &primary),
+		DestinationServer:    ApplicationFieldValue(input.App, consts.ApplicationFieldDestinationServer, &primary),
+		Sources:              make([]ExpressionSource, 0, len(sources)),
+	}
+	for _, source := range sources {
+		app.Sources = append(app.Sources, ExpressionSource(source))
+	}
+
+	project := ExpressionProject{Labels: map[string]string{}, Annotations: map[string]string{}}
+	if input.Project != nil {
+		project.Name = input.Project.GetName()
+		project.Description, _, _ = unstructured.NestedString(input.Project.Object, "spec", "description")
+		project.Labels = orEmpty(input.Project.GetLabels())
+		project.Annotations = orEmpty(input.Project.GetAnnotations())
+	}
+
+	return map[string]any{
+		consts.ExpressionVariablePod:         pod,
+		consts.ExpressionVariableOwners:      owners,
+		consts.ExpressionVariableApplication: app,
+		consts.ExpressionVariableProject:     project,
+	}
+}
+
+// orEmpty returns values, or an empty map if it is nil, so that expressions can look keys up in it
+func orEmpty(values map[string]string) map[string]string {
+	if values == nil {
+		return map[string]string{}
+	}
+	return values
+}
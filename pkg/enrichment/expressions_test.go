@@ -0,0 +1,188 @@
+package enrichment
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	consts "argocd-pod-enrichment/pkg/consts/enrichment"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func expressionTestInput() *ExpressionInput {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "shop",
+		Name:        "checkout-7d9f8-abcde",
+		Labels:      map[string]string{"app": "checkout"},
+		Annotations: map[string]string{"example.com/owner": "payments"},
+	}}
+	app := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"namespace": "argocd", "name": "checkout"},
+		"spec": map[string]interface{}{
+			"project": "payments",
+			"source": map[string]interface{}{
+				"repoURL":        "https://github.com/acme/checkout.git",
+				"path":           "deploy",
+				"targetRevision": "main",
+			},
+			"destination": map[string]interface{}{"namespace": "shop", "server": "https://kubernetes.default.svc"},
+		},
+	}}
+	owner := &unstructured.Unstructured{}
+	owner.SetAPIVersion("apps/v1")
+	owner.SetKind("Deployment")
+	owner.SetName("checkout")
+	project := &unstructured.Unstructured{}
+	project.SetName("payments")
+	project.SetAnnotations(map[string]string{"example.com/cost-center": "cc-42"})
+	return &ExpressionInput{Pod: pod, Owners: []*unstructured.Unstructured{owner}, App: app, Project: project}
+}
+
+func TestExpressionCompileErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression Expression
+		want       string
+	}{
+		{name: "syntax error", expression: Expression{Key: "team", Expression: "app.name +"}, want: "Syntax error"},
+		{name: "unknown variable", expression: Expression{Key: "team", Expression: "cluster.name"}, want: "undeclared reference"},
+		{name: "unknown field", expression: Expression{Key: "team", Expression: "pod.nodeName"}, want: "undefined field"},
+		{name: "not a string", expression: Expression{Key: "team", Expression: "owners.size()"}, want: "must return a string"},
+		{name: "optional of another type", expression: Expression{Key: "team", Expression: "optional.of(1)"}, want: "must return a string"},
+		{name: "neither expression nor template", expression: Expression{Key: "team"}, want: "exactly one of"},
+		{name: "both expression and template", expression: Expression{Key: "team", Expression: "app.name", Template: "{{app.name}}"}, want: "exactly one of"},
+		{name: "invalid key", expression: Expression{Key: "not a key", Expression: "app.name"}, want: "invalid key"},
+		{name: "invalid target", expression: Expression{Key: "team", Expression: "app.name", Target: "env"}, want: "invalid target"},
+		{name: "unterminated template", expression: Expression{Key: "team", Template: "{{app.name"}, want: "unterminated"},
+		{name: "empty template placeholder", expression: Expression{Key: "team", Template: "x-{{ }}"}, want: "empty {{}}"},
+		{name: "template placeholder not a string", expression: Expression{Key: "team", Template: "{{owners.size()}}"}, want: "must return a string"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.expression.compile()
+			if err == nil {
+				t.Fatalf("compile() succeeded, want an error containing %q", test.want)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("compile() error = %q, want it to contain %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestExpressionMetadata(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression Expression
+		want       string
+		wantUnset  bool
+		wantError  bool
+	}{
+		{name: "expression", expression: Expression{Expression: `app.project + "." + app.name`}, want: "payments.checkout"},
+		{name: "template", expression: Expression{Template: "{{app.namespace}}-{{app.name}}"}, want: "argocd-checkout"},
+		{name: "template with literals only", expression: Expression{Template: "static"}, want: "static"},
+		{name: "regex extension", expression: Expression{Expression: `regex.extract(app.repoURL, "github.com/([^/]+)/")`}, want: "acme"},
+		{name: "pod metadata", expression: Expression{Expression: `pod.labels["app"] + "." + pod.annotations["example.com/owner"]`}, want: "checkout.payments"},
+		{name: "owners", expression: Expression{Expression: `owners[0].kind + "-" + owners[0].name`}, want: "Deployment-checkout"},
+		{name: "project", expression: Expression{Expression: `project.annotations[?"example.com/cost-center"].orValue("none")`}, want: "cc-42"},
+		{name: "optional with a value", expression: Expression{Expression: `pod.labels[?"app"]`}, want: "checkout"},
+		{name: "empty optional sets nothing", expression: Expression{Expression: `pod.labels[?"team"]`}, wantUnset: true},
+		{name: "empty optional in a template sets nothing", expression: Expression{Template: "team-{{pod.labels[?\"team\"]}}"}, wantUnset: true},
+		{name: "empty string sets nothing", expression: Expression{Expression: `""`}, wantUnset: true},
+		{name: "missing map key fails", expression: Expression{Expression: `pod.labels["team"]`}, wantError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.expression.Key = "example.com/value"
+			config := &Config{Expressions: []Expression{test.expression}}
+			if err := config.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			metadata, skipped, err := config.ExpressionMetadata(expressionTestInput())
+			if test.wantError {
+				if err == nil {
+					t.Fatalf("ExpressionMetadata() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpressionMetadata() error = %v", err)
+			}
+			if len(skipped) != 0 {
+				t.Errorf("skipped = %v, want none", skipped)
+			}
+			value, set := metadata.Labels["example.com/value"]
+			if test.wantUnset {
+				if set {
+					t.Errorf("label set to %q, want it unset", value)
+				}
+				return
+			}
+			if value != test.want {
+				t.Errorf("label = %q, want %q", value, test.want)
+			}
+		})
+	}
+}
+
+func TestExpressionMetadataSkipsInvalidLabelValues(t *testing.T) {
+	config := &Config{Expressions: []Expression{
+		{Key: "example.com/repo", Expression: "app.repoURL"},
+		{Key: "example.com/repo-annotation", Expression: "app.repoURL", Target: TargetAnnotation},
+	}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	metadata, skipped, err := config.ExpressionMetadata(expressionTestInput())
+	if err != nil {
+		t.Fatalf("ExpressionMetadata() error = %v", err)
+	}
+	if len(skipped) != 1 || skipped[0] != "example.com/repo" {
+		t.Errorf("skipped = %v, want [example.com/repo]", skipped)
+	}
+	if got := metadata.Annotations["example.com/repo-annotation"]; got != "https://github.com/acme/checkout.git" {
+		t.Errorf("annotation = %q, want the repo URL", got)
+	}
+}
+
+func TestExpressionCostLimit(t *testing.T) {
+	config := &Config{Expressions: []Expression{{
+		Key:        "example.com/value",
+		Expression: `pod.labels.all(a, pod.labels.all(b, a != b || a == b)) ? "done" : ""`,
+	}}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	input := expressionTestInput()
+	for i := 0; i < 1000; i++ {
+		input.Pod.Labels[fmt.Sprintf("label-%d", i)] = "value"
+	}
+
+	_, _, err := config.ExpressionMetadata(input)
+	if err == nil || !strings.Contains(err.Error(), "cost limit") {
+		t.Fatalf("ExpressionMetadata() error = %v, want the cost limit to be exceeded", err)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	config := &Config{Expressions: []Expression{
+		{Key: "example.com/app", Expression: "app.name"},
+		{Key: "example.com/owner", Template: "{{owners.size() > 0 ? owners[0].name : \"\"}}"},
+	}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	for variable, want := range map[string]bool{
+		consts.ExpressionVariableApplication: true,
+		consts.ExpressionVariableOwners:      true,
+		consts.ExpressionVariableProject:     false,
+		consts.ExpressionVariablePod:         false,
+	} {
+		if got := config.References(variable); got != want {
+			t.Errorf("References(%q) = %t, want %t", variable, got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package enrichment
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// ManagedKeys are the pod labels and annotations written by the controller on its last reconcile
+type ManagedKeys struct {
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// ParseManagedKeys decodes the managed keys annotation, an empty or invalid value means no managed keys
+func ParseManagedKeys(value string) ManagedKeys {
+	managed := ManagedKeys{}
+	if value != "" {
+		_ = json.Unmarshal([]byte(value), &managed)
+	}
+	return managed
+}
+
+// Encode returns the value of the managed keys annotation
+func (k ManagedKeys) Encode() string {
+	encoded, _ := json.Marshal(k)
+	return string(encoded)
+}
+
+// Merge copies the labels and annotations of other into m, overriding existing keys
+func (m *Metadata) Merge(other *Metadata) {
+	for key, value := range other.Labels {
+		m.Labels[key] = value
+	}
+	for key, value := range other.Annotations {
+		m.Annotations[key] = value
+	}
+}
+
+// Keys returns the sorted keys of the metadata
+func (m *Metadata) Keys() ManagedKeys {
+	return ManagedKeys{Labels: sortedKeys(m.Labels), Annotations: sortedKeys(m.Annotations)}
+}
+
+// Stale returns the managed keys that are not part of desired anymore
+func (k ManagedKeys) Stale(desired *Metadata) ManagedKeys {
+	stale := ManagedKeys{}
+	for _, key := range k.Labels {
+		if _, ok := desired.Labels[key]; !ok {
+			stale.Labels = append(stale.Labels, key)
+		}
+	}
+	for _, key := range k.Annotations {
+		if _, ok := desired.Annotations[key]; !ok {
+			stale.Annotations = append(stale.Annotations, key)
+		}
+	}
+	return stale
+}
+
+// Unmanaged returns a copy of the labels and annotations without the managed keys,
+// i.e. the values set by someone else than the controller
+func (k ManagedKeys) Unmanaged(labels, annotations map[string]string) *Metadata {
+	unmanaged := NewMetadata()
+	for key, value := range labels {
+		unmanaged.Labels[key] = value
+	}
+	for key, value := range annotations {
+		unmanaged.Annotations[key] = value
+	}
+	for _, key := range k.Labels {
+		delete(unmanaged.Labels, key)
+	}
+	for _, key := range k.Annotations {
+		delete(unmanaged.Annotations, key)
+	}
+	return unmanaged
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,131 @@
+package enrichment
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestManagedKeysStale(t *testing.T) {
+	tests := []struct {
+		name    string
+		managed ManagedKeys
+		desired *Metadata
+		want    ManagedKeys
+	}{
+		{
+			name:    "nothing managed",
+			desired: &Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{}},
+			want:    ManagedKeys{},
+		},
+		{
+			name:    "every managed key is still desired",
+			managed: ManagedKeys{Labels: []string{"example.com/team"}, Annotations: []string{"example.com/runbook"}},
+			desired: &Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{"example.com/runbook": "https://runbooks.example.com"}},
+			want:    ManagedKeys{},
+		},
+		{
+			name:    "keys no longer desired are stale",
+			managed: ManagedKeys{Labels: []string{"example.com/team", "example.com/tier"}, Annotations: []string{"example.com/runbook"}},
+			desired: &Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{}},
+			want:    ManagedKeys{Labels: []string{"example.com/tier"}, Annotations: []string{"example.com/runbook"}},
+		},
+		{
+			name:    "a key moved from labels to annotations is a stale label",
+			managed: ManagedKeys{Labels: []string{"example.com/team"}},
+			desired: &Metadata{Labels: map[string]string{}, Annotations: map[string]string{"example.com/team": "payments"}},
+			want:    ManagedKeys{Labels: []string{"example.com/team"}},
+		},
+		{
+			name:    "nothing desired",
+			managed: ManagedKeys{Labels: []string{"example.com/team"}, Annotations: []string{"example.com/runbook"}},
+			desired: NewMetadata(),
+			want:    ManagedKeys{Labels: []string{"example.com/team"}, Annotations: []string{"example.com/runbook"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.managed.Stale(test.desired); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Stale() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMetadataMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		into  *Metadata
+		other *Metadata
+		want  *Metadata
+	}{
+		{
+			name:  "merge into empty metadata",
+			into:  NewMetadata(),
+			other: &Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{"example.com/runbook": "https://runbooks.example.com"}},
+			want:  &Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{"example.com/runbook": "https://runbooks.example.com"}},
+		},
+		{
+			name:  "merge empty metadata",
+			into:  &Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{}},
+			other: NewMetadata(),
+			want:  &Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{}},
+		},
+		{
+			name:  "other overrides existing keys",
+			into:  &Metadata{Labels: map[string]string{"example.com/team": "platform", "example.com/tier": "frontend"}, Annotations: map[string]string{"example.com/team": "platform"}},
+			other: &Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{}},
+			want:  &Metadata{Labels: map[string]string{"example.com/team": "payments", "example.com/tier": "frontend"}, Annotations: map[string]string{"example.com/team": "platform"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.into.Merge(test.other)
+			if !reflect.DeepEqual(test.into, test.want) {
+				t.Errorf("Merge() = %+v, want %+v", test.into, test.want)
+			}
+		})
+	}
+}
+
+func TestManagedKeysEncoding(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  ManagedKeys
+	}{
+		{name: "empty", value: "", want: ManagedKeys{}},
+		{name: "invalid", value: "{labels", want: ManagedKeys{}},
+		{name: "labels and annotations", value: `{"labels":["example.com/team"],"annotations":["example.com/runbook"]}`, want: ManagedKeys{Labels: []string{"example.com/team"}, Annotations: []string{"example.com/runbook"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ParseManagedKeys(test.value)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseManagedKeys() = %+v, want %+v", got, test.want)
+			}
+			if roundTrip := ParseManagedKeys(got.Encode()); !reflect.DeepEqual(roundTrip, got) {
+				t.Errorf("ParseManagedKeys(Encode()) = %+v, want %+v", roundTrip, got)
+			}
+		})
+	}
+
+	metadata := &Metadata{Labels: map[string]string{"b": "2", "a": "1"}, Annotations: map[string]string{"c": "3"}}
+	if got, want := metadata.Keys(), (ManagedKeys{Labels: []string{"a", "b"}, Annotations: []string{"c"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %+v, want %+v", got, want)
+	}
+}
+
+func TestManagedKeysUnmanaged(t *testing.T) {
+	managed := ManagedKeys{Labels: []string{"example.com/team"}, Annotations: []string{"example.com/runbook"}}
+	labels := map[string]string{"example.com/team": "payments", "app": "checkout"}
+	annotations := map[string]string{"example.com/runbook": "https://runbooks.example.com", "example.com/owner": "payments"}
+
+	got := managed.Unmanaged(labels, annotations)
+	want := &Metadata{Labels: map[string]string{"app": "checkout"}, Annotations: map[string]string{"example.com/owner": "payments"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmanaged() = %+v, want %+v", got, want)
+	}
+	if len(labels) != 2 || len(annotations) != 2 {
+		t.Errorf("Unmanaged() modified the labels or annotations of the pod")
+	}
+}
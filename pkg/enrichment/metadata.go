@@ -0,0 +1,55 @@
+package enrichment
+
+import (
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Target is the kind of pod metadata a value is written to
+type Target string
+
+const (
+	TargetLabel      Target = "label"
+	TargetAnnotation Target = "annotation"
+)
+
+// Metadata holds the labels and annotations the controller wants on a pod
+type Metadata struct {
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func NewMetadata() *Metadata {
+	return &Metadata{Labels: map[string]string{}, Annotations: map[string]string{}}
+}
+
+// Set stores a value for key in the given target. Values that are not valid label values are
+// rejected for labels and false is returned.
+func (m *Metadata) Set(target Target, key, value string) bool {
+	if target == TargetAnnotation {
+		m.Annotations[key] = value
+		return true
+	}
+	if len(validation.IsValidLabelValue(value)) != 0 {
+		return false
+	}
+	m.Labels[key] = value
+	return true
+}
+
+func validateTarget(target Target) bool {
+	return target == TargetLabel || target == TargetAnnotation
+}
+
+func validateKey(key string) []string {
+	return validation.IsQualifiedName(key)
+}
+
+// Has reports whether key is set in the given target
+func (m *Metadata) Has(target Target, key string) bool {
+	if target == TargetAnnotation {
+		_, ok := m.Annotations[key]
+		return ok
+	}
+	_, ok := m.Labels[key]
+	return ok
+}
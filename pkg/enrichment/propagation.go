@@ -0,0 +1,181 @@
+package enrichment
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// OverwritePolicy decides what happens when the pod already has the target key
+type OverwritePolicy string
+
+const (
+	// OverwriteAlways replaces the value on the pod
+	OverwriteAlways OverwritePolicy = "always"
+	// OverwriteIfAbsent only sets keys the pod does not have yet
+	OverwriteIfAbsent OverwritePolicy = "ifAbsent"
+)
+
+// PropagationRule copies Application labels or annotations to pod labels or annotations.
+// Exactly one of SourceKey, SourcePrefix and SourceRegex selects the Application keys.
+type PropagationRule struct {
+	// SourceType is label or annotation, the kind of Application metadata to read
+	SourceType Target `json:"sourceType,omitempty"`
+	// SourceKey matches a single key
+	SourceKey string `json:"sourceKey,omitempty"`
+	// SourcePrefix matches every key with the prefix
+	SourcePrefix string `json:"sourcePrefix,omitempty"`
+	// SourceRegex matches every key matching the regular expression
+	SourceRegex string `json:"sourceRegex,omitempty"`
+
+	// TargetKey is the pod key for SourceKey rules, and a template expanded with the submatches of SourceRegex
+	// (e.g. team.example.com/$1) for regex rules. Defaults to the source key.
+	TargetKey string `json:"targetKey,omitempty"`
+	// TargetPrefix replaces SourcePrefix in the pod key for prefix rules. Defaults to SourcePrefix.
+	TargetPrefix string `json:"targetPrefix,omitempty"`
+	// Target is label or annotation, the kind of pod metadata to write
+	Target Target `json:"target,omitempty"`
+
+	Overwrite OverwritePolicy `json:"overwrite,omitempty"`
+	// Default is used when the Application does not have SourceKey
+	Default *string `json:"default,omitempty"`
+
+	sourceRegexp *regexp.Regexp
+}
+
+// ProductKey is the Application annotation propagated to pods when no rules are configured
+const ProductKey = "codefresh.io/product"
+
+// DefaultPropagationRules keep the historic behaviour of copying the product annotation to a pod label
+func DefaultPropagationRules() []PropagationRule {
+	return []PropagationRule{{
+		SourceType: TargetAnnotation,
+		SourceKey:  ProductKey,
+		TargetKey:  ProductKey,
+		Target:     TargetLabel,
+		Overwrite:  OverwriteAlways,
+	}}
+}
+
+func (r *PropagationRule) validate() error {
+	selectors := 0
+	for _, selector := range []string{r.SourceKey, r.SourcePrefix, r.SourceRegex} {
+		if selector != "" {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return fmt.Errorf("exactly one of sourceKey, sourcePrefix and sourceRegex is required")
+	}
+
+	if r.SourceType == "" {
+		r.SourceType = TargetAnnotation
+	}
+	if r.Target == "" {
+		r.Target = TargetLabel
+	}
+	if r.Overwrite == "" {
+		r.Overwrite = OverwriteAlways
+	}
+	if !validateTarget(r.SourceType) {
+		return fmt.Errorf("invalid sourceType %q, expected label or annotation", r.SourceType)
+	}
+	if !validateTarget(r.Target) {
+		return fmt.Errorf("invalid target %q, expected label or annotation", r.Target)
+	}
+	if r.Overwrite != OverwriteAlways && r.Overwrite != OverwriteIfAbsent {
+		return fmt.Errorf("invalid overwrite %q, expected always or ifAbsent", r.Overwrite)
+	}
+	if r.Default != nil && r.SourceKey == "" {
+		return fmt.Errorf("default is only supported with sourceKey")
+	}
+
+	switch {
+	case r.SourceKey != "":
+		if r.TargetKey == "" {
+			r.TargetKey = r.SourceKey
+		}
+		if errs := validateKey(r.TargetKey); len(errs) > 0 {
+			return fmt.Errorf("invalid targetKey %q: %s", r.TargetKey, strings.Join(errs, "; "))
+		}
+	case r.SourcePrefix != "":
+		if r.TargetPrefix == "" {
+			r.TargetPrefix = r.SourcePrefix
+		}
+	case r.SourceRegex != "":
+		compiled, err := regexp.Compile(r.SourceRegex)
+		if err != nil {
+			return fmt.Errorf("invalid sourceRegex: %w", err)
+		}
+		r.sourceRegexp = compiled
+	}
+	return nil
+}
+
+// matches returns the pod keys and values the rule produces from the Application metadata
+func (r *PropagationRule) matches(source map[string]string) map[string]string {
+	result := map[string]string{}
+	switch {
+	case r.SourceKey != "":
+		if value, ok := source[r.SourceKey]; ok {
+			result[r.TargetKey] = value
+		} else if r.Default != nil {
+			result[r.TargetKey] = *r.Default
+		}
+	case r.SourcePrefix != "":
+		for key, value := range source {
+			if strings.HasPrefix(key, r.SourcePrefix) {
+				result[r.TargetPrefix+strings.TrimPrefix(key, r.SourcePrefix)] = value
+			}
+		}
+	case r.sourceRegexp != nil:
+		for key, value := range source {
+			if submatches := r.sourceRegexp.FindStringSubmatchIndex(key); submatches != nil {
+				// Without a template, the whole source key is kept, not only the part matching the regex
+				targetKey := key
+				if r.TargetKey != "" {
+					targetKey = string(r.sourceRegexp.ExpandString(nil, r.TargetKey, key, submatches))
+				}
+				result[targetKey] = value
+			}
+		}
+	}
+	return result
+}
+
+// PropagationMetadata applies the propagation rules to the Application. existing holds the current
+// labels and annotations of the pod, for the ifAbsent overwrite policy. It also returns the keys that
+// were skipped because the key or the value is not valid for the target, or the key is reserved.
+func (c *Config) PropagationMetadata(app *unstructured.Unstructured, existing *Metadata) (*Metadata, []string) {
+	metadata := NewMetadata()
+	skipped := []string{}
+
+	for i := range c.PropagationRules {
+		rule := &c.PropagationRules[i]
+		source := app.GetAnnotations()
+		if rule.SourceType == TargetLabel {
+			source = app.GetLabels()
+		}
+
+		values := rule.matches(source)
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if rule.Overwrite == OverwriteIfAbsent && existing.Has(rule.Target, key) {
+				continue
+			}
+			if (isReserved(key) && !rule.isDefault()) || len(validateKey(key)) > 0 || !metadata.Set(rule.Target, key, values[key]) {
+				skipped = append(skipped, key)
+			}
+		}
+	}
+
+	return metadata, skipped
+}
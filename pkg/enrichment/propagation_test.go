@@ -0,0 +1,182 @@
+package enrichment
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPropagationRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr bool
+	}{
+		{name: "source key", rules: "- sourceKey: example.com/team\n"},
+		{name: "source prefix", rules: "- sourcePrefix: example.com/\n  targetPrefix: team.example.com/\n"},
+		{name: "source regex", rules: "- sourceRegex: ^example\\.com/(.*)$\n  targetKey: team.example.com/$1\n"},
+		{name: "default with source key", rules: "- sourceKey: example.com/team\n  default: none\n"},
+		{name: "no source selector", rules: "- targetKey: example.com/team\n", wantErr: true},
+		{name: "several source selectors", rules: "- sourceKey: example.com/team\n  sourcePrefix: example.com/\n", wantErr: true},
+		{name: "invalid source type", rules: "- sourceKey: example.com/team\n  sourceType: spec\n", wantErr: true},
+		{name: "invalid target", rules: "- sourceKey: example.com/team\n  target: env\n", wantErr: true},
+		{name: "invalid overwrite", rules: "- sourceKey: example.com/team\n  overwrite: never\n", wantErr: true},
+		{name: "default without source key", rules: "- sourcePrefix: example.com/\n  default: none\n", wantErr: true},
+		{name: "invalid target key", rules: "- sourceKey: example.com/team\n  targetKey: not a key\n", wantErr: true},
+		{name: "invalid source key used as target key", rules: "- sourceKey: not a key\n", wantErr: true},
+		{name: "invalid source regex", rules: "- sourceRegex: (\n", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte("propagationRules:\n" + indent(test.rules)))
+			if (err != nil) != test.wantErr {
+				t.Errorf("ParseConfig() error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPropagationMetadata(t *testing.T) {
+	app := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	app.SetLabels(map[string]string{
+		"example.com/tier":          "frontend",
+		"app.kubernetes.io/part-of": "shop",
+	})
+	app.SetAnnotations(map[string]string{
+		ProductKey:              "checkout",
+		"example.com/team":      "payments",
+		"example.com/on-call":   "payments-oncall",
+		"example.com/runbook":   "https://runbooks.example.com/checkout",
+		"other.example.com/key": "other",
+	})
+
+	tests := []struct {
+		name        string
+		rules       string
+		existing    *Metadata
+		want        *Metadata
+		wantSkipped []string
+	}{
+		{
+			name:  "default rules copy the product annotation to a label",
+			rules: "",
+			want:  &Metadata{Labels: map[string]string{ProductKey: "checkout"}, Annotations: map[string]string{}},
+		},
+		{
+			name:  "source key to an annotation with another key",
+			rules: "- sourceKey: example.com/team\n  targetKey: team.example.com/name\n  target: annotation\n",
+			want:  &Metadata{Labels: map[string]string{}, Annotations: map[string]string{"team.example.com/name": "payments"}},
+		},
+		{
+			name:  "source key from the Application labels",
+			rules: "- sourceKey: example.com/tier\n  sourceType: label\n",
+			want:  &Metadata{Labels: map[string]string{"example.com/tier": "frontend"}, Annotations: map[string]string{}},
+		},
+		{
+			name:  "missing source key",
+			rules: "- sourceKey: example.com/cost-center\n",
+			want:  NewMetadata(),
+		},
+		{
+			name:  "missing source key with a default",
+			rules: "- sourceKey: example.com/cost-center\n  default: unassigned\n",
+			want:  &Metadata{Labels: map[string]string{"example.com/cost-center": "unassigned"}, Annotations: map[string]string{}},
+		},
+		{
+			name:  "source prefix with a target prefix",
+			rules: "- sourcePrefix: example.com/\n  targetPrefix: team.example.com/\n  target: annotation\n",
+			want: &Metadata{Labels: map[string]string{}, Annotations: map[string]string{
+				"team.example.com/team":    "payments",
+				"team.example.com/on-call": "payments-oncall",
+				"team.example.com/runbook": "https://runbooks.example.com/checkout",
+			}},
+		},
+		{
+			name:  "source regex with submatches",
+			rules: "- sourceRegex: ^example\\.com/(team|on-call)$\n  targetKey: team.example.com/$1\n",
+			want:  &Metadata{Labels: map[string]string{"team.example.com/team": "payments", "team.example.com/on-call": "payments-oncall"}, Annotations: map[string]string{}},
+		},
+		{
+			name:  "source regex without target key keeps the source key",
+			rules: "- sourceRegex: ^other\\.\n",
+			want:  &Metadata{Labels: map[string]string{"other.example.com/key": "other"}, Annotations: map[string]string{}},
+		},
+		{
+			name:        "values invalid for labels are skipped",
+			rules:       "- sourcePrefix: example.com/\n",
+			want:        &Metadata{Labels: map[string]string{"example.com/team": "payments", "example.com/on-call": "payments-oncall"}, Annotations: map[string]string{}},
+			wantSkipped: []string{"example.com/runbook"},
+		},
+		{
+			name:        "reserved keys produced by a regex are skipped",
+			rules:       "- sourceRegex: ^example\\.com/(team)$\n  targetKey: codefresh.io/$1\n",
+			want:        NewMetadata(),
+			wantSkipped: []string{"codefresh.io/team"},
+		},
+		{
+			name:        "invalid keys produced by a regex are skipped",
+			rules:       "- sourceRegex: ^example\\.com/(team)$\n  targetKey: not a key $1\n  target: annotation\n",
+			want:        NewMetadata(),
+			wantSkipped: []string{"not a key team"},
+		},
+		{
+			name:     "ifAbsent keeps the keys of the pod",
+			rules:    "- sourcePrefix: example.com/\n  target: annotation\n  overwrite: ifAbsent\n",
+			existing: &Metadata{Labels: map[string]string{"example.com/on-call": "label"}, Annotations: map[string]string{"example.com/team": "platform"}},
+			want: &Metadata{Labels: map[string]string{}, Annotations: map[string]string{
+				"example.com/on-call": "payments-oncall",
+				"example.com/runbook": "https://runbooks.example.com/checkout",
+			}},
+		},
+		{
+			name:     "always overwrites the keys of the pod",
+			rules:    "- sourceKey: example.com/team\n",
+			existing: &Metadata{Labels: map[string]string{"example.com/team": "platform"}, Annotations: map[string]string{}},
+			want:     &Metadata{Labels: map[string]string{"example.com/team": "payments"}, Annotations: map[string]string{}},
+		},
+		{
+			name:  "later rules override earlier ones",
+			rules: "- sourceKey: example.com/team\n  targetKey: example.com/owner\n- sourceKey: example.com/on-call\n  targetKey: example.com/owner\n",
+			want:  &Metadata{Labels: map[string]string{"example.com/owner": "payments-oncall"}, Annotations: map[string]string{}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := "{}"
+			if test.rules != "" {
+				data = "propagationRules:\n" + indent(test.rules)
+			}
+			config, err := ParseConfig([]byte(data))
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			existing := test.existing
+			if existing == nil {
+				existing = NewMetadata()
+			}
+
+			got, skipped := config.PropagationMetadata(app, existing)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("PropagationMetadata() = %+v, want %+v", got, test.want)
+			}
+			if len(skipped) > 0 || len(test.wantSkipped) > 0 {
+				if !reflect.DeepEqual(skipped, test.wantSkipped) {
+					t.Errorf("PropagationMetadata() skipped %v, want %v", skipped, test.wantSkipped)
+				}
+			}
+		})
+	}
+}
+
+// indent indents YAML lines by two spaces, to nest them under a key
+func indent(yaml string) string {
+	indented := ""
+	for _, line := range strings.SplitAfter(yaml, "\n") {
+		if line != "" {
+			indented += "  " + line
+		}
+	}
+	return indented
+}
@@ -0,0 +1,50 @@
+package enrichment
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	consts "argocd-pod-enrichment/pkg/consts/enrichment"
+)
+
+// ErrReservedKey is returned for configs setting a key with consts.ReservedKeyPrefix
+var ErrReservedKey = errors.New("reserved key")
+
+// validateKeys rejects the keys reserved for the webhook and the controller, except the product label of the
+// default propagation rule. The keys of regex propagation rules are only known once expanded,
+// PropagationMetadata skips the reserved ones.
+func (c *Config) validateKeys() error {
+	for i, mapping := range c.ApplicationFields {
+		if isReserved(mapping.Key) {
+			return fmt.Errorf("applicationFields[%d]: %w %q, keys with the prefix %s are set by the controller", i, ErrReservedKey, mapping.Key, consts.ReservedKeyPrefix)
+		}
+	}
+	for i := range c.PropagationRules {
+		rule := &c.PropagationRules[i]
+		if rule.isDefault() {
+			continue
+		}
+		if rule.SourceKey != "" && isReserved(rule.TargetKey) {
+			return fmt.Errorf("propagationRules[%d]: %w %q, keys with the prefix %s are set by the controller", i, ErrReservedKey, rule.TargetKey, consts.ReservedKeyPrefix)
+		}
+		if rule.SourcePrefix != "" && (isReserved(rule.TargetPrefix) || strings.HasPrefix(consts.ReservedKeyPrefix, rule.TargetPrefix)) {
+			return fmt.Errorf("propagationRules[%d]: %w prefix %q, keys with the prefix %s are set by the controller", i, ErrReservedKey, rule.TargetPrefix, consts.ReservedKeyPrefix)
+		}
+	}
+	for i, expression := range c.Expressions {
+		if isReserved(expression.Key) {
+			return fmt.Errorf("expressions[%d]: %w %q, keys with the prefix %s are set by the controller", i, ErrReservedKey, expression.Key, consts.ReservedKeyPrefix)
+		}
+	}
+	return nil
+}
+
+// isDefault reports whether the rule is the one of DefaultPropagationRules, copying the product annotation
+func (r *PropagationRule) isDefault() bool {
+	return r.SourceType == TargetAnnotation && r.SourceKey == ProductKey && r.TargetKey == ProductKey && r.Target == TargetLabel
+}
+
+func isReserved(key string) bool {
+	return strings.HasPrefix(key, consts.ReservedKeyPrefix)
+}
@@ -0,0 +1,110 @@
+package enrichment
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Source is the compact form of one Application source
+type Source struct {
+	RepoURL        string `json:"repo"`
+	Path           string `json:"path,omitempty"`
+	Chart          string `json:"chart,omitempty"`
+	TargetRevision string `json:"revision,omitempty"`
+	Ref            string `json:"ref,omitempty"`
+}
+
+// SourcesConfig controls how multi-source Applications are represented on pods
+type SourcesConfig struct {
+	// Annotation, if set, receives a JSON list of every source of the Application
+	Annotation string `json:"annotation,omitempty"`
+	// Primary selects the source used for the repoURL, path, chart and targetRevision application fields
+	Primary PrimarySourceRule `json:"primary,omitempty"`
+}
+
+// PrimarySourceRule picks the primary source of an Application. Index takes precedence over RepoURLPattern.
+// Without either, the first source that is not a ref-only source (a source with a ref and no path or chart) is used.
+type PrimarySourceRule struct {
+	Index          *int   `json:"index,omitempty"`
+	RepoURLPattern string `json:"repoURLPattern,omitempty"`
+
+	repoURLRegexp *regexp.Regexp
+}
+
+// ApplicationSources returns the sources of an Application in spec order,
+// for both single source (spec.source) and multi-source (spec.sources) Applications
+func ApplicationSources(app *unstructured.Unstructured) []Source {
+	if sources, found, _ := unstructured.NestedSlice(app.Object, "spec", "sources"); found && len(sources) > 0 {
+		result := make([]Source, 0, len(sources))
+		for _, source := range sources {
+			if sourceMap, ok := source.(map[string]interface{}); ok {
+				result = append(result, sourceFromMap(sourceMap))
+			}
+		}
+		return result
+	}
+	if source, found, _ := unstructured.NestedMap(app.Object, "spec", "source"); found {
+		return []Source{sourceFromMap(source)}
+	}
+	return nil
+}
+
+func sourceFromMap(source map[string]interface{}) Source {
+	s := Source{}
+	s.RepoURL, _, _ = unstructured.NestedString(source, "repoURL")
+	s.Path, _, _ = unstructured.NestedString(source, "path")
+	s.Chart, _, _ = unstructured.NestedString(source, "chart")
+	s.TargetRevision, _, _ = unstructured.NestedString(source, "targetRevision")
+	s.Ref, _, _ = unstructured.NestedString(source, "ref")
+	return s
+}
+
+// EncodeSources returns a compact, deterministic JSON form of the sources
+func EncodeSources(sources []Source) string {
+	encoded, _ := json.Marshal(sources)
+	return string(encoded)
+}
+
+// Select returns the primary source, or false if there is none
+func (r *PrimarySourceRule) Select(sources []Source) (Source, bool) {
+	if len(sources) == 0 {
+		return Source{}, false
+	}
+	if r.Index != nil {
+		if *r.Index < len(sources) {
+			return sources[*r.Index], true
+		}
+		return Source{}, false
+	}
+	if r.repoURLRegexp != nil {
+		for _, source := range sources {
+			if r.repoURLRegexp.MatchString(source.RepoURL) {
+				return source, true
+			}
+		}
+		return Source{}, false
+	}
+	for _, source := range sources {
+		if source.Ref == "" || source.Path != "" || source.Chart != "" {
+			return source, true
+		}
+	}
+	return sources[0], true
+}
+
+func (r *PrimarySourceRule) validate() error {
+	if r.Index != nil && *r.Index < 0 {
+		return fmt.Errorf("index must not be negative")
+	}
+	if r.RepoURLPattern != "" {
+		compiled, err := regexp.Compile(r.RepoURLPattern)
+		if err != nil {
+			return fmt.Errorf("invalid repoURLPattern: %w", err)
+		}
+		r.repoURLRegexp = compiled
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package enrichment
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+)
+
+func TestPrimarySourceRule(t *testing.T) {
+	values := Source{RepoURL: "https://github.com/acme/values.git", TargetRevision: "main", Ref: "values"}
+	app := Source{RepoURL: "https://github.com/acme/checkout.git", Path: "deploy", TargetRevision: "main"}
+	chart := Source{RepoURL: "https://charts.acme.com", Chart: "checkout", TargetRevision: "1.2.0", Ref: "chart"}
+
+	tests := []struct {
+		name      string
+		rule      PrimarySourceRule
+		sources   []Source
+		want      Source
+		wantFound bool
+		wantErr   bool
+	}{
+		{
+			name: "no sources",
+			rule: PrimarySourceRule{},
+		},
+		{
+			name:      "single source",
+			sources:   []Source{app},
+			want:      app,
+			wantFound: true,
+		},
+		{
+			name:      "first source which is not ref-only",
+			sources:   []Source{values, app, chart},
+			want:      app,
+			wantFound: true,
+		},
+		{
+			name:      "source with a ref and a chart is not ref-only",
+			sources:   []Source{values, chart, app},
+			want:      chart,
+			wantFound: true,
+		},
+		{
+			name:      "only ref-only sources",
+			sources:   []Source{values, {RepoURL: "https://github.com/acme/other.git", Ref: "other"}},
+			want:      values,
+			wantFound: true,
+		},
+		{
+			name:      "index",
+			rule:      PrimarySourceRule{Index: ptr.To(2)},
+			sources:   []Source{values, app, chart},
+			want:      chart,
+			wantFound: true,
+		},
+		{
+			name:      "index of a ref-only source",
+			rule:      PrimarySourceRule{Index: ptr.To(0)},
+			sources:   []Source{values, app},
+			want:      values,
+			wantFound: true,
+		},
+		{
+			name:    "index out of range",
+			rule:    PrimarySourceRule{Index: ptr.To(3)},
+			sources: []Source{values, app, chart},
+		},
+		{
+			name:    "negative index",
+			rule:    PrimarySourceRule{Index: ptr.To(-1)},
+			wantErr: true,
+		},
+		{
+			name:      "repoURL pattern",
+			rule:      PrimarySourceRule{RepoURLPattern: `charts\.acme\.com`},
+			sources:   []Source{values, app, chart},
+			want:      chart,
+			wantFound: true,
+		},
+		{
+			name:      "first source matching the repoURL pattern",
+			rule:      PrimarySourceRule{RepoURLPattern: `github\.com/acme/`},
+			sources:   []Source{values, app},
+			want:      values,
+			wantFound: true,
+		},
+		{
+			name:    "no source matches the repoURL pattern",
+			rule:    PrimarySourceRule{RepoURLPattern: `gitlab\.com`},
+			sources: []Source{values, app},
+		},
+		{
+			name:      "index takes precedence over the repoURL pattern",
+			rule:      PrimarySourceRule{Index: ptr.To(1), RepoURLPattern: `charts\.acme\.com`},
+			sources:   []Source{values, app, chart},
+			want:      app,
+			wantFound: true,
+		},
+		{
+			name:    "invalid repoURL pattern",
+			rule:    PrimarySourceRule{RepoURLPattern: `(`},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.rule.validate()
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("validate() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			got, found := test.rule.Select(test.sources)
+			if got != test.want || found != test.wantFound {
+				t.Errorf("Select() = %+v, %v, want %+v, %v", got, found, test.want, test.wantFound)
+			}
+		})
+	}
+}
+
+func TestApplicationSources(t *testing.T) {
+	tests := []struct {
+		name string
+		spec map[string]interface{}
+		want []Source
+	}{
+		{
+			name: "single source",
+			spec: map[string]interface{}{"source": map[string]interface{}{"repoURL": "https://github.com/acme/checkout.git", "path": "deploy", "targetRevision": "main"}},
+			want: []Source{{RepoURL: "https://github.com/acme/checkout.git", Path: "deploy", TargetRevision: "main"}},
+		},
+		{
+			name: "multiple sources in spec order",
+			spec: map[string]interface{}{"sources": []interface{}{
+				map[string]interface{}{"repoURL": "https://github.com/acme/values.git", "ref": "values"},
+				map[string]interface{}{"repoURL": "https://charts.acme.com", "chart": "checkout", "targetRevision": "1.2.0"},
+			}},
+			want: []Source{{RepoURL: "https://github.com/acme/values.git", Ref: "values"}, {RepoURL: "https://charts.acme.com", Chart: "checkout", TargetRevision: "1.2.0"}},
+		},
+		{
+			name: "sources take precedence over source",
+			spec: map[string]interface{}{
+				"source":  map[string]interface{}{"repoURL": "https://github.com/acme/old.git"},
+				"sources": []interface{}{map[string]interface{}{"repoURL": "https://github.com/acme/new.git"}},
+			},
+			want: []Source{{RepoURL: "https://github.com/acme/new.git"}},
+		},
+		{
+			name: "empty sources fall back to source",
+			spec: map[string]interface{}{
+				"source":  map[string]interface{}{"repoURL": "https://github.com/acme/checkout.git"},
+				"sources": []interface{}{},
+			},
+			want: []Source{{RepoURL: "https://github.com/acme/checkout.git"}},
+		},
+		{
+			name: "no source",
+			spec: map[string]interface{}{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := &unstructured.Unstructured{Object: map[string]interface{}{"spec": test.spec}}
+			if got := ApplicationSources(app); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ApplicationSources() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
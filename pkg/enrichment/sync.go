@@ -0,0 +1,69 @@
+package enrichment
+
+import (
+	"strconv"
+	"strings"
+
+	consts "argocd-pod-enrichment/pkg/consts/webhook"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// SyncAnnotationKeys are the annotations written by SyncMetadata
+var SyncAnnotationKeys = []string{
+	consts.SyncRevisionAnnotationKey,
+	consts.SyncOperationPhaseAnnotationKey,
+	consts.SyncOperationStartedAtAnnotationKey,
+	consts.SyncOperationFinishedAtAnnotationKey,
+	consts.SyncHistoryIDAnnotationKey,
+}
+
+// SyncMetadata returns annotations describing the revision the Application is synced to
+// and its current or last sync operation. Multi-source revisions are joined with commas, in source order.
+func SyncMetadata(app *unstructured.Unstructured) *Metadata {
+	metadata := NewMetadata()
+
+	if revision, _, _ := unstructured.NestedString(app.Object, "status", "sync", "revision"); revision != "" {
+		metadata.Set(TargetAnnotation, consts.SyncRevisionAnnotationKey, revision)
+	} else if revisions, _, _ := unstructured.NestedStringSlice(app.Object, "status", "sync", "revisions"); len(revisions) > 0 {
+		metadata.Set(TargetAnnotation, consts.SyncRevisionAnnotationKey, strings.Join(revisions, ","))
+	}
+
+	if operationState, found, _ := unstructured.NestedMap(app.Object, "status", "operationState"); found {
+		fields := map[string]string{
+			"phase":      consts.SyncOperationPhaseAnnotationKey,
+			"startedAt":  consts.SyncOperationStartedAtAnnotationKey,
+			"finishedAt": consts.SyncOperationFinishedAtAnnotationKey,
+		}
+		for field, key := range fields {
+			if value, _, _ := unstructured.NestedString(operationState, field); value != "" {
+				metadata.Set(TargetAnnotation, key, value)
+			}
+		}
+	}
+
+	// The history ID of the last sync is the ID of the newest history entry
+	if history, _, _ := unstructured.NestedSlice(app.Object, "status", "history"); len(history) > 0 {
+		if last, ok := history[len(history)-1].(map[string]interface{}); ok {
+			// The ID is an int64 when read through the Kubernetes API and a float64 when decoded from the ArgoCD API
+			switch id := last["id"].(type) {
+			case int64:
+				metadata.Set(TargetAnnotation, consts.SyncHistoryIDAnnotationKey, strconv.FormatInt(id, 10))
+			case float64:
+				metadata.Set(TargetAnnotation, consts.SyncHistoryIDAnnotationKey, strconv.FormatInt(int64(id), 10))
+			}
+		}
+	}
+
+	return metadata
+}
+
+// HasSyncMetadata reports whether sync annotations were already recorded on the object
+func HasSyncMetadata(annotations map[string]string) bool {
+	for _, key := range SyncAnnotationKeys {
+		if _, ok := annotations[key]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package enrichmentpolicy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"argocd-pod-enrichment/pkg/enrichment"
+)
+
+// Evaluation holds the policies matching a pod, by precedence: PodEnrichmentPolicies before
+// ClusterPodEnrichmentPolicies, then by name
+type Evaluation struct {
+	Matched []*Policy
+	// OptOut is the first matching policy opting the pod out of the enrichment, nil if none does
+	OptOut *Policy
+}
+
+// Evaluate returns the policies matching the pod
+func Evaluate(policies []*Policy, target Target) *Evaluation {
+	evaluation := &Evaluation{}
+	for _, policy := range policies {
+		if policy.Matches(target) {
+			evaluation.Matched = append(evaluation.Matched, policy)
+		}
+	}
+	sort.SliceStable(evaluation.Matched, func(i, j int) bool {
+		a, b := evaluation.Matched[i], evaluation.Matched[j]
+		if (a.Namespace == "") != (b.Namespace == "") {
+			return a.Namespace != ""
+		}
+		return a.Name < b.Name
+	})
+	for _, policy := range evaluation.Matched {
+		if policy.Spec.OptOut {
+			evaluation.OptOut = policy
+			break
+		}
+	}
+	return evaluation
+}
+
+// Apply adds the metadata of the matched policies to desired, which holds the metadata of the global
+// enrichment config. existing holds the labels and annotations set on the pod by someone else. A key set by
+// a policy is never replaced by a policy of lower precedence, and skip policies never replace any value.
+// It returns the keys skipped because their value is not valid for the target or the key is reserved, and
+// the errors of the expressions that failed.
+func (e *Evaluation) Apply(desired *enrichment.Metadata, input *enrichment.ExpressionInput, existing *enrichment.Metadata) ([]string, error) {
+	skipped := []string{}
+	errs := []error{}
+	setByPolicy := enrichment.NewMetadata()
+
+	for _, policy := range e.Matched {
+		metadata, invalid := policy.config.ApplicationMetadata(input.App)
+		skipped = append(skipped, invalid...)
+		propagated, invalid := policy.config.PropagationMetadata(input.App, existing)
+		skipped = append(skipped, invalid...)
+		metadata.Merge(propagated)
+		computed, invalid, err := policy.config.ExpressionMetadata(input)
+		skipped = append(skipped, invalid...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", policy, err))
+		}
+		metadata.Merge(computed)
+
+		for _, target := range []enrichment.Target{enrichment.TargetLabel, enrichment.TargetAnnotation} {
+			values := metadata.Labels
+			if target == enrichment.TargetAnnotation {
+				values = metadata.Annotations
+			}
+			for key, value := range values {
+				if isReserved(key) {
+					skipped = append(skipped, key)
+					continue
+				}
+				if setByPolicy.Has(target, key) {
+					continue
+				}
+				if policy.Spec.ConflictPolicy == ConflictSkip && (desired.Has(target, key) || existing.Has(target, key)) {
+					continue
+				}
+				desired.Set(target, key, value)
+				setByPolicy.Set(target, key, value)
+			}
+		}
+	}
+	return skipped, errors.Join(errs...)
+}
+
+// References reports whether an expression of a matched policy references the variable
+func (e *Evaluation) References(variable string) bool {
+	for _, policy := range e.Matched {
+		if policy.config.References(variable) {
+			return true
+		}
+	}
+	return false
+}
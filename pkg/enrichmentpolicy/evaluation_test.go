@@ -0,0 +1,145 @@
+package enrichmentpolicy
+
+import (
+	"reflect"
+	"testing"
+
+	consts "argocd-pod-enrichment/pkg/consts/enrichmentpolicy"
+	"argocd-pod-enrichment/pkg/enrichment"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestEvaluate(t *testing.T) {
+	namespaced := consts.PodEnrichmentPolicyGVK.Kind
+	cluster := consts.ClusterPodEnrichmentPolicyGVK.Kind
+	policies := []*Policy{
+		parsePolicy(t, cluster, "", "a-cluster", "{}"),
+		parsePolicy(t, namespaced, "shop", "b-namespaced", "{}"),
+		parsePolicy(t, namespaced, "shop", "a-namespaced", "{}"),
+		parsePolicy(t, namespaced, "payments", "other-namespace", "{}"),
+		parsePolicy(t, cluster, "", "opt-out", `optOut: true`),
+		parsePolicy(t, namespaced, "shop", "z-opt-out", `{optOut: true, selector: {podSelector: {matchLabels: {tier: frontend}}}}`),
+	}
+
+	evaluation := Evaluate(policies, Target{Namespace: "shop", Labels: map[string]string{"tier": "backend"}})
+	names := []string{}
+	for _, policy := range evaluation.Matched {
+		names = append(names, policy.Name)
+	}
+	if want := []string{"a-namespaced", "b-namespaced", "a-cluster", "opt-out"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Matched = %v, want %v", names, want)
+	}
+	if evaluation.OptOut == nil || evaluation.OptOut.Name != "opt-out" {
+		t.Errorf("OptOut = %v, want opt-out", evaluation.OptOut)
+	}
+
+	// Namespaced policies come first, so they also decide the opt-out
+	evaluation = Evaluate(policies, Target{Namespace: "shop", Labels: map[string]string{"tier": "frontend"}})
+	if evaluation.OptOut == nil || evaluation.OptOut.Name != "z-opt-out" {
+		t.Errorf("OptOut = %v, want z-opt-out", evaluation.OptOut)
+	}
+
+	if evaluation := Evaluate(policies[:4], Target{Namespace: "shop"}); evaluation.OptOut != nil {
+		t.Errorf("OptOut = %v, want none", evaluation.OptOut)
+	}
+}
+
+func TestApply(t *testing.T) {
+	app := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"source": map[string]interface{}{"repoURL": "https://github.com/acme/shop.git"},
+		},
+	}}
+	app.SetNamespace("argocd")
+	app.SetName("checkout")
+	app.SetLabels(map[string]string{"team": "payments", "codefresh.io/internal": "true"})
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "checkout-abcde"}}
+	input := &enrichment.ExpressionInput{Pod: pod, App: app}
+
+	namespaced := consts.PodEnrichmentPolicyGVK.Kind
+	cluster := consts.ClusterPodEnrichmentPolicyGVK.Kind
+	tests := []struct {
+		name     string
+		policies []*Policy
+		desired  map[string]string
+		existing map[string]string
+		want     map[string]string
+		skipped  []string
+	}{
+		{
+			name:     "adds the policy metadata",
+			policies: []*Policy{parsePolicy(t, namespaced, "shop", "team", `propagationRules: [{sourceType: label, sourceKey: team, targetKey: example.com/team}]`)},
+			want:     map[string]string{"example.com/team": "payments"},
+		},
+		{
+			name: "namespaced policies win over cluster policies",
+			policies: []*Policy{
+				parsePolicy(t, namespaced, "shop", "z", `expressions: [{key: example.com/owner, expression: '"namespaced"'}]`),
+				parsePolicy(t, cluster, "", "a", `expressions: [{key: example.com/owner, expression: '"cluster"'}]`),
+			},
+			want: map[string]string{"example.com/owner": "namespaced"},
+		},
+		{
+			name: "policies are ordered by name",
+			policies: []*Policy{
+				parsePolicy(t, namespaced, "shop", "a", `expressions: [{key: example.com/owner, expression: '"a"'}]`),
+				parsePolicy(t, namespaced, "shop", "b", `expressions: [{key: example.com/owner, expression: '"b"'}]`),
+			},
+			want: map[string]string{"example.com/owner": "a"},
+		},
+		{
+			name:     "overwrite replaces the enrichment config and the pod",
+			policies: []*Policy{parsePolicy(t, namespaced, "shop", "owner", `expressions: [{key: example.com/owner, expression: '"policy"'}, {key: example.com/tier, expression: '"policy"'}]`)},
+			desired:  map[string]string{"example.com/owner": "config"},
+			existing: map[string]string{"example.com/tier": "pod"},
+			want:     map[string]string{"example.com/owner": "policy", "example.com/tier": "policy"},
+		},
+		{
+			name:     "skip keeps the enrichment config and the pod",
+			policies: []*Policy{parsePolicy(t, namespaced, "shop", "owner", `{conflictPolicy: skip, expressions: [{key: example.com/owner, expression: '"policy"'}, {key: example.com/tier, expression: '"policy"'}]}`)},
+			desired:  map[string]string{"example.com/owner": "config"},
+			existing: map[string]string{"example.com/tier": "pod"},
+			want:     map[string]string{"example.com/owner": "config"},
+		},
+		{
+			name:     "reserved keys of regex rules are skipped",
+			policies: []*Policy{parsePolicy(t, namespaced, "shop", "labels", `propagationRules: [{sourceType: label, sourceRegex: "^(team|codefresh.io/internal)$"}]`)},
+			want:     map[string]string{"team": "payments"},
+			skipped:  []string{"codefresh.io/internal"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			evaluation := Evaluate(test.policies, Target{Namespace: "shop"})
+			desired := enrichment.NewMetadata()
+			for key, value := range test.desired {
+				desired.Labels[key] = value
+			}
+			existing := enrichment.NewMetadata()
+			for key, value := range test.existing {
+				existing.Labels[key] = value
+			}
+
+			skipped, err := evaluation.Apply(desired, input, existing)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if !reflect.DeepEqual(desired.Labels, orEmpty(test.want)) {
+				t.Errorf("labels = %v, want %v", desired.Labels, test.want)
+			}
+			if !reflect.DeepEqual(skipped, append([]string{}, test.skipped...)) {
+				t.Errorf("skipped = %v, want %v", skipped, test.skipped)
+			}
+		})
+	}
+}
+
+func orEmpty(values map[string]string) map[string]string {
+	if values == nil {
+		return map[string]string{}
+	}
+	return values
+}
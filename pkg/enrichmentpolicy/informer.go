@@ -0,0 +1,86 @@
+package enrichmentpolicy
+
+import (
+	"context"
+	"fmt"
+
+	consts "argocd-pod-enrichment/pkg/consts/enrichmentpolicy"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// Informer keeps the policies in memory for the webhook, which has no controller-runtime cache
+type Informer struct {
+	Client dynamic.Interface
+	// Namespaces are the namespaces whose PodEnrichmentPolicies are watched, all namespaces if empty
+	Namespaces []string
+
+	parser Parser
+	// listers of PodEnrichmentPolicies by watched namespace
+	listers map[string]toolscache.GenericLister
+	cluster toolscache.GenericLister
+}
+
+// Start starts the informers and waits for their caches to sync
+func (i *Informer) Start(ctx context.Context) error {
+	i.listers = map[string]toolscache.GenericLister{}
+	synced := []toolscache.InformerSynced{}
+
+	namespaces := i.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	for _, namespace := range namespaces {
+		factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(i.Client, 0, namespace, nil)
+		informer := factory.ForResource(consts.PodEnrichmentPolicyGVR)
+		i.listers[namespace] = informer.Lister()
+		synced = append(synced, informer.Informer().HasSynced)
+		factory.Start(ctx.Done())
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(i.Client, 0)
+	informer := factory.ForResource(consts.ClusterPodEnrichmentPolicyGVR)
+	i.cluster = informer.Lister()
+	synced = append(synced, informer.Informer().HasSynced)
+	factory.Start(ctx.Done())
+
+	if !toolscache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("failed to sync enrichment policies")
+	}
+	return nil
+}
+
+// Policies returns the valid policies that may apply to the pods of the namespace
+func (i *Informer) Policies(namespace string) ([]*Policy, error) {
+	objs := []unstructured.Unstructured{}
+	lister, ok := i.listers[namespace]
+	if !ok {
+		lister, ok = i.listers[metav1.NamespaceAll]
+	}
+	if ok {
+		namespaced, err := lister.ByNamespace(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range namespaced {
+			if policy, isUnstructured := obj.(*unstructured.Unstructured); isUnstructured {
+				objs = append(objs, *policy)
+			}
+		}
+	}
+	cluster, err := i.cluster.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range cluster {
+		if policy, isUnstructured := obj.(*unstructured.Unstructured); isUnstructured {
+			objs = append(objs, *policy)
+		}
+	}
+	return i.parser.ParseAll(objs), nil
+}
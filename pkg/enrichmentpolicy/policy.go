@@ -0,0 +1,214 @@
+package enrichmentpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	consts "argocd-pod-enrichment/pkg/consts/enrichmentpolicy"
+	"argocd-pod-enrichment/pkg/enrichment"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// ConflictPolicy decides what happens when a key of the policy is already set, on the pod by someone else,
+// by the global enrichment config or by a policy of higher precedence
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces values set on the pod or by the global enrichment config
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip keeps the values already set
+	ConflictSkip ConflictPolicy = "skip"
+)
+
+// Spec is the spec of PodEnrichmentPolicy and ClusterPodEnrichmentPolicy
+type Spec struct {
+	// Selector selects the pods the policy applies to, every pod in scope if empty
+	Selector Selector `json:"selector,omitempty"`
+	// OptOut disables the enrichment of the selected pods, by the webhook and the controller
+	OptOut bool `json:"optOut,omitempty"`
+	// ConflictPolicy is overwrite, the default, or skip
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy,omitempty"`
+	// ApplicationFields, PropagationRules and Expressions have the format of the enrichment config
+	ApplicationFields []enrichment.FieldMapping    `json:"applicationFields,omitempty"`
+	PropagationRules  []enrichment.PropagationRule `json:"propagationRules,omitempty"`
+	Expressions       []enrichment.Expression      `json:"expressions,omitempty"`
+}
+
+// Selector selects pods by namespace, Application and labels. All of the set criteria must match.
+type Selector struct {
+	// Namespaces restricts a ClusterPodEnrichmentPolicy to these namespaces. A PodEnrichmentPolicy only
+	// applies to its own namespace and must not set it.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Applications restricts the policy to the pods of these Applications, as name or namespace/name
+	Applications []string `json:"applications,omitempty"`
+	// PodSelector selects pods by label
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+}
+
+// ErrReservedKey is returned for policies setting a key with consts.ReservedKeyPrefix
+var ErrReservedKey = enrichment.ErrReservedKey
+
+// Policy is a parsed and validated PodEnrichmentPolicy or ClusterPodEnrichmentPolicy
+type Policy struct {
+	// Namespace is empty for a ClusterPodEnrichmentPolicy
+	Namespace string
+	Name      string
+	Spec      Spec
+
+	podSelector labels.Selector
+	config      *enrichment.Config
+}
+
+// Target describes the pod a policy is evaluated for
+type Target struct {
+	Namespace            string
+	Labels               map[string]string
+	ApplicationNamespace string
+	ApplicationName      string
+}
+
+// Parse decodes and validates a PodEnrichmentPolicy or ClusterPodEnrichmentPolicy
+func Parse(obj *unstructured.Unstructured) (*Policy, error) {
+	policy := &Policy{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	// The rules of the spec hold unexported compiled state, which the unstructured converter cannot skip
+	spec, _, _ := unstructured.NestedMap(obj.Object, "spec")
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &policy.Spec); err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+	if err := policy.validate(obj.GetKind() == consts.ClusterPodEnrichmentPolicyGVK.Kind); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (p *Policy) validate(clusterScoped bool) error {
+	if p.Spec.ConflictPolicy == "" {
+		p.Spec.ConflictPolicy = ConflictOverwrite
+	}
+	if p.Spec.ConflictPolicy != ConflictOverwrite && p.Spec.ConflictPolicy != ConflictSkip {
+		return fmt.Errorf("invalid conflictPolicy %q, expected overwrite or skip", p.Spec.ConflictPolicy)
+	}
+	if !clusterScoped && len(p.Spec.Selector.Namespaces) > 0 {
+		return fmt.Errorf("selector.namespaces is only supported by ClusterPodEnrichmentPolicy")
+	}
+	for i, application := range p.Spec.Selector.Applications {
+		namespace, name, found := strings.Cut(application, "/")
+		if !found {
+			namespace, name = "", application
+		}
+		if name == "" || found && namespace == "" {
+			return fmt.Errorf("selector.applications[%d]: invalid Application %q, expected name or namespace/name", i, application)
+		}
+	}
+	p.podSelector = labels.Everything()
+	if p.Spec.Selector.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(p.Spec.Selector.PodSelector)
+		if err != nil {
+			return fmt.Errorf("selector.podSelector: %w", err)
+		}
+		p.podSelector = selector
+	}
+	if p.Spec.OptOut && (len(p.Spec.ApplicationFields) > 0 || len(p.Spec.PropagationRules) > 0 || len(p.Spec.Expressions) > 0) {
+		return fmt.Errorf("optOut cannot be combined with applicationFields, propagationRules or expressions")
+	}
+
+	p.config = &enrichment.Config{ApplicationFields: p.Spec.ApplicationFields, PropagationRules: p.Spec.PropagationRules, Expressions: p.Spec.Expressions}
+	if err := p.config.Validate(); err != nil {
+		return err
+	}
+	return p.validateKeys()
+}
+
+// validateKeys rejects the propagation rule of the default enrichment config, the only reserved key
+// Config.Validate accepts, since policies cannot set the product label either
+func (p *Policy) validateKeys() error {
+	for i, rule := range p.Spec.PropagationRules {
+		if rule.SourceKey != "" && isReserved(rule.TargetKey) {
+			return fmt.Errorf("propagationRules[%d]: %w %q, keys with the prefix %s are set by the controller", i, ErrReservedKey, rule.TargetKey, consts.ReservedKeyPrefix)
+		}
+	}
+	return nil
+}
+
+func isReserved(key string) bool {
+	return strings.HasPrefix(key, consts.ReservedKeyPrefix)
+}
+
+// Matches reports whether the policy applies to the pod
+func (p *Policy) Matches(target Target) bool {
+	if p.Namespace != "" && p.Namespace != target.Namespace {
+		return false
+	}
+	if len(p.Spec.Selector.Namespaces) > 0 && !slices.Contains(p.Spec.Selector.Namespaces, target.Namespace) {
+		return false
+	}
+	if len(p.Spec.Selector.Applications) > 0 {
+		if target.ApplicationName == "" {
+			return false
+		}
+		if !slices.Contains(p.Spec.Selector.Applications, target.ApplicationName) &&
+			!slices.Contains(p.Spec.Selector.Applications, target.ApplicationNamespace+"/"+target.ApplicationName) {
+			return false
+		}
+	}
+	return p.podSelector.Matches(labels.Set(target.Labels))
+}
+
+// String identifies the policy for logs
+func (p *Policy) String() string {
+	if p.Namespace == "" {
+		return consts.ClusterPodEnrichmentPolicyGVK.Kind + " " + p.Name
+	}
+	return consts.PodEnrichmentPolicyGVK.Kind + " " + p.Namespace + "/" + p.Name
+}
+
+// Parser parses policies once per generation, so that evaluating them on every admission or reconcile does
+// not compile their selectors and regular expressions again
+type Parser struct {
+	mu      sync.Mutex
+	entries map[types.UID]parsed
+}
+
+type parsed struct {
+	generation int64
+	policy     *Policy
+	err        error
+}
+
+// Parse returns the parsed policy, from the previous parse if the generation did not change
+func (p *Parser) Parse(obj *unstructured.Unstructured) (*Policy, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry, ok := p.entries[obj.GetUID()]; ok && entry.generation == obj.GetGeneration() {
+		return entry.policy, entry.err
+	}
+	policy, err := Parse(obj)
+	if p.entries == nil {
+		p.entries = map[types.UID]parsed{}
+	}
+	p.entries[obj.GetUID()] = parsed{generation: obj.GetGeneration(), policy: policy, err: err}
+	return policy, err
+}
+
+// ParseAll parses the policies, skipping the invalid ones which are reported in their status
+func (p *Parser) ParseAll(objs []unstructured.Unstructured) []*Policy {
+	policies := make([]*Policy, 0, len(objs))
+	for i := range objs {
+		if policy, err := p.Parse(&objs[i]); err == nil {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
@@ -0,0 +1,154 @@
+package enrichmentpolicy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	consts "argocd-pod-enrichment/pkg/consts/enrichmentpolicy"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// policyObject builds a policy of kind from the YAML of its spec
+func policyObject(t *testing.T, kind, namespace, name, spec string) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if err := yaml.Unmarshal([]byte(spec), &obj.Object); err != nil {
+		t.Fatalf("invalid test spec: %v", err)
+	}
+	obj.Object = map[string]interface{}{"spec": obj.Object}
+	obj.SetGroupVersionKind(consts.PodEnrichmentPolicyGVK.GroupVersion().WithKind(kind))
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func parsePolicy(t *testing.T, kind, namespace, name, spec string) *Policy {
+	t.Helper()
+	policy, err := Parse(policyObject(t, kind, namespace, name, spec))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return policy
+}
+
+func TestParse(t *testing.T) {
+	namespaced := consts.PodEnrichmentPolicyGVK.Kind
+	cluster := consts.ClusterPodEnrichmentPolicyGVK.Kind
+	tests := []struct {
+		name         string
+		kind         string
+		spec         string
+		wantErr      string
+		wantReserved bool
+	}{
+		{name: "empty spec", kind: namespaced, spec: "{}"},
+		{name: "metadata", kind: namespaced, spec: `
+applicationFields: [{field: repoURL, key: team.example.com/repo, target: annotation}]
+propagationRules: [{sourceType: label, sourceKey: team, targetKey: team.example.com/team}]
+expressions: [{key: team.example.com/app, template: "{{app.name}}"}]`},
+		{name: "cluster selector", kind: cluster, spec: `selector: {namespaces: [shop], applications: [argocd/shop, shop]}`},
+		{name: "opt out", kind: namespaced, spec: `optOut: true`},
+		{name: "unknown field", kind: namespaced, spec: `selectors: {}`, wantErr: "unknown field"},
+		{name: "invalid conflict policy", kind: namespaced, spec: `conflictPolicy: merge`, wantErr: "invalid conflictPolicy"},
+		{name: "namespaces on a namespaced policy", kind: namespaced, spec: `selector: {namespaces: [shop]}`, wantErr: "only supported by ClusterPodEnrichmentPolicy"},
+		{name: "invalid application", kind: namespaced, spec: `selector: {applications: [argocd/]}`, wantErr: "invalid Application"},
+		{name: "invalid pod selector", kind: namespaced, spec: `selector: {podSelector: {matchExpressions: [{key: tier, operator: Near}]}}`, wantErr: "selector.podSelector"},
+		{name: "opt out with metadata", kind: namespaced, spec: `
+optOut: true
+applicationFields: [{field: repoURL, key: team.example.com/repo}]`, wantErr: "optOut cannot be combined"},
+		{name: "invalid expression", kind: namespaced, spec: `expressions: [{key: team.example.com/app, expression: "app.unknown"}]`, wantErr: "expressions[0]"},
+		{name: "reserved application field key", kind: namespaced, spec: `applicationFields: [{field: repoURL, key: codefresh.io/repo}]`, wantReserved: true},
+		{name: "reserved propagation target key", kind: namespaced, spec: `propagationRules: [{sourceKey: team, targetKey: codefresh.io/team}]`, wantReserved: true},
+		{name: "reserved propagation source key", kind: namespaced, spec: `propagationRules: [{sourceKey: codefresh.io/product}]`, wantReserved: true},
+		{name: "reserved propagation prefix", kind: namespaced, spec: `propagationRules: [{sourcePrefix: team.example.com/, targetPrefix: codefresh.io/team-}]`, wantReserved: true},
+		{name: "propagation prefix covering the reserved keys", kind: cluster, spec: `propagationRules: [{sourcePrefix: codefresh.}]`, wantReserved: true},
+		{name: "reserved expression key", kind: cluster, spec: `expressions: [{key: codefresh.io/app, expression: "app.name"}]`, wantReserved: true},
+		{name: "subdomain of the reserved prefix", kind: namespaced, spec: `applicationFields: [{field: repoURL, key: team.codefresh.io/repo}]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := Parse(policyObject(t, test.kind, "shop", "policy", test.spec))
+			switch {
+			case test.wantReserved:
+				if !errors.Is(err, ErrReservedKey) {
+					t.Fatalf("Parse() error = %v, want ErrReservedKey", err)
+				}
+			case test.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Parse() error = %v, want it to contain %q", err, test.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("Parse() error = %v", err)
+			case policy.Spec.ConflictPolicy != ConflictOverwrite:
+				t.Errorf("conflictPolicy = %q, want the default %q", policy.Spec.ConflictPolicy, ConflictOverwrite)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	target := Target{
+		Namespace:            "shop",
+		Labels:               map[string]string{"tier": "backend"},
+		ApplicationNamespace: "argocd",
+		ApplicationName:      "checkout",
+	}
+	namespaced := consts.PodEnrichmentPolicyGVK.Kind
+	cluster := consts.ClusterPodEnrichmentPolicyGVK.Kind
+	tests := []struct {
+		name      string
+		kind      string
+		namespace string
+		spec      string
+		target    Target
+		want      bool
+	}{
+		{name: "empty selector", kind: namespaced, namespace: "shop", spec: "{}", target: target, want: true},
+		{name: "other namespace", kind: namespaced, namespace: "payments", spec: "{}", target: target, want: false},
+		{name: "cluster policy", kind: cluster, spec: "{}", target: target, want: true},
+		{name: "cluster policy in namespace", kind: cluster, spec: `selector: {namespaces: [shop]}`, target: target, want: true},
+		{name: "cluster policy in other namespace", kind: cluster, spec: `selector: {namespaces: [payments]}`, target: target, want: false},
+		{name: "application by name", kind: namespaced, namespace: "shop", spec: `selector: {applications: [checkout]}`, target: target, want: true},
+		{name: "application by namespace and name", kind: namespaced, namespace: "shop", spec: `selector: {applications: [argocd/checkout]}`, target: target, want: true},
+		{name: "application in other namespace", kind: namespaced, namespace: "shop", spec: `selector: {applications: [team-a/checkout]}`, target: target, want: false},
+		{name: "pod without Application", kind: namespaced, namespace: "shop", spec: `selector: {applications: [checkout]}`, target: Target{Namespace: "shop"}, want: false},
+		{name: "pod selector", kind: namespaced, namespace: "shop", spec: `selector: {podSelector: {matchLabels: {tier: backend}}}`, target: target, want: true},
+		{name: "pod selector not matching", kind: namespaced, namespace: "shop", spec: `selector: {podSelector: {matchLabels: {tier: frontend}}}`, target: target, want: false},
+		{name: "all criteria", kind: cluster, spec: `selector: {namespaces: [shop], applications: [checkout], podSelector: {matchLabels: {tier: backend}}}`, target: target, want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := parsePolicy(t, test.kind, test.namespace, "policy", test.spec)
+			if got := policy.Matches(test.target); got != test.want {
+				t.Errorf("Matches() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParserCachesByGeneration(t *testing.T) {
+	parser := &Parser{}
+	obj := policyObject(t, consts.PodEnrichmentPolicyGVK.Kind, "shop", "policy", `conflictPolicy: skip`)
+	obj.SetUID("uid")
+	obj.SetGeneration(1)
+
+	first, err := parser.Parse(obj)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if second, _ := parser.Parse(obj); second != first {
+		t.Errorf("Parse() parsed the same generation again")
+	}
+
+	obj.Object["spec"] = map[string]interface{}{"conflictPolicy": "merge"}
+	obj.SetGeneration(2)
+	if _, err := parser.Parse(obj); err == nil {
+		t.Errorf("Parse() of the new generation succeeded, want the validation error")
+	}
+	if policies := parser.ParseAll([]unstructured.Unstructured{*obj}); len(policies) != 0 {
+		t.Errorf("ParseAll() = %v, want the invalid policy skipped", policies)
+	}
+}
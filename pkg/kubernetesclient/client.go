@@ -2,33 +2,54 @@ package kubernetesclient
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"slices"
 	"strings"
 
+	argocdconsts "argocd-pod-enrichment/pkg/consts/argocd"
+	"argocd-pod-enrichment/pkg/ownerbridge"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	dyclient "k8s.io/client-go/dynamic"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 type KubernetesClient struct {
 	DynamicClient dyclient.Interface
+	// MetadataClient reads owners as metadata only, their spec and status are never read
+	MetadataClient metadata.Interface
+	AuthorizationClient authorizationclient.AuthorizationV1Interface
+	// CoreClient records Events
+	CoreClient corev1client.CoreV1Interface
 	discoveryClient *discovery.DiscoveryClient
+	// OwnerBridgeRules are consulted when a resource has no controller ownerReference
+	OwnerBridgeRules []ownerbridge.Rule
+	// Namespaces restricts owner lookups to these namespaces, all namespaces are allowed if it is empty
+	Namespaces []string
 }
 
-// NewInClusterKubernetesClient initializes a dynamic client using in-cluster config
-func NewInClusterKubernetesClient() (*KubernetesClient, error) {
+// ErrOwnerOutOfScope is returned for owners outside Namespaces, including cluster-scoped owners
+var ErrOwnerOutOfScope = errors.New("owner is outside the watched namespaces")
+
+// NewInClusterKubernetesClient initializes a dynamic client using the kubeconfig file if set, the in-cluster config otherwise
+func NewInClusterKubernetesClient(kubeconfig string) (*KubernetesClient, error) {
        var config *rest.Config
        var err error
-       kubeconfig := os.Getenv("KUBECONFIG")
        if kubeconfig != "" {
 	       config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
 	       if err != nil {
-		       return nil, fmt.Errorf("failed to build config from KUBECONFIG: %w", err)
+		       return nil, fmt.Errorf("failed to build config from kubeconfig %s: %w", kubeconfig, err)
 	       }
        } else {
 	       config, err = rest.InClusterConfig()
@@ -36,6 +57,11 @@ func NewInClusterKubernetesClient() (*KubernetesClient, error) {
 		       return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
 	       }
        }
+       return NewKubernetesClientForConfig(config)
+}
+
+// NewKubernetesClientForConfig initializes a dynamic client for the given config
+func NewKubernetesClientForConfig(config *rest.Config) (*KubernetesClient, error) {
        dynClient, err := dyclient.NewForConfig(config)
        if err != nil {
 	       return nil, fmt.Errorf("failed to create dynamic client: %w", err)
@@ -44,41 +70,148 @@ func NewInClusterKubernetesClient() (*KubernetesClient, error) {
        if err != nil {
 	       return nil, fmt.Errorf("failed to create discovery client: %w", err)
        }
-       return &KubernetesClient{DynamicClient: dynClient, discoveryClient: discoveryClient}, nil
+       metadataClient, err := metadata.NewForConfig(config)
+       if err != nil {
+	       return nil, fmt.Errorf("failed to create metadata client: %w", err)
+       }
+       authorizationClient, err := authorizationclient.NewForConfig(config)
+       if err != nil {
+	       return nil, fmt.Errorf("failed to create authorization client: %w", err)
+       }
+       coreClient, err := corev1client.NewForConfig(config)
+       if err != nil {
+	       return nil, fmt.Errorf("failed to create core client: %w", err)
+       }
+       return &KubernetesClient{DynamicClient: dynClient, MetadataClient: metadataClient, AuthorizationClient: authorizationClient, CoreClient: coreClient, discoveryClient: discoveryClient}, nil
 }
 
 func (c *KubernetesClient) GetTopmostControllerOwner(res *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	chain, err := c.GetControllerOwnerChain(res)
+	if err != nil {
+		return nil, err
+	}
+	return chain[len(chain)-1], nil
+}
+
+// GetControllerOwnerChain walks the controller owners of res and returns them in order,
+// starting with res itself and ending with the topmost owner.
+func (c *KubernetesClient) GetControllerOwnerChain(res *unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	return c.getControllerOwnerChain(res, []*unstructured.Unstructured{}, map[types.UID]bool{})
+}
+
+func (c *KubernetesClient) getControllerOwnerChain(res *unstructured.Unstructured, chain []*unstructured.Unstructured, visited map[types.UID]bool) ([]*unstructured.Unstructured, error) {
+
+	// Bridge rules are user supplied and may form a loop, stop at the first repeated resource
+	if uid := res.GetUID(); uid != "" {
+		if visited[uid] {
+			return chain, nil
+		}
+		visited[uid] = true
+	}
+
+	chain = append(chain, res)
 
 	owners := res.GetOwnerReferences()
 
 	for _, ownerRef := range owners {
 
 		if ownerRef.Controller != nil && *ownerRef.Controller {
-			gvr, isNamespaced, err := c.gvrFromAPIVersionKind(ownerRef.APIVersion, ownerRef.Kind)
-
-			if err != nil {
-				return nil, fmt.Errorf("error getting GVR from apiVersion/kind: %v", err)
-			}
+			ownerRes, err := c.getOwnerResource(res.GetNamespace(), ownerRef.APIVersion, ownerRef.Kind, ownerRef.Name)
 
-			var ownerRes *unstructured.Unstructured
-
-			if isNamespaced {
-				// If the owner is namespaced, we need to get it from the same namespace
-				ownerRes, err = c.DynamicClient.Resource(gvr).Namespace(res.GetNamespace()).Get(context.TODO(), ownerRef.Name, metav1.GetOptions{})
-			} else {
-				ownerRes, err = c.DynamicClient.Resource(gvr).Get(context.TODO(), ownerRef.Name, metav1.GetOptions{})
+			if errors.Is(err, ErrOwnerOutOfScope) {
+				// The owner cannot be read, the current resource is the topmost one
+				return chain, nil
 			}
 
 			if err != nil {
-				return nil, fmt.Errorf("error getting owner resource %s/%s: %v", ownerRef.Kind, ownerRef.Name, err)
+				return nil, fmt.Errorf("error getting owner resource %s/%s: %w", ownerRef.Kind, ownerRef.Name, err)
 			}
 
 			// Recursively get the topmost owner
-			return c.GetTopmostControllerOwner(ownerRes)
+			return c.getControllerOwnerChain(ownerRes, chain, visited)
+		}
+	}
+
+	// No controller ownerReference, check whether the parent is linked through a label instead
+	rule, parentName := ownerbridge.FindParent(c.OwnerBridgeRules, res)
+	if rule != nil {
+		parentRes, err := c.getOwnerResource(res.GetNamespace(), rule.ParentAPIVersion, rule.ParentKind, parentName)
+
+		if apierrors.IsNotFound(err) || errors.Is(err, ErrOwnerOutOfScope) {
+			// The label points to a parent that no longer exists or cannot be read, the current resource is the topmost one
+			return chain, nil
 		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error getting bridged owner resource %s/%s: %w", rule.ParentKind, parentName, err)
+		}
+
+		return c.getControllerOwnerChain(parentRes, chain, visited)
+	}
+
+	return chain, nil
+}
+
+// getOwnerResource fetches the metadata of the owner identified by apiVersion, kind and name.
+// Namespaced owners are looked up in the namespace of the child resource.
+func (c *KubernetesClient) getOwnerResource(namespace, apiVersion, kind, name string) (*unstructured.Unstructured, error) {
+	gvr, isNamespaced, err := c.gvrFromAPIVersionKind(apiVersion, kind)
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting GVR from apiVersion/kind: %w", err)
+	}
+
+	if len(c.Namespaces) > 0 && (!isNamespaced || !slices.Contains(c.Namespaces, namespace)) {
+		return nil, ErrOwnerOutOfScope
+	}
+
+	var ownerMetadata *metav1.PartialObjectMetadata
+	if isNamespaced {
+		// If the owner is namespaced, we need to get it from the same namespace
+		ownerMetadata, err = c.MetadataClient.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	} else {
+		ownerMetadata, err = c.MetadataClient.Resource(gvr).Get(context.TODO(), name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	objectMeta, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&ownerMetadata.ObjectMeta)
+	if err != nil {
+		return nil, err
+	}
+	owner := &unstructured.Unstructured{Object: map[string]interface{}{"metadata": objectMeta}}
+	owner.SetAPIVersion(apiVersion)
+	owner.SetKind(kind)
+	return owner, nil
+}
+
+// GetResource fetches the full object of a resource of an owner chain, for the few owners whose spec or
+// status is needed
+func (c *KubernetesClient) GetResource(ctx context.Context, res *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	gvr, isNamespaced, err := c.gvrFromAPIVersionKind(res.GetAPIVersion(), res.GetKind())
+	if err != nil {
+		return nil, fmt.Errorf("error getting GVR from apiVersion/kind: %w", err)
+	}
+	if isNamespaced {
+		return c.DynamicClient.Resource(gvr).Namespace(res.GetNamespace()).Get(ctx, res.GetName(), metav1.GetOptions{})
 	}
+	return c.DynamicClient.Resource(gvr).Get(ctx, res.GetName(), metav1.GetOptions{})
+}
+
+// ResourceFor returns the GroupVersionResource of apiVersion and kind, and whether it is namespaced
+func (c *KubernetesClient) ResourceFor(apiVersion, kind string) (schema.GroupVersionResource, bool, error) {
+	return c.gvrFromAPIVersionKind(apiVersion, kind)
+}
+
+// GetArgoCDApplication fetches an ArgoCD Application by namespace and name.
+func (c *KubernetesClient) GetArgoCDApplication(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	return c.DynamicClient.Resource(argocdconsts.ApplicationGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+}
 
-	return res, nil
+// GetArgoCDAppProject fetches an ArgoCD AppProject by namespace and name.
+func (c *KubernetesClient) GetArgoCDAppProject(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	return c.DynamicClient.Resource(argocdconsts.AppProjectGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
 }
 
 // GVRFromAPIVersionKind returns the GroupVersionResource for the given apiVersion and kind using discoveryClient.
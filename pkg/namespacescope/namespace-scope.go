@@ -0,0 +1,94 @@
+package namespacescope
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	consts "argocd-pod-enrichment/pkg/consts/namespacescope"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var namespaceGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// Scope restricts the pods handled by the webhook and the controller to a set of namespaces,
+// given explicitly or by a namespace label selector. A nil Scope covers the whole cluster.
+type Scope struct {
+	Namespaces []string
+	Selector   labels.Selector
+}
+
+// Load builds the scope from the watched namespaces or the namespace selector of the configuration.
+// It returns nil if neither is set.
+func Load(namespaces []string, selector string) (*Scope, error) {
+	scope, err := Parse(strings.Join(namespaces, ","), selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s or %s: %w", consts.WatchNamespacesEnvironmentVariable, consts.WatchNamespaceSelectorEnvironmentVariable, err)
+	}
+	return scope, nil
+}
+
+// Parse builds the scope from comma separated namespaces or a namespace label selector.
+// It returns nil if both are empty.
+func Parse(namespacesValue, selectorValue string) (*Scope, error) {
+	namespaces := []string{}
+	for _, namespace := range strings.Split(namespacesValue, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" && !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	selector := strings.TrimSpace(selectorValue)
+
+	switch {
+	case len(namespaces) > 0 && selector != "":
+		return nil, fmt.Errorf("namespaces and namespace selector are mutually exclusive")
+	case len(namespaces) > 0:
+		return &Scope{Namespaces: namespaces}, nil
+	case selector != "":
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %w", err)
+		}
+		return &Scope{Selector: parsed}, nil
+	}
+	return nil, nil
+}
+
+// Resolve returns the namespaces of the scope. Selectors are resolved by listing namespaces once,
+// which needs cluster-wide list access to namespaces. Namespaces matching the selector later are only
+// picked up by resolving it again, i.e. on restart.
+func (s *Scope) Resolve(ctx context.Context, client dynamic.Interface) ([]string, error) {
+	if s.Selector == nil {
+		return s.Namespaces, nil
+	}
+	list, err := client.Resource(namespaceGVR).List(ctx, metav1.ListOptions{LabelSelector: s.Selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces matching %q: %w", s.Selector.String(), err)
+	}
+	namespaces := make([]string, 0, len(list.Items))
+	for _, namespace := range list.Items {
+		namespaces = append(namespaces, namespace.GetName())
+	}
+	sort.Strings(namespaces)
+	if len(namespaces) == 0 {
+		return nil, fmt.Errorf("no namespace matches %q", s.Selector.String())
+	}
+	return namespaces, nil
+}
+
+// String describes the scope for logs
+func (s *Scope) String() string {
+	if s == nil {
+		return "all namespaces"
+	}
+	if s.Selector != nil {
+		return "namespaces matching " + s.Selector.String()
+	}
+	return "namespaces " + strings.Join(s.Namespaces, ",")
+}
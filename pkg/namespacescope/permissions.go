@@ -0,0 +1,191 @@
+package namespacescope
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	consts "argocd-pod-enrichment/pkg/consts/namespacescope"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+// Permission is an access a command needs, in every namespace of its scope
+type Permission struct {
+	Group    string
+	Resource string
+	Verbs    []string
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	return resource
+}
+
+// CheckPermissions verifies with SelfSubjectAccessReviews that the service account has every permission in
+// every namespace. An empty namespace stands for all namespaces. The error lists all missing permissions.
+func CheckPermissions(ctx context.Context, client authorizationclient.SelfSubjectAccessReviewsGetter, namespaces []string, permissions []Permission) error {
+	missing := []error{}
+	for _, namespace := range namespaces {
+		for _, permission := range permissions {
+			for _, verb := range permission.Verbs {
+				resource, subresource, _ := strings.Cut(permission.Resource, "/")
+				review, err := client.SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+					Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+						ResourceAttributes: &authorizationv1.ResourceAttributes{
+							Namespace:   namespace,
+							Verb:        verb,
+							Group:       permission.Group,
+							Resource:    resource,
+							Subresource: subresource,
+						},
+					},
+				}, metav1.CreateOptions{})
+				if err != nil {
+					return fmt.Errorf("failed to review access to %s: %w", permission, err)
+				}
+				if !review.Status.Allowed {
+					where := "namespace " + namespace
+					if namespace == "" {
+						where = "all namespaces"
+					}
+					missing = append(missing, fmt.Errorf("cannot %s %s in %s", verb, permission, where))
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing RBAC permissions: %w", errors.Join(missing...))
+	}
+	return nil
+}
+
+var (
+	// PodPermissions are needed by the controller in every watched namespace
+	PodPermissions = []Permission{
+		{Resource: "pods", Verbs: []string{"get", "list", "watch", "patch"}},
+	}
+	// ApplicationPermissions are needed by the controller in the Application namespaces
+	ApplicationPermissions = []Permission{
+		{Group: "argoproj.io", Resource: "applications", Verbs: []string{"get", "list", "watch"}},
+	}
+	// HookApplicationPermissions are needed by the webhook to read the sync operation of hooks
+	HookApplicationPermissions = []Permission{
+		{Group: "argoproj.io", Resource: "applications", Verbs: []string{"get"}},
+	}
+	// RolloutPermissions are needed by the controller in every watched namespace to follow the role of Rollout
+	// pods, when the owner kinds include Rollouts
+	RolloutPermissions = []Permission{
+		{Group: "argoproj.io", Resource: "rollouts", Verbs: []string{"get", "list", "watch"}},
+	}
+	// AppProjectPermissions are needed by the controller in the ArgoCD namespace when enrichment expressions
+	// reference the AppProject
+	AppProjectPermissions = []Permission{
+		{Group: "argoproj.io", Resource: "appprojects", Verbs: []string{"get"}},
+	}
+	// ClusterSecretPermissions are needed by the controller in the ArgoCD namespace in multi-cluster mode
+	ClusterSecretPermissions = []Permission{
+		{Resource: "secrets", Verbs: []string{"get", "list", "watch"}},
+	}
+	// ClusterIdentityPermissions are needed by the controller in the ArgoCD namespace to resolve the cluster
+	// identity from the cluster secrets
+	ClusterIdentityPermissions = []Permission{
+		{Resource: "secrets", Verbs: []string{"list"}},
+	}
+	// LeasePermissions are needed by the controller in the namespace of the shard Leases
+	LeasePermissions = []Permission{
+		{Group: "coordination.k8s.io", Resource: "leases", Verbs: []string{"get", "list", "create", "update", "delete"}},
+	}
+	// RuntimeConfigPermissions are needed by both components in the namespace of the runtime config ConfigMap,
+	// to watch it and to report rejected updates with Events
+	RuntimeConfigPermissions = []Permission{
+		{Resource: "configmaps", Verbs: []string{"get", "list", "watch"}},
+		{Resource: "events", Verbs: []string{"create", "patch"}},
+	}
+	// PolicyPermissions are needed by both components in every watched namespace when policies are enabled
+	PolicyPermissions = []Permission{
+		{Group: "enrichment.codefresh.io", Resource: "podenrichmentpolicies", Verbs: []string{"get", "list", "watch"}},
+	}
+	// ClusterPolicyPermissions are needed by both components cluster-wide when policies are enabled
+	ClusterPolicyPermissions = []Permission{
+		{Group: "enrichment.codefresh.io", Resource: "clusterpodenrichmentpolicies", Verbs: []string{"get", "list", "watch"}},
+	}
+	// PolicyStatusPermissions are needed by the controller to report the status of the policies, namespaced
+	// policies in every watched namespace and cluster policies cluster-wide
+	PolicyStatusPermissions = []Permission{
+		{Group: "enrichment.codefresh.io", Resource: "podenrichmentpolicies/status", Verbs: []string{"patch"}},
+	}
+	ClusterPolicyStatusPermissions = []Permission{
+		{Group: "enrichment.codefresh.io", Resource: "clusterpodenrichmentpolicies/status", Verbs: []string{"patch"}},
+	}
+	// NamespacePermissions are needed cluster-wide to resolve WATCH_NAMESPACE_SELECTOR
+	NamespacePermissions = []Permission{
+		{Resource: "namespaces", Verbs: []string{"list"}},
+	}
+)
+
+// OwnerPermissions cover the owner lookups of pods created by the built-in workload controllers.
+// They are the default when OWNER_KINDS is not set.
+var OwnerPermissions = []Permission{
+	{Group: "apps", Resource: "replicasets", Verbs: []string{"get"}},
+	{Group: "apps", Resource: "deployments", Verbs: []string{"get"}},
+	{Group: "apps", Resource: "statefulsets", Verbs: []string{"get"}},
+	{Group: "apps", Resource: "daemonsets", Verbs: []string{"get"}},
+	{Group: "batch", Resource: "jobs", Verbs: []string{"get"}},
+	{Group: "batch", Resource: "cronjobs", Verbs: []string{"get"}},
+}
+
+// IncludesResource reports whether permissions cover the resource of group
+func IncludesResource(permissions []Permission, group, resource string) bool {
+	return slices.ContainsFunc(permissions, func(permission Permission) bool {
+		return permission.Group == group && permission.Resource == resource
+	})
+}
+
+// ParseResource parses a resource in the resource.group form of kubectl, e.g. replicasets.apps,
+// into a permission to get it
+func ParseResource(value string) (Permission, error) {
+	value = strings.TrimSpace(value)
+	resource, group, _ := strings.Cut(value, ".")
+	if resource == "" {
+		return Permission{}, fmt.Errorf("invalid resource %q, expected resource.group", value)
+	}
+	return Permission{Group: group, Resource: resource, Verbs: []string{"get"}}, nil
+}
+
+// ParseResources parses a comma separated list of resources in the resource.group form
+func ParseResources(value string) ([]Permission, error) {
+	permissions := []Permission{}
+	for _, resource := range strings.Split(value, ",") {
+		if strings.TrimSpace(resource) == "" {
+			continue
+		}
+		permission, err := ParseResource(resource)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, nil
+}
+
+// ParseOwnerKinds returns the owner lookups of the configured owner kinds in the resource.group form,
+// or OwnerPermissions if none is configured
+func ParseOwnerKinds(kinds []string) ([]Permission, error) {
+	value := strings.Join(kinds, ",")
+	if strings.TrimSpace(value) == "" {
+		return OwnerPermissions, nil
+	}
+	permissions, err := ParseResources(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", consts.OwnerKindsEnvironmentVariable, err)
+	}
+	return permissions, nil
+}
@@ -0,0 +1,90 @@
+package ownerbridge
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Rule links a child resource to its parent through a label instead of an ownerReference.
+// A resource matching Kind (and APIVersion, if set) that carries Label is treated as owned by
+// the ParentKind resource whose name is the label value, in the same namespace.
+type Rule struct {
+	APIVersion       string `json:"apiVersion,omitempty"`
+	Kind             string `json:"kind"`
+	Label            string `json:"label"`
+	ParentAPIVersion string `json:"parentAPIVersion"`
+	ParentKind       string `json:"parentKind"`
+}
+
+type RulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Match returns the parent name if the rule applies to the given resource.
+func (r Rule) Match(res *unstructured.Unstructured) (string, bool) {
+	if res.GetKind() != r.Kind {
+		return "", false
+	}
+	if r.APIVersion != "" && res.GetAPIVersion() != r.APIVersion {
+		return "", false
+	}
+	parentName := res.GetLabels()[r.Label]
+	if parentName == "" {
+		return "", false
+	}
+	return parentName, true
+}
+
+func (r Rule) validate() error {
+	if r.Kind == "" {
+		return fmt.Errorf("kind is required")
+	}
+	if r.Label == "" {
+		return fmt.Errorf("label is required")
+	}
+	if r.ParentAPIVersion == "" || r.ParentKind == "" {
+		return fmt.Errorf("parentAPIVersion and parentKind are required")
+	}
+	return nil
+}
+
+// ParseRules decodes and validates bridge rules from YAML or JSON.
+func ParseRules(data []byte) ([]Rule, error) {
+	var rulesFile RulesFile
+	if err := yaml.UnmarshalStrict(data, &rulesFile); err != nil {
+		return nil, fmt.Errorf("failed to parse owner bridge rules: %w", err)
+	}
+	for i, rule := range rulesFile.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid owner bridge rule %d: %w", i, err)
+		}
+	}
+	return rulesFile.Rules, nil
+}
+
+// LoadRulesFile reads bridge rules from the file at path.
+// It returns no rules if path is empty. The rules are loaded at startup only, they are not part of the
+// runtime config.
+func LoadRulesFile(path string) ([]Rule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read owner bridge rules file %s: %w", path, err)
+	}
+	return ParseRules(data)
+}
+
+// FindParent returns the first rule matching the resource together with the parent name.
+func FindParent(rules []Rule, res *unstructured.Unstructured) (*Rule, string) {
+	for i := range rules {
+		if parentName, ok := rules[i].Match(res); ok {
+			return &rules[i], parentName
+		}
+	}
+	return nil, ""
+}
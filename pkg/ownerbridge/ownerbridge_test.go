@@ -0,0 +1,155 @@
+package ownerbridge
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseRules(t *testing.T) {
+	cronWorkflowRule := Rule{
+		APIVersion:       "argoproj.io/v1alpha1",
+		Kind:             "Workflow",
+		Label:            "workflows.argoproj.io/cron-workflow",
+		ParentAPIVersion: "argoproj.io/v1alpha1",
+		ParentKind:       "CronWorkflow",
+	}
+	tests := []struct {
+		name    string
+		data    string
+		want    []Rule
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			data: `
+rules:
+  - apiVersion: argoproj.io/v1alpha1
+    kind: Workflow
+    label: workflows.argoproj.io/cron-workflow
+    parentAPIVersion: argoproj.io/v1alpha1
+    parentKind: CronWorkflow
+`,
+			want: []Rule{cronWorkflowRule},
+		},
+		{
+			name: "json",
+			data: `{"rules": [{"apiVersion": "argoproj.io/v1alpha1", "kind": "Workflow", "label": "workflows.argoproj.io/cron-workflow", "parentAPIVersion": "argoproj.io/v1alpha1", "parentKind": "CronWorkflow"}]}`,
+			want: []Rule{cronWorkflowRule},
+		},
+		{
+			name: "apiVersion is optional",
+			data: "rules:\n  - kind: Job\n    label: app.example.com/batch\n    parentAPIVersion: example.com/v1\n    parentKind: Batch\n",
+			want: []Rule{{Kind: "Job", Label: "app.example.com/batch", ParentAPIVersion: "example.com/v1", ParentKind: "Batch"}},
+		},
+		{
+			name: "no rules",
+			data: "rules: []\n",
+			want: []Rule{},
+		},
+		{
+			name:    "missing kind",
+			data:    "rules:\n  - label: app.example.com/batch\n    parentAPIVersion: example.com/v1\n    parentKind: Batch\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing label",
+			data:    "rules:\n  - kind: Job\n    parentAPIVersion: example.com/v1\n    parentKind: Batch\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing parent",
+			data:    "rules:\n  - kind: Job\n    label: app.example.com/batch\n    parentKind: Batch\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			data:    "rules:\n  - kind: Job\n    label: app.example.com/batch\n    parentAPIVersion: example.com/v1\n    parentKind: Batch\n    parentName: nightly\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid yaml",
+			data:    "rules: [",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseRules([]byte(test.data))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("ParseRules() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRules() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseRules() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	rule := Rule{APIVersion: "argoproj.io/v1alpha1", Kind: "Workflow", Label: "workflows.argoproj.io/cron-workflow", ParentAPIVersion: "argoproj.io/v1alpha1", ParentKind: "CronWorkflow"}
+	resource := func(apiVersion, kind string, labels map[string]string) *unstructured.Unstructured {
+		res := &unstructured.Unstructured{}
+		res.SetAPIVersion(apiVersion)
+		res.SetKind(kind)
+		res.SetLabels(labels)
+		return res
+	}
+	tests := []struct {
+		name       string
+		rule       Rule
+		resource   *unstructured.Unstructured
+		wantParent string
+		wantMatch  bool
+	}{
+		{
+			name:       "matching resource",
+			rule:       rule,
+			resource:   resource("argoproj.io/v1alpha1", "Workflow", map[string]string{"workflows.argoproj.io/cron-workflow": "nightly"}),
+			wantParent: "nightly",
+			wantMatch:  true,
+		},
+		{
+			name:     "other kind",
+			rule:     rule,
+			resource: resource("argoproj.io/v1alpha1", "WorkflowTemplate", map[string]string{"workflows.argoproj.io/cron-workflow": "nightly"}),
+		},
+		{
+			name:     "other apiVersion",
+			rule:     rule,
+			resource: resource("argoproj.io/v1beta1", "Workflow", map[string]string{"workflows.argoproj.io/cron-workflow": "nightly"}),
+		},
+		{
+			name:       "any apiVersion",
+			rule:       Rule{Kind: "Workflow", Label: "workflows.argoproj.io/cron-workflow"},
+			resource:   resource("argoproj.io/v1beta1", "Workflow", map[string]string{"workflows.argoproj.io/cron-workflow": "nightly"}),
+			wantParent: "nightly",
+			wantMatch:  true,
+		},
+		{
+			name:     "missing label",
+			rule:     rule,
+			resource: resource("argoproj.io/v1alpha1", "Workflow", nil),
+		},
+		{
+			name:     "empty label",
+			rule:     rule,
+			resource: resource("argoproj.io/v1alpha1", "Workflow", map[string]string{"workflows.argoproj.io/cron-workflow": ""}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent, match := test.rule.Match(test.resource)
+			if parent != test.wantParent || match != test.wantMatch {
+				t.Errorf("Match() = %q, %v, want %q, %v", parent, match, test.wantParent, test.wantMatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package podtracking
+
+import (
+	"context"
+	"fmt"
+
+	"argocd-pod-enrichment/pkg/argocdhooks"
+	argocdtracking "argocd-pod-enrichment/pkg/argocdresourcetracking"
+	"argocd-pod-enrichment/pkg/argorollouts"
+	consts "argocd-pod-enrichment/pkg/consts/webhook"
+	"argocd-pod-enrichment/pkg/kubernetesclient"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ApplicationGetter fetches an ArgoCD Application
+type ApplicationGetter func(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error)
+
+// Resolution is the ArgoCD tracking of a pod and the metadata derived from its owner chain
+type Resolution struct {
+	Tracking *argocdtracking.ArgoCDTrackingInfo
+	// ExtraLabels and ExtraAnnotations hold the Argo Rollouts and hook metadata
+	ExtraLabels      map[string]string
+	ExtraAnnotations map[string]string
+	// Rollout and Hook are set when the pod belongs to a Rollout or a sync hook
+	Rollout *argorollouts.RolloutInfo
+	Hook    *argocdhooks.HookInfo
+	// RolloutError is set when the Rollout could not be read. The rollout name label is still set,
+	// only the role and step labels are missing.
+	RolloutError error
+	// HookApplicationError is set when the Application of a hook could not be read. The hook label is
+	// still set, only the sync operation labels are missing.
+	HookApplicationError error
+}
+
+// Options are the ArgoCD settings of the resolution
+type Options struct {
+	// ArgoCDNamespace is the namespace of the Applications whose tracking does not name one
+	ArgoCDNamespace string
+	// TrackingLabel is the label ArgoCD tracks resources with, the default tracking label if empty
+	TrackingLabel string
+}
+
+// Resolve walks the controller owner chain of the pod and extracts the ArgoCD tracking of its topmost owner.
+// It returns nil if the pod is not managed by ArgoCD. getApplication reads the Application of sync hooks.
+func Resolve(ctx context.Context, client *kubernetesclient.KubernetesClient, pod *unstructured.Unstructured, getApplication ApplicationGetter, opts Options) (*Resolution, error) {
+	ownerChain, err := client.GetControllerOwnerChain(pod)
+	if err != nil {
+		return nil, fmt.Errorf("error getting topmost controller owner: %w", err)
+	}
+	owner := ownerChain[len(ownerChain)-1]
+
+	tracking := argocdtracking.ExtractArgoCDTrackingInfo(*owner, opts.TrackingLabel)
+	if tracking == nil {
+		return nil, nil
+	}
+
+	resolution := &Resolution{
+		Tracking:         tracking,
+		ExtraLabels:      map[string]string{},
+		ExtraAnnotations: map[string]string{},
+	}
+
+	if rollout := argorollouts.FindRollout(ownerChain); rollout != nil {
+		// Owners are read as metadata only, the role and step come from the status of the Rollout
+		if fullRollout, err := client.GetResource(ctx, rollout); err != nil {
+			resolution.RolloutError = fmt.Errorf("error getting Rollout %s: %w", rollout.GetName(), err)
+		} else {
+			rollout = fullRollout
+		}
+		rolloutInfo := argorollouts.ExtractRolloutInfo(pod, rollout)
+		resolution.Rollout = rolloutInfo
+		resolution.ExtraLabels[consts.RolloutNameLabelKey] = rolloutInfo.RolloutName
+		if rolloutInfo.Role != "" {
+			resolution.ExtraLabels[consts.RolloutRoleLabelKey] = rolloutInfo.Role
+		}
+		if rolloutInfo.StepIndex != "" {
+			resolution.ExtraLabels[consts.RolloutStepIndexLabelKey] = rolloutInfo.StepIndex
+		}
+	}
+
+	if hook := argocdhooks.FindHook(ownerChain); hook != nil {
+		resolution.Hook = hook
+		resolution.ExtraLabels[consts.HookLabelKey] = hook.LabelValue()
+
+		appNamespace := tracking.ApplicationNamespace
+		if appNamespace == "" {
+			appNamespace = opts.ArgoCDNamespace
+		}
+		app, err := getApplication(ctx, appNamespace, tracking.ApplicationName)
+		if err != nil {
+			resolution.HookApplicationError = fmt.Errorf("error getting ArgoCD application %s/%s for hook: %w", appNamespace, tracking.ApplicationName, err)
+		} else if syncOperation := argocdhooks.ExtractSyncOperation(app); syncOperation != nil {
+			if syncOperation.Revision != "" && argocdhooks.IsValidLabelValue(syncOperation.Revision) {
+				resolution.ExtraLabels[consts.HookSyncRevisionLabelKey] = syncOperation.Revision
+			}
+			if syncOperation.StartedAt != "" {
+				resolution.ExtraAnnotations[consts.HookSyncStartedAtAnnotationKey] = syncOperation.StartedAt
+			}
+		}
+	}
+
+	return resolution, nil
+}
+
+// Labels returns every label the webhook sets on the pod: the tracking labels and the extra labels
+func (r *Resolution) Labels() map[string]string {
+	labels := map[string]string{consts.ApplicationLabelKey: r.Tracking.ApplicationName}
+	if r.Tracking.ApplicationNamespace != "" {
+		labels[consts.ApplicationNamespaceLabelKey] = r.Tracking.ApplicationNamespace
+	}
+	if r.Tracking.InstallationID != "" {
+		labels[consts.InstallationIDLabelKey] = r.Tracking.InstallationID
+	}
+	for key, value := range r.ExtraLabels {
+		labels[key] = value
+	}
+	return labels
+}
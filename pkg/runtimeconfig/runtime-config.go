@@ -0,0 +1,110 @@
+package runtimeconfig
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	consts "argocd-pod-enrichment/pkg/consts/runtimeconfig"
+	"argocd-pod-enrichment/pkg/enrichment"
+	"argocd-pod-enrichment/pkg/namespacescope"
+
+	"k8s.io/client-go/dynamic"
+)
+
+// Config is the part of the configuration that can change without restarting the webhook or the controller
+type Config struct {
+	// Enrichment selects the Application metadata copied to pods
+	Enrichment *enrichment.Config
+	// Scope narrows the namespaces whose pods are enriched within the namespaces the component started with,
+	// nil for no restriction
+	Scope *namespacescope.Scope
+	// Namespaces are the resolved namespaces of Scope, nil for every namespace
+	Namespaces []string
+}
+
+// Watches reports whether the pods of the namespace are enriched
+func (c *Config) Watches(namespace string) bool {
+	return c.Namespaces == nil || slices.Contains(c.Namespaces, namespace)
+}
+
+// Parse builds a config from the data of the runtime config ConfigMap. Keys missing from data keep the values
+// of base. Namespace selectors are resolved with client.
+func Parse(ctx context.Context, client dynamic.Interface, data map[string]string, base *Config) (*Config, error) {
+	config := *base
+
+	if value, ok := data[consts.EnrichmentConfigKey]; ok {
+		enrichmentConfig, err := enrichment.ParseConfig([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", consts.EnrichmentConfigKey, err)
+		}
+		config.Enrichment = enrichmentConfig
+	}
+
+	namespaces, hasNamespaces := data[consts.WatchNamespacesKey]
+	selector, hasSelector := data[consts.WatchNamespaceSelectorKey]
+	if hasNamespaces || hasSelector {
+		scope, err := namespacescope.Parse(namespaces, selector)
+		if err != nil {
+			return nil, fmt.Errorf("%s or %s: %w", consts.WatchNamespacesKey, consts.WatchNamespaceSelectorKey, err)
+		}
+		config.Scope, config.Namespaces = scope, nil
+		if scope != nil {
+			if config.Namespaces, err = scope.Resolve(ctx, client); err != nil {
+				return nil, fmt.Errorf("%s: %w", consts.WatchNamespaceSelectorKey, err)
+			}
+		}
+	}
+	return &config, nil
+}
+
+// Store holds the active config. The config is swapped atomically, readers get either the old or the new
+// config, never a mix of both.
+type Store struct {
+	startup *Config
+	current atomic.Pointer[Config]
+
+	mu          sync.Mutex
+	subscribers []chan struct{}
+}
+
+// NewStore returns a store whose active config is the config the component started with
+func NewStore(startup *Config) *Store {
+	store := &Store{startup: startup}
+	store.current.Store(startup)
+	return store
+}
+
+// Current returns the active config
+func (s *Store) Current() *Config {
+	return s.current.Load()
+}
+
+// Startup returns the config the component started with
+func (s *Store) Startup() *Config {
+	return s.startup
+}
+
+// Subscribe returns a channel notified whenever the active config changes
+func (s *Store) Subscribe() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscriber := make(chan struct{}, 1)
+	s.subscribers = append(s.subscribers, subscriber)
+	return subscriber
+}
+
+// Swap makes config the active config and notifies the subscribers
+func (s *Store) Swap(config *Config) {
+	s.current.Store(config)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, subscriber := range s.subscribers {
+		select {
+		case subscriber <- struct{}{}:
+		default:
+		}
+	}
+}
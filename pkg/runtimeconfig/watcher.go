@@ -0,0 +1,161 @@
+package runtimeconfig
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	consts "argocd-pod-enrichment/pkg/consts/runtimeconfig"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+var configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+var (
+	reloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "argocd_pod_enrichment_config_reloads_total",
+		Help: "Updates of the runtime config ConfigMap, by result: applied or rejected",
+	}, []string{"component", "result"})
+	lastReloadSuccessful = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "argocd_pod_enrichment_config_last_reload_successful",
+		Help: "Whether the last update of the runtime config ConfigMap was applied, 1, or rejected, 0",
+	}, []string{"component"})
+)
+
+// RegisterMetrics registers the reload metrics
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{reloadsTotal, lastReloadSuccessful} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseConfigMapReference parses the namespace/name of the runtime config ConfigMap
+func ParseConfigMapReference(value string) (string, string, error) {
+	namespace, name, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("invalid %s %q, expected namespace/name", consts.RuntimeConfigMapEnvironmentVariable, value)
+	}
+	return namespace, name, nil
+}
+
+// ConfigMapReference returns the namespace and name of the runtime config ConfigMap configured as
+// namespace/name, or empty strings if none is configured
+func ConfigMapReference(value string) (string, string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", "", nil
+	}
+	return ParseConfigMapReference(value)
+}
+
+// Watcher watches the runtime config ConfigMap and swaps the config of Store when it changes. Invalid
+// contents are rejected with a warning Event on the ConfigMap and the last valid config stays active.
+// Deleting the ConfigMap restores the config the component started with.
+type Watcher struct {
+	Client    dynamic.Interface
+	Namespace string
+	Name      string
+	Store     *Store
+	Recorder  record.EventRecorder
+	Log       logr.Logger
+	// Component labels the reload metrics
+	Component string
+
+	// lastSeen is the data of the last update handled, applied or rejected, and lastApplied the data of
+	// the active config. Both are nil until the ConfigMap is seen.
+	lastSeen    map[string]string
+	lastApplied map[string]string
+}
+
+// NeedLeaderElection is false, every replica follows the runtime config
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the ConfigMap until ctx is done
+func (w *Watcher) Start(ctx context.Context) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(w.Client, 0, w.Namespace, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.Name).String()
+	})
+	informer := factory.ForResource(configMapGVR).Informer()
+	// Handlers of an informer are called sequentially, the reloads never race
+	if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.reload(ctx, obj) },
+		UpdateFunc: func(_, obj interface{}) { w.reload(ctx, obj) },
+		DeleteFunc: func(interface{}) {
+			w.Log.Info("Runtime config ConfigMap deleted, restoring the startup config", "configMap", w.Namespace+"/"+w.Name)
+			w.lastSeen, w.lastApplied = nil, nil
+			w.Store.Swap(w.Store.Startup())
+		},
+	}); err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+	return nil
+}
+
+func (w *Watcher) reload(ctx context.Context, obj interface{}) {
+	configMap, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	data, _, err := unstructured.NestedStringMap(configMap.Object, "data")
+	if err != nil {
+		w.reject(configMap, err)
+		return
+	}
+	if data == nil {
+		data = map[string]string{}
+	}
+	// Metadata-only updates do not reload the config, nor reject again a rejected one
+	if w.lastSeen != nil && maps.Equal(data, w.lastSeen) {
+		return
+	}
+	w.lastSeen = data
+	// Reverting a rejected update restores the data of the active config, which stays in place
+	if w.lastApplied != nil && maps.Equal(data, w.lastApplied) {
+		w.applied(configMap)
+		return
+	}
+
+	config, err := Parse(ctx, w.Client, data, w.Store.Startup())
+	if err != nil {
+		w.reject(configMap, err)
+		return
+	}
+	w.lastApplied = data
+	w.Store.Swap(config)
+	w.applied(configMap)
+}
+
+func (w *Watcher) applied(configMap *unstructured.Unstructured) {
+	reloadsTotal.WithLabelValues(w.Component, "applied").Inc()
+	lastReloadSuccessful.WithLabelValues(w.Component).Set(1)
+	w.Log.Info("Runtime config applied", "configMap", w.Namespace+"/"+w.Name, "resourceVersion", configMap.GetResourceVersion())
+	w.Recorder.Eventf(configMap, corev1.EventTypeNormal, consts.ReasonConfigReloaded, "Runtime config applied by %s", w.Component)
+}
+
+func (w *Watcher) reject(configMap *unstructured.Unstructured, err error) {
+	reloadsTotal.WithLabelValues(w.Component, "rejected").Inc()
+	lastReloadSuccessful.WithLabelValues(w.Component).Set(0)
+	w.Log.Error(err, "Runtime config rejected, keeping the last valid config", "configMap", w.Namespace+"/"+w.Name, "resourceVersion", configMap.GetResourceVersion())
+	w.Recorder.Eventf(configMap, corev1.EventTypeWarning, consts.ReasonConfigRejected, "Runtime config rejected by %s, keeping the last valid config: %v", w.Component, err)
+}
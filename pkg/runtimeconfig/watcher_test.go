@@ -0,0 +1,209 @@
+package runtimeconfig
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	consts "argocd-pod-enrichment/pkg/consts/runtimeconfig"
+	"argocd-pod-enrichment/pkg/enrichment"
+
+	"github.com/go-logr/logr"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func configMap(resourceVersion string, data map[string]string) *unstructured.Unstructured {
+	configMap := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	configMap.SetAPIVersion("v1")
+	configMap.SetKind("ConfigMap")
+	configMap.SetNamespace("argocd-pod-enrichment")
+	configMap.SetName("runtime-config")
+	configMap.SetResourceVersion(resourceVersion)
+	if data != nil {
+		values := map[string]interface{}{}
+		for key, value := range data {
+			values[key] = value
+		}
+		configMap.Object["data"] = values
+	}
+	return configMap
+}
+
+// enrichmentData returns runtime config data copying the project of the Application to the label key
+func enrichmentData(key string) map[string]string {
+	return map[string]string{consts.EnrichmentConfigKey: "applicationFields:\n  - field: project\n    key: " + key + "\n"}
+}
+
+// activeKey returns the label key of the active enrichment config, empty for the startup config
+func activeKey(store *Store) string {
+	if fields := store.Current().Enrichment.ApplicationFields; len(fields) > 0 {
+		return fields[0].Key
+	}
+	return ""
+}
+
+func lastReloadGauge(t *testing.T, component string) float64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := lastReloadSuccessful.WithLabelValues(component).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	type step struct {
+		// data of the ConfigMap update
+		data map[string]string
+		// wantKey is the label key of the active config after the update
+		wantKey string
+		// wantEvents are the reasons of the Events recorded by the update
+		wantEvents []string
+		wantGauge  float64
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "valid updates are applied",
+			steps: []step{
+				{data: enrichmentData("project"), wantKey: "project", wantEvents: []string{consts.ReasonConfigReloaded}, wantGauge: 1},
+				{data: enrichmentData("team"), wantKey: "team", wantEvents: []string{consts.ReasonConfigReloaded}, wantGauge: 1},
+			},
+		},
+		{
+			name: "metadata-only update is ignored",
+			steps: []step{
+				{data: enrichmentData("project"), wantKey: "project", wantEvents: []string{consts.ReasonConfigReloaded}, wantGauge: 1},
+				{data: enrichmentData("project"), wantKey: "project", wantGauge: 1},
+			},
+		},
+		{
+			name: "invalid update keeps the last valid config and is rejected once",
+			steps: []step{
+				{data: enrichmentData("project"), wantKey: "project", wantEvents: []string{consts.ReasonConfigReloaded}, wantGauge: 1},
+				{data: enrichmentData("Invalid Key!"), wantKey: "project", wantEvents: []string{consts.ReasonConfigRejected}, wantGauge: 0},
+				{data: enrichmentData("Invalid Key!"), wantKey: "project", wantGauge: 0},
+			},
+		},
+		{
+			name: "reverting a rejected update reports the active config as applied",
+			steps: []step{
+				{data: enrichmentData("project"), wantKey: "project", wantEvents: []string{consts.ReasonConfigReloaded}, wantGauge: 1},
+				{data: enrichmentData("Invalid Key!"), wantKey: "project", wantEvents: []string{consts.ReasonConfigRejected}, wantGauge: 0},
+				{data: enrichmentData("project"), wantKey: "project", wantEvents: []string{consts.ReasonConfigReloaded}, wantGauge: 1},
+			},
+		},
+		{
+			name: "invalid first update keeps the startup config",
+			steps: []step{
+				{data: enrichmentData("Invalid Key!"), wantKey: "", wantEvents: []string{consts.ReasonConfigRejected}, wantGauge: 0},
+				{data: map[string]string{}, wantKey: "", wantEvents: []string{consts.ReasonConfigReloaded}, wantGauge: 1},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			store := NewStore(&Config{Enrichment: enrichment.DefaultConfig()})
+			w := &Watcher{Store: store, Recorder: recorder, Log: logr.Discard(), Component: "test"}
+
+			for i, step := range test.steps {
+				w.reload(context.Background(), configMap(string(rune('1'+i)), step.data))
+				if key := activeKey(store); key != step.wantKey {
+					t.Errorf("step %d: active config key = %q, want %q", i, key, step.wantKey)
+				}
+				events := drainEvents(recorder)
+				if len(events) != len(step.wantEvents) {
+					t.Fatalf("step %d: events = %v, want %v", i, events, step.wantEvents)
+				}
+				for j, reason := range step.wantEvents {
+					if !strings.Contains(events[j], " "+reason+" ") {
+						t.Errorf("step %d: event %q, want reason %s", i, events[j], reason)
+					}
+				}
+				if gauge := lastReloadGauge(t, "test"); gauge != step.wantGauge {
+					t.Errorf("step %d: last reload successful = %v, want %v", i, gauge, step.wantGauge)
+				}
+			}
+		})
+	}
+}
+
+func TestWatcherStart(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		configMapGVR: "ConfigMapList",
+	})
+	store := NewStore(&Config{Enrichment: enrichment.DefaultConfig()})
+	changes := store.Subscribe()
+	w := &Watcher{
+		Client:    client,
+		Namespace: "argocd-pod-enrichment",
+		Name:      "runtime-config",
+		Store:     store,
+		Recorder:  record.NewFakeRecorder(10),
+		Log:       logr.Discard(),
+		Component: "test",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- w.Start(ctx) }()
+
+	waitForChange := func(want string) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for activeKey(store) != want {
+			select {
+			case <-changes:
+			case <-deadline:
+				t.Fatalf("active config key = %q, want %q", activeKey(store), want)
+			}
+		}
+	}
+
+	resource := client.Resource(configMapGVR).Namespace("argocd-pod-enrichment")
+	// The informer may start after the ConfigMap is created, it then lists it
+	if _, err := resource.Create(ctx, configMap("", enrichmentData("project")), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange("project")
+
+	if _, err := resource.Update(ctx, configMap("", enrichmentData("team")), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange("team")
+
+	if err := resource.Delete(ctx, "runtime-config", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange("")
+	if store.Current() != store.Startup() {
+		t.Errorf("active config after deletion is not the startup config")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Start() error = %v", err)
+	}
+}